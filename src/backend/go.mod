@@ -3,11 +3,27 @@ module example.com/website_backend
 go 1.21.3
 
 require (
+	example.com/announcements v0.0.0-00010101000000-000000000000
+	example.com/attendance v0.0.0-00010101000000-000000000000
 	example.com/districts v0.0.0-00010101000000-000000000000
+	example.com/events v0.0.0-00010101000000-000000000000
+	example.com/giving v0.0.0-00010101000000-000000000000
 	example.com/groups v0.0.0-00010101000000-000000000000
+	example.com/households v0.0.0-00010101000000-000000000000
 	example.com/members v0.0.0-00010101000000-000000000000
+	example.com/memberships v0.0.0-00010101000000-000000000000
 	example.com/messages v0.0.0-00010101000000-000000000000
-	//example.com/users v0.0.0-00010101000000-000000000000
+	example.com/notifications v0.0.0-00010101000000-000000000000
+	example.com/photos v0.0.0-00010101000000-000000000000
+	example.com/reminders v0.0.0-00010101000000-000000000000
+	example.com/response v0.0.0-00010101000000-000000000000
+	example.com/roles v0.0.0-00010101000000-000000000000
+	example.com/sacraments v0.0.0-00010101000000-000000000000
+	example.com/scheduler v0.0.0-00010101000000-000000000000
+	example.com/sermons v0.0.0-00010101000000-000000000000
+	example.com/sms v0.0.0-00010101000000-000000000000
+	example.com/store v0.0.0-00010101000000-000000000000
+	example.com/users v0.0.0-00010101000000-000000000000
 	github.com/astaxie/beego v1.12.3
 	github.com/joho/godotenv v1.5.1
 )
@@ -31,9 +47,25 @@ require (
 )
 
 replace (
+	example.com/announcements => ./modules/announcements
+	example.com/attendance => ./modules/attendance
 	example.com/districts => ./modules/districts
+	example.com/events => ./modules/events
+	example.com/giving => ./modules/giving
 	example.com/groups => ./modules/groups
+	example.com/households => ./modules/households
 	example.com/members => ./modules/members
+	example.com/memberships => ./modules/memberships
 	example.com/messages => ./modules/messages
-//example.com/users => ./modules/users
+	example.com/notifications => ./modules/notifications
+	example.com/photos => ./modules/photos
+	example.com/reminders => ./modules/reminders
+	example.com/response => ./modules/response
+	example.com/roles => ./modules/roles
+	example.com/sacraments => ./modules/sacraments
+	example.com/scheduler => ./modules/scheduler
+	example.com/sermons => ./modules/sermons
+	example.com/sms => ./modules/sms
+	example.com/store => ./modules/store
+	example.com/users => ./modules/users
 )
@@ -3,17 +3,33 @@ package main
 import (
 	"context"
 	"crypto/tls"
-	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 
+	"example.com/announcements"
+	"example.com/attendance"
 	"example.com/districts"
+	"example.com/events"
+	"example.com/giving"
 	"example.com/groups"
+	"example.com/households"
 	"example.com/members"
+	"example.com/memberships"
 	"example.com/messages"
+	"example.com/notifications"
+	"example.com/photos"
+	"example.com/reminders"
+	"example.com/response"
+	"example.com/roles"
+	"example.com/sacraments"
+	"example.com/scheduler"
+	"example.com/sermons"
+	"example.com/sms"
+	"example.com/store"
+	"example.com/users"
 	"github.com/astaxie/beego/session"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
@@ -24,9 +40,12 @@ import (
 
 var (
 	globalSessions *session.Manager
+	userSessions   *session.Manager
 	tlsConfig      *tls.Config
 	db             *mongo.Database
 	m              *members.Members
+	rl             *roles.Roles
+	u              *users.Users
 )
 
 func init() {
@@ -43,33 +62,29 @@ func middleware(next http.Handler) http.Handler {
 		w.Header().Set("Access-Control-Allow-Origin", string(os.Getenv("Allow_Origin")))
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 		w.Header().Set("Access-Control-Allow-Methods", "POST,GET,PUT,DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, "+response.Header)
+		w.Header().Set("Access-Control-Expose-Headers", response.Header)
 		if r.Method == "OPTIONS" {
 			return
 		}
 		if !strings.EqualFold(r.URL.Path, "/login") {
-			c, err := r.Cookie(os.Getenv("Session_Cookie"))
-			cokval := "000"
-			if err == nil {
-				cokval = c.Value
-			}
+			caller, loggedin := m.Caller(r)
 			if strings.EqualFold(r.URL.Path, "/loggedin") {
-				store, err := globalSessions.GetProvider().SessionRead(cokval)
-				if err != nil {
-					res := struct{ Error string }{Error: err.Error()}
-					json.NewEncoder(w).Encode(res)
-					return
-				}
-				x, ok := store.Get("useremail").(string)
-				if !ok || len(x) == 0 {
-					x = ""
+				x := caller.Email
+				role := ""
+				if userrole, ok := rl.Get(m.RoleOf(x)); ok {
+					role = userrole.Name
 				}
-				res := fmt.Sprintf(`{"active":%t ,"useremail":%q}`, m.SuperUser(x), x)
-				json.NewEncoder(w).Encode(res)
+				response.OK(w, struct {
+					Active    bool   `json:"active"`
+					UserEmail string `json:"useremail"`
+					Role      string `json:"role"`
+					UserId    string `json:"userid"`
+				}{m.SuperUser(x), x, role, caller.Id})
 				return
 			}
-			if !globalSessions.GetProvider().SessionExist(cokval) {
-				w.WriteHeader(http.StatusForbidden)
+			if !loggedin && !rl.Allowed(roles.Guest, r.URL.Path, r.Method) {
+				response.Failf(w, http.StatusUnauthorized, "log in to continue")
 				return
 			}
 		}
@@ -77,6 +92,61 @@ func middleware(next http.Handler) http.Handler {
 
 	})
 }
+
+// authorize checks the caller's role against the permission table of the
+// requested resource. Callers without a valid session are treated as
+// guests.
+func authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role := roles.Guest
+		if caller, ok := m.Caller(r); ok {
+			role = m.RoleOf(caller.Email)
+		}
+		if !rl.Allowed(role, r.URL.Path, r.Method) {
+			response.Failf(w, http.StatusForbidden, "permission denied: %s %s", r.Method, r.URL.Path)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// promote turns a visitor account into a member record, carrying over the
+// name, email, passport and password hash.
+func promote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.Failf(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	var visitor struct{ Id string }
+	if !response.Decode(w, r, &visitor) {
+		return
+	}
+	user := u.Find(visitor.Id)
+	if user == nil {
+		response.Failf(w, http.StatusNotFound, "visitor account does not exist")
+		return
+	}
+	member := members.Member{Id: uuid.NewString(), Name: user.Name, Email: user.Email, Passport: user.Passport, Password: user.Password, Active: true, Role: roles.Member}
+	newmember, err := m.AddHashed(&member)
+	if err != nil {
+		response.Fail(w, err)
+		return
+	}
+	response.OK(w, newmember.Admin())
+}
+
+// integrity lists members pointing at districts or groups that no longer
+// exist.
+func integrity(d *districts.Districts, g *groups.Groups) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			response.Failf(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		response.OK(w, m.Orphans(d.Exists, g.Exists))
+	}
+}
+
 func EmptyHandler(w http.ResponseWriter, r *http.Request) {
 }
 
@@ -87,6 +157,11 @@ func main() {
 		log.Fatalf("Failed to create session manager")
 	}
 	go globalSessions.GC()
+	userSessions, err = session.NewManager("file", &session.ManagerConfig{CookieName: os.Getenv("User_Session_Cookie"), Gclifetime: 3600, ProviderConfig: "./tmp/users"})
+	if err != nil {
+		log.Fatalf("Failed to create visitor session manager")
+	}
+	go userSessions.GC()
 
 	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(os.Getenv("Mongo_Connect")))
 	if err != nil {
@@ -94,20 +169,21 @@ func main() {
 	}
 	defer client.Disconnect(context.TODO())
 	db = client.Database(os.Getenv("Database"))
-	m = members.NewMembers(db, globalSessions)
+	m = members.NewMembers(store.NewMongo[members.Member](db, members.MemberCollection, "Id"), store.NewMongo[members.Token](db, members.TokenCollection, "_id"), globalSessions)
+	rl = roles.NewRoles()
 	names, err := db.ListCollectionNames(context.TODO(), bson.D{})
 	if err != nil {
 		log.Fatal(err)
 	}
 	exists := false
 	for _, name := range names {
-		if name == "member" {
+		if name == members.MemberCollection {
 			exists = true
 		}
 	}
 	if !exists {
-		db.CreateCollection(context.TODO(), "member")
-		member := members.Member{Email: os.Getenv("DefaultEmail"), Password: os.Getenv("DefaultPassword"), Active: true, Id: uuid.NewString(), Role: 1}
+		db.CreateCollection(context.TODO(), members.MemberCollection)
+		member := members.Member{Email: os.Getenv("DefaultEmail"), Password: os.Getenv("DefaultPassword"), Active: true, Id: uuid.NewString(), Role: roles.Admin, Verified: true}
 		_, err = m.Add(&member)
 		if err != nil {
 			log.Fatal("Error initializing default user")
@@ -125,24 +201,112 @@ func main() {
 
 	router := http.NewServeMux()
 
-	router.Handle("/member", middleware(http.HandlerFunc(m.ServeHTTP)))
+	router.Handle("/member", middleware(authorize(http.HandlerFunc(m.ServeHTTP))))
 	router.Handle("/login", middleware(http.HandlerFunc(m.ServeHTTP)))
 	router.Handle("/logout", middleware(http.HandlerFunc(m.ServeHTTP)))
+	for _, path := range []string{"/me", "/me/password", "/me/deactivate", "/password/forgot", "/password/reset", "/verify", "/verify/resend"} {
+		router.Handle(path, middleware(authorize(http.HandlerFunc(m.ServeHTTP))))
+	}
+
+	g := groups.NewGroups(store.NewMongo[groups.Group](db, groups.GroupCollection, "Id"))
+	router.Handle("/group", middleware(authorize(http.HandlerFunc(g.ServeHTTP))))
+
+	d := districts.NewDistricts(store.NewMongo[districts.District](db, districts.DistrictCollection, "Id"))
+	router.Handle("/district", middleware(authorize(http.HandlerFunc(d.ServeHTTP))))
+
+	m.Lead(d, g)
+	d.Notify(m)
+
+	ms := memberships.NewMemberships(store.NewMongo[memberships.Membership](db, memberships.MembershipCollection, "Id"))
+	if err = m.Link(ms); err != nil {
+		log.Fatalf("Failed to migrate group memberships: %v", err)
+	}
+	g.Notify(ms)
+	ms.Check(m, g)
+	router.Handle("/membership", middleware(authorize(http.HandlerFunc(ms.ServeHTTP))))
+	h := households.NewHouseholds(store.NewMongo[households.Household](db, households.HouseholdCollection, "Id"), m)
+	d.Notify(h)
+	router.Handle("/household", middleware(authorize(http.HandlerFunc(h.ServeHTTP))))
+
+	sc := sacraments.NewSacraments(store.NewMongo[sacraments.Sacrament](db, sacraments.SacramentCollection, "Id"), store.NewMongoCounter(db, sacraments.CounterCollection), m)
+	router.Handle("/sacrament", middleware(authorize(http.HandlerFunc(sc.ServeHTTP))))
+	router.Handle("/sacrament/certificate", middleware(authorize(http.HandlerFunc(sc.ServeHTTP))))
+
+	at := attendance.NewAttendance(store.NewMongo[attendance.Session](db, attendance.SessionCollection, "Id"), store.NewMongo[attendance.Record](db, attendance.RecordCollection, "Id"), m)
+	for _, path := range []string{"/attendance", "/attendance/checkin", "/attendance/history", "/attendance/headcount", "/attendance/absent"} {
+		router.Handle(path, middleware(authorize(http.HandlerFunc(at.ServeHTTP))))
+	}
+
+	ev := events.NewEvents(store.NewMongo[events.Event](db, events.EventCollection, "Id"), store.NewMongo[events.RSVP](db, events.RSVPCollection, "Id"), m, d, g)
+	d.Notify(ev)
+	g.Notify(ev)
+	for _, path := range []string{"/event", "/event/upcoming", "/event/calendar.ics", "/event/rsvp", "/event/attendees.csv"} {
+		router.Handle(path, middleware(authorize(http.HandlerFunc(ev.ServeHTTP))))
+	}
+
+	an := announcements.NewAnnouncements(store.NewMongo[announcements.Announcement](db, announcements.AnnouncementCollection, "Id"), m, d, g)
+	d.Notify(an)
+	g.Notify(an)
+	for _, path := range []string{"/announcement", "/announcement/live", "/announcement/bulletin"} {
+		router.Handle(path, middleware(authorize(http.HandlerFunc(an.ServeHTTP))))
+	}
+
+	se := sermons.NewSermons(store.NewMongo[sermons.Sermon](db, sermons.SermonCollection, "Id"), m, os.Getenv("Media_Dir"))
+	for _, path := range []string{"/sermon", "/sermon/media", "/sermon/feed.rss"} {
+		router.Handle(path, middleware(authorize(http.HandlerFunc(se.ServeHTTP))))
+	}
+
+	gv := giving.NewGiving(store.NewMongo[giving.Entry](db, giving.EntryCollection, "Id"), store.NewMongo[giving.Change](db, giving.ChangeCollection, "Id"), m)
+	for _, path := range []string{"/giving", "/giving/statement", "/giving/totals", "/giving/log"} {
+		router.Handle(path, middleware(authorize(http.HandlerFunc(gv.ServeHTTP))))
+	}
+
+	ph := photos.NewPhotos(m, os.Getenv("Photo_Dir"))
+	router.Handle("/photo", middleware(authorize(http.HandlerFunc(ph.ServeHTTP))))
 
-	g := groups.NewGroups(db)
-	router.Handle("/group", middleware(http.HandlerFunc(g.ServeHTTP)))
+	router.Handle("/integrity", middleware(authorize(integrity(d, g))))
+
+	var sender notifications.Sender = &notifications.FileSender{Dir: os.Getenv("Mail_Dir"), From: os.Getenv("Mail_From")}
+	if len(os.Getenv("Mail_SMTP")) != 0 {
+		sender = &notifications.SMTPSender{Addr: os.Getenv("Mail_SMTP"), From: os.Getenv("Mail_From"), Username: os.Getenv("Mail_Username"), Password: os.Getenv("Mail_Password")}
+	}
+	nt := notifications.NewNotifications(store.NewMongo[notifications.Mail](db, notifications.OutboxCollection, "Id"), sender)
+	m.Mail(nt)
+	router.Handle("/outbox", middleware(authorize(http.HandlerFunc(nt.ServeHTTP))))
+
+	jobs := scheduler.NewScheduler(store.NewMongo[scheduler.JobRun](db, scheduler.RunCollection, "_id"), store.NewMongo[scheduler.Claim](db, scheduler.OnceCollection, "_id"))
+	for _, job := range reminders.NewReminders(m, d, sc, nt).Jobs() {
+		jobs.Add(job)
+	}
+	jobs.Start()
+	router.Handle("/job", middleware(authorize(http.HandlerFunc(jobs.ServeHTTP))))
+
+	var provider sms.Provider = &sms.FakeProvider{}
+	if len(os.Getenv("SMS_Gateway_URL")) != 0 {
+		provider = &sms.HTTPGateway{URL: os.Getenv("SMS_Gateway_URL"), Username: os.Getenv("SMS_Username"), APIKey: os.Getenv("SMS_API_Key"), From: os.Getenv("SMS_From")}
+	}
+	rate, _ := strconv.Atoi(os.Getenv("SMS_Rate"))
+	texts := sms.NewSMS(store.NewMongo[sms.Broadcast](db, sms.BroadcastCollection, "Id"), store.NewMongo[sms.Delivery](db, sms.DeliveryCollection, "Id"), m, d, g, provider, rate)
+	router.Handle("/sms", middleware(authorize(http.HandlerFunc(texts.ServeHTTP))))
+	router.Handle("/sms/report", middleware(authorize(http.HandlerFunc(texts.ServeHTTP))))
 
-	d := districts.NewDistricts(db)
-	router.Handle("/district", middleware(http.HandlerFunc(d.ServeHTTP)))
+	mes := messages.NewMessages(store.NewMongo[messages.Message](db, messages.MessageCollection, "Id"), m, nt)
+	router.Handle("/message", middleware(authorize(http.HandlerFunc(mes.ServeHTTP))))
+	router.Handle("/message/reply", middleware(authorize(http.HandlerFunc(mes.ServeHTTP))))
 
-	mes := messages.NewMessages(db)
-	router.Handle("/message", middleware(http.HandlerFunc(mes.ServeHTTP)))
+	u = users.NewUsers(store.NewMongo[users.User](db, users.UserCollection, "Id"), userSessions)
+	router.Handle("/user", middleware(authorize(http.HandlerFunc(u.ServeHTTP))))
+	router.Handle("/user/login", middleware(http.HandlerFunc(u.ServeHTTP)))
+	router.Handle("/user/logout", middleware(http.HandlerFunc(u.ServeHTTP)))
+	router.Handle("/user/me", middleware(authorize(http.HandlerFunc(u.ServeHTTP))))
+	router.Handle("/user/promote", middleware(authorize(http.HandlerFunc(promote))))
+	ev.Visitors(u)
 
 	router.Handle("/loggedin", middleware(http.HandlerFunc(EmptyHandler)))
 
 	server := &http.Server{
 		Addr:      os.Getenv("PORT"),
-		Handler:   router,
+		Handler:   response.RequestId(router),
 		TLSConfig: tlsConfig,
 	}
 	err = server.ListenAndServeTLS("", "")
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"example.com/members"
+	"example.com/memberships"
+	"example.com/roles"
+	"example.com/store"
+	"github.com/astaxie/beego/session"
+)
+
+// setup runs the globals on in-memory stores and sessions with an admin and
+// a member, and returns a session cookie for each.
+func setup(t *testing.T) (admin, member *http.Cookie) {
+	t.Helper()
+	var err error
+	globalSessions, err = session.NewManager("memory", &session.ManagerConfig{CookieName: os.Getenv("Session_Cookie"), Gclifetime: 3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = members.NewMembers(store.NewMemory[members.Member]("Id"), store.NewMemory[members.Token]("_id"), globalSessions)
+	rl = roles.NewRoles()
+	for _, record := range []members.Member{
+		{Id: "admin", Email: "admin@example.com", Role: roles.Admin},
+		{Id: "wambui", Email: "wambui@example.com", Role: roles.Member},
+	} {
+		record := record
+		record.Password, record.Active, record.Verified = "password", true, true
+		if _, err := m.Add(&record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return login(t, "admin@example.com"), login(t, "wambui@example.com")
+}
+
+func login(t *testing.T, email string) *http.Cookie {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	middleware(m).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"NameEmail":"`+email+`","Password":"password"}`)))
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == os.Getenv("Session_Cookie") {
+			return c
+		}
+	}
+	t.Fatalf("login %s = %d %s", email, rec.Code, rec.Body.String())
+	return nil
+}
+
+func serve(handler http.Handler, session *http.Cookie, method, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if session != nil {
+		req.AddCookie(session)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAuthorize(t *testing.T) {
+	admin, member := setup(t)
+	handler := middleware(authorize(m))
+	cases := []struct {
+		name    string
+		session *http.Cookie
+		method  string
+		want    int
+	}{
+		{"guest", nil, http.MethodGet, http.StatusUnauthorized},
+		{"member deleting", member, http.MethodDelete, http.StatusForbidden},
+		{"member listing", member, http.MethodGet, http.StatusOK},
+		{"admin listing", admin, http.MethodGet, http.StatusOK},
+		{"inactive admin listing", inactive(t, admin), http.MethodGet, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		if rec := serve(handler, c.session, c.method, "/member"); rec.Code != c.want {
+			t.Errorf("%s: %s /member = %d %s; want %d", c.name, c.method, rec.Code, rec.Body.String(), c.want)
+		}
+	}
+}
+
+// inactive logs in a second admin and has admin clear their Active flag,
+// returning the session kept from before.
+func inactive(t *testing.T, admin *http.Cookie) *http.Cookie {
+	t.Helper()
+	record := members.Member{Id: "njeri", Email: "njeri@example.com", Role: roles.Admin, Password: "password", Active: true, Verified: true}
+	if _, err := m.Add(&record); err != nil {
+		t.Fatal(err)
+	}
+	session := login(t, record.Email)
+	req := httptest.NewRequest(http.MethodPut, "/member", strings.NewReader(`{"Id":"njeri","Email":"njeri@example.com","Active":false}`))
+	req.AddCookie(admin)
+	rec := httptest.NewRecorder()
+	middleware(m).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || m.RoleOf(record.Email) != roles.Guest {
+		t.Fatalf("clearing Active = %d %s, role %d", rec.Code, rec.Body.String(), m.RoleOf(record.Email))
+	}
+	rec = httptest.NewRecorder()
+	middleware(m).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"NameEmail":"njeri@example.com","Password":"password"}`)))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("inactive login = %d; want 403", rec.Code)
+	}
+	return session
+}
+
+func TestLoggedIn(t *testing.T) {
+	admin, _ := setup(t)
+	var status struct {
+		UserEmail string `json:"useremail"`
+		Role      string `json:"role"`
+	}
+	rec := serve(middleware(http.HandlerFunc(EmptyHandler)), admin, http.MethodGet, "/loggedin")
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil || status.Role != "admin" || status.UserEmail != "admin@example.com" {
+		t.Fatalf("/loggedin = %s", rec.Body.String())
+	}
+}
+
+// TestMemberViewsWhileGroupsChange is meant for go test -race: member views
+// read group memberships while they are being changed.
+func TestMemberViewsWhileGroupsChange(t *testing.T) {
+	admin, member := setup(t)
+	ms := memberships.NewMemberships(store.NewMemory[memberships.Membership]("Id"))
+	if err := m.Link(ms); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 20; n++ {
+				ms.Sync("wambui", []string{"choir", []string{"youth", "men", "women"}[n%3]})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 20; n++ {
+				serve(m, admin, http.MethodGet, "/member")
+				serve(m, member, http.MethodGet, "/me")
+			}
+		}()
+	}
+	wg.Wait()
+	if groups := ms.GroupsOf("wambui"); len(groups) != 2 {
+		t.Fatalf("wambui belongs to %v; want choir and one more", groups)
+	}
+}
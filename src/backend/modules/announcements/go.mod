@@ -0,0 +1,33 @@
+module example.com/announcements
+
+go 1.21.3
+
+require (
+	example.com/members v0.0.0-00010101000000-000000000000
+	example.com/response v0.0.0-00010101000000-000000000000
+	example.com/roles v0.0.0-00010101000000-000000000000
+	example.com/store v0.0.0-00010101000000-000000000000
+	github.com/google/uuid v1.6.0
+)
+
+require (
+	github.com/astaxie/beego v1.12.3 // indirect
+	github.com/golang/snappy v0.0.4 // indirect
+	github.com/klauspost/compress v1.16.7 // indirect
+	github.com/montanaflynn/stats v0.7.1 // indirect
+	github.com/xdg-go/pbkdf2 v1.0.0 // indirect
+	github.com/xdg-go/scram v1.1.2 // indirect
+	github.com/xdg-go/stringprep v1.0.4 // indirect
+	github.com/youmark/pkcs8 v0.0.0-20240726163527-a2c0da244d78 // indirect
+	go.mongodb.org/mongo-driver v1.17.3 // indirect
+	golang.org/x/crypto v0.26.0 // indirect
+	golang.org/x/sync v0.8.0 // indirect
+	golang.org/x/text v0.17.0 // indirect
+)
+
+replace (
+	example.com/members => ../members
+	example.com/response => ../response
+	example.com/roles => ../roles
+	example.com/store => ../store
+)
@@ -0,0 +1,453 @@
+package announcements
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/members"
+	"example.com/response"
+	"example.com/roles"
+	"example.com/store"
+	"github.com/google/uuid"
+)
+
+// Announcement states. Authors write drafts and submit them for review; an
+// admin publishes or sends them back.
+const (
+	Draft     = "draft"
+	Review    = "review"
+	Published = "published"
+)
+
+const dateLayout = "2006-01-02"
+
+// Announcement is a notice for the congregation, or for one district or
+// group when District or Group is set. A published announcement shows from
+// its Publish date through its Expire date; an empty Expire never expires.
+type Announcement struct {
+	Id       string `bson:"Id"`
+	Title    string `bson:"Title"`
+	Body     string `bson:"Body"`
+	Status   string `bson:"Status"`
+	District string `bson:"District"`
+	Group    string `bson:"Group"`
+	Publish  string `bson:"Publish"`
+	Expire   string `bson:"Expire"`
+	Author   string `bson:"Author"`
+	Reviewer string `bson:"Reviewer"`
+}
+
+// Bulletin is the printable set of announcements running on a Sunday.
+type Bulletin struct {
+	Date          string
+	Announcements []Announcement
+}
+
+// Owners are the districts or groups an announcement can target.
+type Owners interface {
+	Exists(id string) bool
+	Led(memberId string) []string
+}
+
+type Announcements struct {
+	announcements *store.Cache[Announcement]
+	store         store.Store[Announcement]
+	members       *members.Members
+	districts     Owners
+	groups        Owners
+	// mutex serializes add, delete and update; reads use cache snapshots
+	mutex sync.Mutex
+}
+
+// AnnouncementCollection is where the Mongo store keeps announcements.
+const AnnouncementCollection = "announcement"
+
+func NewAnnouncements(records store.Store[Announcement], m *members.Members, districts, groups Owners) *Announcements {
+	announcements, err := records.All()
+	if err != nil {
+		log.Fatal("error loading announcements data " + err.Error())
+	}
+	cache := store.NewCache(announcements, func(announcement *Announcement) string { return announcement.Id })
+	cache.Follow("announcements", records)
+	return &Announcements{announcements: cache, store: records, members: m, districts: districts, groups: groups}
+}
+
+// DistrictReferences counts the announcements aimed at a district.
+func (announcements *Announcements) DistrictReferences(id string) int {
+	return announcements.references("District", id)
+}
+
+// DistrictMoved retargets the announcements of district from at district to.
+func (announcements *Announcements) DistrictMoved(from, to string) error {
+	return announcements.moved("District", from, to)
+}
+
+// GroupReferences counts the announcements aimed at a group.
+func (announcements *Announcements) GroupReferences(id string) int {
+	return announcements.references("Group", id)
+}
+
+// GroupMoved retargets the announcements of group from at group to.
+func (announcements *Announcements) GroupMoved(from, to string) error {
+	return announcements.moved("Group", from, to)
+}
+
+func audience(announcement *Announcement, field string) *string {
+	if field == "District" {
+		return &announcement.District
+	}
+	return &announcement.Group
+}
+
+func (announcements *Announcements) references(field, id string) int {
+	count := 0
+	for _, announcement := range announcements.announcements.All() {
+		if strings.EqualFold(*audience(announcement, field), id) {
+			count++
+		}
+	}
+	return count
+}
+
+func (announcements *Announcements) moved(field, from, to string) error {
+	announcements.mutex.Lock()
+	defer announcements.mutex.Unlock()
+	_, err := announcements.store.UpdateWhere(map[string]interface{}{field: from}, map[string]interface{}{field: to})
+	if err != nil {
+		return fmt.Errorf("error moving announcements %s", err)
+	}
+	for _, announcement := range announcements.announcements.All() {
+		if *audience(announcement, field) == from {
+			moved := *announcement
+			*audience(&moved, field) = to
+			announcements.announcements.Put(&moved)
+		}
+	}
+	return nil
+}
+
+func (announcements *Announcements) validate(announcement *Announcement) error {
+	if len(strings.TrimSpace(announcement.Title)) == 0 {
+		return response.Errorf(http.StatusBadRequest, "announcement title is required")
+	}
+	if _, err := time.Parse(dateLayout, announcement.Publish); err != nil {
+		return response.Errorf(http.StatusBadRequest, "publish date must look like %s", dateLayout)
+	}
+	if len(announcement.Expire) != 0 {
+		if _, err := time.Parse(dateLayout, announcement.Expire); err != nil {
+			return response.Errorf(http.StatusBadRequest, "expiry date must look like %s", dateLayout)
+		}
+		if announcement.Expire < announcement.Publish {
+			return response.Errorf(http.StatusBadRequest, "announcement cannot expire before it is published")
+		}
+	}
+	if len(announcement.District) != 0 && len(announcement.Group) != 0 {
+		return response.Errorf(http.StatusBadRequest, "an announcement targets a district or a group, not both")
+	}
+	if len(announcement.District) != 0 && !announcements.districts.Exists(announcement.District) {
+		return response.Errorf(http.StatusBadRequest, "district does not exists")
+	}
+	if len(announcement.Group) != 0 && !announcements.groups.Exists(announcement.Group) {
+		return response.Errorf(http.StatusBadRequest, "group does not exists")
+	}
+	return nil
+}
+
+// Running reports whether a published announcement shows on the given day.
+func (announcement *Announcement) Running(day string) bool {
+	return announcement.Status == Published && announcement.Publish <= day && (len(announcement.Expire) == 0 || day <= announcement.Expire)
+}
+
+// role resolves the caller and their role; visitors come back as guests.
+func (announcements *Announcements) role(r *http.Request) (members.Member, int) {
+	caller, ok := announcements.members.Caller(r)
+	if !ok {
+		return caller, roles.Guest
+	}
+	return caller, announcements.members.RoleOf(caller.Email)
+}
+
+// owns reports whether the caller may write announcements for the audience.
+// Admins write for anyone; district elders and group leaders only for the
+// districts and groups they lead.
+func (announcements *Announcements) owns(r *http.Request, announcement *Announcement) bool {
+	caller, role := announcements.role(r)
+	switch role {
+	case roles.Admin:
+		return true
+	case roles.DistrictElder:
+		return len(announcement.District) != 0 && contains(announcements.districts.Led(caller.Id), announcement.District)
+	case roles.GroupLeader:
+		return len(announcement.Group) != 0 && contains(announcements.groups.Led(caller.Id), announcement.Group)
+	}
+	return false
+}
+
+// transition checks a status change. Authors move drafts into review and
+// may pull them back; only admins publish, reject or unpublish.
+func (announcements *Announcements) transition(r *http.Request, from, to string) error {
+	if from == to {
+		return nil
+	}
+	_, role := announcements.role(r)
+	switch {
+	case from == Draft && to == Review, from == Review && to == Draft:
+		return nil
+	case role == roles.Admin && (to == Published || (from == Published && to == Draft)):
+		return nil
+	}
+	return response.Errorf(http.StatusConflict, "cannot move an announcement from %s to %s", from, to)
+}
+
+func contains(list []string, value string) bool {
+	for _, x := range list {
+		if strings.EqualFold(x, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (announcements *Announcements) add(newannouncement *Announcement) (*Announcement, error) {
+	if err := announcements.validate(newannouncement); err != nil {
+		return nil, err
+	}
+	announcements.mutex.Lock()
+	defer announcements.mutex.Unlock()
+	err := announcements.store.Insert(newannouncement)
+	if err != nil {
+		return nil, fmt.Errorf("error registering announcement")
+	}
+	saved := *newannouncement
+	announcements.announcements.Put(&saved)
+	return newannouncement, nil
+}
+
+func (announcements *Announcements) delete(oldannouncement *Announcement) (*Announcement, error) {
+	announcements.mutex.Lock()
+	defer announcements.mutex.Unlock()
+	if announcement := announcements.find(oldannouncement.Id); announcement != nil {
+		err := announcements.store.Delete(announcement.Id)
+		if err != nil {
+			return nil, fmt.Errorf("error deleting announcement")
+		}
+		announcements.announcements.Remove(announcement.Id)
+		return announcement, nil
+	}
+	return nil, response.Errorf(http.StatusNotFound, "announcement does not exists")
+}
+
+// update merges the given fields into an announcement. Author and Reviewer
+// are kept by the module, not the client.
+func (announcements *Announcements) update(update map[string]interface{}, reviewer string) (*Announcement, error) {
+	announcements.mutex.Lock()
+	defer announcements.mutex.Unlock()
+	announcement := announcements.find(fmt.Sprint(update["Id"]))
+	if announcement == nil {
+		return nil, response.Errorf(http.StatusNotFound, "announcement does not exists")
+	}
+	usr := *announcement
+	set, err := store.Apply(&usr, update, "Id", "Author", "Reviewer")
+	if err != nil {
+		return nil, err
+	}
+	if err := announcements.validate(&usr); err != nil {
+		return nil, err
+	}
+	if usr.Status != announcement.Status && usr.Status == Published {
+		usr.Reviewer = reviewer
+		set["Reviewer"] = reviewer
+	}
+	err = announcements.store.Update(usr.Id, set)
+	if err != nil {
+		return nil, fmt.Errorf("error updating announcement %s", err)
+	}
+	saved := usr
+	announcements.announcements.Put(&saved)
+	return &usr, nil
+}
+
+func (announcements *Announcements) find(id string) *Announcement {
+	return announcements.announcements.Get(id)
+}
+
+// Live lists the announcements running today that the caller is in the
+// audience of. Visitors only see those for the whole congregation.
+func (announcements *Announcements) Live(r *http.Request) []Announcement {
+	caller, role := announcements.role(r)
+	today := time.Now().Format(dateLayout)
+	result := make([]Announcement, 0)
+	for _, announcement := range announcements.announcements.All() {
+		if announcement.Running(today) && reaches(announcement, caller, role) {
+			result = append(result, *announcement)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Publish > result[j].Publish })
+	return result
+}
+
+// reaches reports whether caller is in the audience of announcement.
+// Admins are in every audience; guests only in the congregation wide one.
+func reaches(announcement *Announcement, caller members.Member, role int) bool {
+	if role == roles.Admin {
+		return true
+	}
+	if len(announcement.District) != 0 && !strings.EqualFold(announcement.District, caller.District) {
+		return false
+	}
+	if len(announcement.Group) != 0 && !contains(strings.Split(caller.Groups, ";"), announcement.Group) {
+		return false
+	}
+	return true
+}
+
+// Sunday returns the Sunday on or after day.
+func Sunday(day time.Time) time.Time {
+	return day.AddDate(0, 0, (7-int(day.Weekday()))%7)
+}
+
+// Bulletin assembles the announcements running on the given Sunday that
+// caller is in the audience of, congregation wide notices first. Admins get
+// every notice, visitors only the congregation wide ones.
+func (announcements *Announcements) Bulletin(sunday time.Time, caller members.Member, role int) Bulletin {
+	day := sunday.Format(dateLayout)
+	bulletin := Bulletin{Date: day, Announcements: make([]Announcement, 0)}
+	for _, announcement := range announcements.announcements.All() {
+		if announcement.Running(day) && reaches(announcement, caller, role) {
+			bulletin.Announcements = append(bulletin.Announcements, *announcement)
+		}
+	}
+	sort.SliceStable(bulletin.Announcements, func(i, j int) bool {
+		a, b := bulletin.Announcements[i], bulletin.Announcements[j]
+		if wide := len(a.District)+len(a.Group) == 0; wide != (len(b.District)+len(b.Group) == 0) {
+			return wide
+		}
+		return a.Publish > b.Publish
+	})
+	return bulletin
+}
+
+func (announcements *Announcements) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.URL.Path, "/announcement/live") {
+		response.OK(w, announcements.Live(r))
+		return
+	} else if strings.EqualFold(r.URL.Path, "/announcement/bulletin") {
+		day := time.Now()
+		if date := r.URL.Query().Get("date"); len(date) != 0 {
+			parsed, err := time.Parse(dateLayout, date)
+			if err != nil {
+				response.Failf(w, http.StatusBadRequest, "date must look like %s", dateLayout)
+				return
+			}
+			day = parsed
+		}
+		caller, role := announcements.role(r)
+		response.OK(w, announcements.Bulletin(Sunday(day), caller, role))
+		return
+	} else if strings.EqualFold(r.URL.Path, "/announcement") {
+		switch r.Method {
+		case http.MethodPost:
+			{
+				var newannouncement Announcement
+				if !response.Decode(w, r, &newannouncement) {
+					return
+				}
+				if !announcements.owns(r, &newannouncement) {
+					response.Failf(w, http.StatusForbidden, "you may only write announcements for the districts and groups you lead")
+					return
+				}
+				caller, _ := announcements.role(r)
+				newannouncement.Id = uuid.NewString()
+				newannouncement.Status = Draft
+				newannouncement.Author = caller.Id
+				newannouncement.Reviewer = ""
+				u, err := announcements.add(&newannouncement)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		case http.MethodGet:
+			{
+				status := r.URL.Query().Get("status")
+				result := make([]Announcement, 0)
+				for _, announcement := range announcements.announcements.All() {
+					if announcements.owns(r, announcement) && (len(status) == 0 || announcement.Status == status) {
+						result = append(result, *announcement)
+					}
+				}
+				response.OK(w, result)
+				return
+			}
+		case http.MethodDelete:
+			{
+				var oldannouncement Announcement
+				if !response.Decode(w, r, &oldannouncement) {
+					return
+				}
+				if announcement := announcements.find(oldannouncement.Id); announcement != nil {
+					if !announcements.owns(r, announcement) {
+						response.Failf(w, http.StatusForbidden, "you may only delete announcements for the districts and groups you lead")
+						return
+					}
+					if _, role := announcements.role(r); announcement.Status == Published && role != roles.Admin {
+						response.Failf(w, http.StatusForbidden, "only an admin may delete a published announcement")
+						return
+					}
+				}
+				u, err := announcements.delete(&oldannouncement)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		case http.MethodPut:
+			{
+				updateannouncement := make(map[string]interface{}, 0)
+				if !response.Decode(w, r, &updateannouncement) {
+					return
+				}
+				if announcement := announcements.find(fmt.Sprint(updateannouncement["Id"])); announcement != nil {
+					moved := *announcement
+					if district, ok := updateannouncement["District"].(string); ok {
+						moved.District = district
+					}
+					if group, ok := updateannouncement["Group"].(string); ok {
+						moved.Group = group
+					}
+					if !announcements.owns(r, announcement) || !announcements.owns(r, &moved) {
+						response.Failf(w, http.StatusForbidden, "you may only change announcements for the districts and groups you lead")
+						return
+					}
+					if _, role := announcements.role(r); announcement.Status == Published && role != roles.Admin {
+						response.Failf(w, http.StatusForbidden, "only an admin may change a published announcement")
+						return
+					}
+					if status, ok := updateannouncement["Status"].(string); ok {
+						if err := announcements.transition(r, announcement.Status, status); err != nil {
+							response.Fail(w, err)
+							return
+						}
+					}
+				}
+				caller, _ := announcements.role(r)
+				u, err := announcements.update(updateannouncement, caller.Id)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		}
+	}
+}
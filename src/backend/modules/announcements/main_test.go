@@ -0,0 +1,82 @@
+package announcements
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"example.com/members"
+	"example.com/members/memberstest"
+	"example.com/roles"
+	"example.com/store"
+)
+
+// owners is an Owners mapping a leader's Id to what they lead.
+type owners map[string][]string
+
+func (o owners) Led(memberId string) []string { return o[memberId] }
+func (o owners) Exists(id string) bool {
+	for _, led := range o {
+		for _, x := range led {
+			if x == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestBulletinAndReview(t *testing.T) {
+	m := memberstest.New(t,
+		members.Member{Id: "admin", Email: "admin@example.com", Role: roles.Admin},
+		members.Member{Id: "elder", Email: "elder@example.com", Role: roles.DistrictElder, District: "d1"},
+		members.Member{Id: "kamau", Email: "kamau@example.com", Role: roles.Member, District: "d2"},
+	)
+	districts := owners{"elder": {"d1"}}
+	m.Lead(memberstest.Leads(districts), memberstest.Leads{})
+	announcements := NewAnnouncements(store.NewMemory[Announcement]("Id"), m, districts, owners{})
+	admin := memberstest.Login(t, m, "admin@example.com")
+	elder := memberstest.Login(t, m, "elder@example.com")
+	today := time.Now().Format(dateLayout)
+
+	var local Announcement
+	if code := memberstest.Request(t, announcements, elder, http.MethodPost, "/announcement", `{"Title":"District prayer","District":"d1","Publish":"`+today+`"}`, &local); code != http.StatusOK {
+		t.Fatalf("POST /announcement = %d", code)
+	}
+	if code := memberstest.Request(t, announcements, elder, http.MethodPut, "/announcement", `{"Id":"`+local.Id+`","Status":"published"}`, nil); code != http.StatusConflict {
+		t.Fatalf("elder publishing = %d; want 409", code)
+	}
+	var wide Announcement
+	memberstest.Request(t, announcements, admin, http.MethodPost, "/announcement", `{"Title":"Harvest","Publish":"`+today+`"}`, &wide)
+	for _, id := range []string{local.Id, wide.Id} {
+		if code := memberstest.Request(t, announcements, admin, http.MethodPut, "/announcement", `{"Id":"`+id+`","Status":"published"}`, nil); code != http.StatusOK {
+			t.Fatalf("admin publishing = %d", code)
+		}
+	}
+
+	var bulletin Bulletin
+	memberstest.Request(t, announcements, nil, http.MethodGet, "/announcement/bulletin", "", &bulletin)
+	if len(bulletin.Announcements) != 1 || bulletin.Announcements[0].Id != wide.Id {
+		t.Fatalf("a visitor's bulletin holds %+v; want the congregation wide notice only", bulletin.Announcements)
+	}
+	memberstest.Request(t, announcements, elder, http.MethodGet, "/announcement/bulletin", "", &bulletin)
+	if len(bulletin.Announcements) != 2 {
+		t.Fatalf("a member's bulletin holds %d notices; want 2", len(bulletin.Announcements))
+	}
+	kamau := memberstest.Login(t, m, "kamau@example.com")
+	memberstest.Request(t, announcements, kamau, http.MethodGet, "/announcement/bulletin", "", &bulletin)
+	if len(bulletin.Announcements) != 1 || bulletin.Announcements[0].Id != wide.Id {
+		t.Fatalf("a d2 member's bulletin holds %+v; want the congregation wide notice only", bulletin.Announcements)
+	}
+	memberstest.Request(t, announcements, admin, http.MethodGet, "/announcement/bulletin", "", &bulletin)
+	if len(bulletin.Announcements) != 2 {
+		t.Fatalf("an admin's bulletin holds %d notices; want 2", len(bulletin.Announcements))
+	}
+
+	if code := memberstest.Request(t, announcements, elder, http.MethodDelete, "/announcement", `{"Id":"`+local.Id+`"}`, nil); code != http.StatusForbidden {
+		t.Fatalf("elder deleting a published announcement = %d; want 403", code)
+	}
+	if code := memberstest.Request(t, announcements, admin, http.MethodDelete, "/announcement", `{"Id":"`+local.Id+`"}`, nil); code != http.StatusOK {
+		t.Fatalf("admin deleting a published announcement = %d", code)
+	}
+}
@@ -0,0 +1,382 @@
+package attendance
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/members"
+	"example.com/response"
+	"example.com/roles"
+	"example.com/store"
+	"github.com/google/uuid"
+)
+
+// Kinds of sessions attendance is taken for. Ref names the group or
+// district for group and district meetings.
+const (
+	Service      = "service"
+	SundaySchool = "sundayschool"
+	Group        = "group"
+	District     = "district"
+)
+
+const dateLayout = "2006-01-02"
+
+type Session struct {
+	Id    string `bson:"Id"`
+	Kind  string `bson:"Kind"`
+	Ref   string `bson:"Ref"`
+	Title string `bson:"Title"`
+	Date  string `bson:"Date"`
+}
+
+type Record struct {
+	Id        string `bson:"Id"`
+	SessionId string `bson:"SessionId"`
+	MemberId  string `bson:"MemberId"`
+	CheckedIn string `bson:"CheckedIn"`
+	Usher     string `bson:"Usher"`
+}
+
+// Visit is one line of a member's attendance history.
+type Visit struct {
+	Session   Session
+	CheckedIn string
+}
+
+// Headcount is the number of members checked in to a session.
+type Headcount struct {
+	Session Session
+	Count   int
+}
+
+// Absentee is a member with no check-in during the period asked about.
+type Absentee struct {
+	Member   interface{}
+	LastSeen string
+}
+
+type Attendance struct {
+	sessions     *store.Cache[Session]
+	records      *store.Cache[Record]
+	sessionStore store.Store[Session]
+	recordStore  store.Store[Record]
+	members      *members.Members
+	// mutex serializes sessions and check-ins; reads use cache snapshots
+	mutex sync.Mutex
+}
+
+// Collections the Mongo stores keep sessions and check-ins in.
+const (
+	SessionCollection = "attendancesession"
+	RecordCollection  = "attendance"
+)
+
+func NewAttendance(sessionStore store.Store[Session], recordStore store.Store[Record], m *members.Members) *Attendance {
+	sessions, err := sessionStore.All()
+	if err != nil {
+		log.Fatal("error loading attendance sessions data " + err.Error())
+	}
+	records, err := recordStore.All()
+	if err != nil {
+		log.Fatal("error loading attendance data " + err.Error())
+	}
+	sessionCache := store.NewCache(sessions, func(session *Session) string { return session.Id })
+	sessionCache.Follow("attendance sessions", sessionStore)
+	recordCache := store.NewCache(records, func(record *Record) string { return record.Id })
+	recordCache.Follow("attendance", recordStore)
+	return &Attendance{sessions: sessionCache, records: recordCache, sessionStore: sessionStore, recordStore: recordStore, members: m}
+}
+
+func (attendance *Attendance) session(id string) *Session {
+	return attendance.sessions.Get(id)
+}
+
+func (attendance *Attendance) addSession(newsession *Session) (*Session, error) {
+	switch newsession.Kind {
+	case Service, SundaySchool, Group, District:
+	default:
+		return nil, response.Errorf(http.StatusBadRequest, "unknown session kind %s", newsession.Kind)
+	}
+	if len(newsession.Date) == 0 {
+		newsession.Date = time.Now().Format(dateLayout)
+	}
+	if _, err := time.Parse(dateLayout, newsession.Date); err != nil {
+		return nil, response.Errorf(http.StatusBadRequest, "session date must look like %s", dateLayout)
+	}
+	attendance.mutex.Lock()
+	defer attendance.mutex.Unlock()
+	err := attendance.sessionStore.Insert(newsession)
+	if err != nil {
+		return nil, fmt.Errorf("error registering session")
+	}
+	saved := *newsession
+	attendance.sessions.Put(&saved)
+	return newsession, nil
+}
+
+// deleteSession removes a session together with its check-ins.
+func (attendance *Attendance) deleteSession(oldsession *Session) (*Session, error) {
+	attendance.mutex.Lock()
+	defer attendance.mutex.Unlock()
+	if session := attendance.session(oldsession.Id); session != nil {
+		for _, record := range attendance.records.All() {
+			if record.SessionId == session.Id {
+				if err := attendance.recordStore.Delete(record.Id); err != nil {
+					return nil, fmt.Errorf("error deleting session attendance")
+				}
+				attendance.records.Remove(record.Id)
+			}
+		}
+		err := attendance.sessionStore.Delete(session.Id)
+		if err != nil {
+			return nil, fmt.Errorf("error deleting session")
+		}
+		attendance.sessions.Remove(session.Id)
+		return session, nil
+	}
+	return nil, response.Errorf(http.StatusNotFound, "session does not exists")
+}
+
+// checkin records a member as present. Checking in twice is harmless and
+// returns the first record. The Ids are stored as the session and member
+// records spell them, so a check-in typed in another case still counts once.
+func (attendance *Attendance) checkin(sessionId, memberId, usher string) (*Record, error) {
+	member, ok := attendance.members.Get(memberId)
+	if !ok {
+		return nil, response.Errorf(http.StatusBadRequest, "member does not exists")
+	}
+	attendance.mutex.Lock()
+	defer attendance.mutex.Unlock()
+	session := attendance.session(sessionId)
+	if session == nil {
+		return nil, response.Errorf(http.StatusNotFound, "session does not exists")
+	}
+	for _, record := range attendance.records.All() {
+		if strings.EqualFold(record.SessionId, session.Id) && strings.EqualFold(record.MemberId, member.Id) {
+			return record, nil
+		}
+	}
+	record := &Record{Id: uuid.NewString(), SessionId: session.Id, MemberId: member.Id, CheckedIn: time.Now().Format(time.RFC3339), Usher: usher}
+	err := attendance.recordStore.Insert(record)
+	if err != nil {
+		return nil, fmt.Errorf("error recording attendance")
+	}
+	saved := *record
+	attendance.records.Put(&saved)
+	return record, nil
+}
+
+func (attendance *Attendance) checkout(sessionId, memberId string) (*Record, error) {
+	attendance.mutex.Lock()
+	defer attendance.mutex.Unlock()
+	for _, record := range attendance.records.All() {
+		if strings.EqualFold(record.SessionId, sessionId) && strings.EqualFold(record.MemberId, memberId) {
+			err := attendance.recordStore.Delete(record.Id)
+			if err != nil {
+				return nil, fmt.Errorf("error removing attendance")
+			}
+			attendance.records.Remove(record.Id)
+			return record, nil
+		}
+	}
+	return nil, response.Errorf(http.StatusNotFound, "member was not checked in")
+}
+
+// History lists the sessions a member attended, newest first.
+func (attendance *Attendance) History(memberId string) []Visit {
+	result := make([]Visit, 0)
+	for _, record := range attendance.records.All() {
+		if !strings.EqualFold(record.MemberId, memberId) {
+			continue
+		}
+		if session := attendance.session(record.SessionId); session != nil {
+			result = append(result, Visit{Session: *session, CheckedIn: record.CheckedIn})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Session.Date > result[j].Session.Date })
+	return result
+}
+
+// Headcounts counts check-ins per session for sessions matching kind and
+// ref, newest first. Empty filters match everything.
+func (attendance *Attendance) Headcounts(kind, ref string) []Headcount {
+	counts := make(map[string]int)
+	for _, record := range attendance.records.All() {
+		counts[record.SessionId]++
+	}
+	result := make([]Headcount, 0)
+	for _, session := range attendance.sessions.All() {
+		if len(kind) != 0 && !strings.EqualFold(session.Kind, kind) {
+			continue
+		}
+		if len(ref) != 0 && !strings.EqualFold(session.Ref, ref) {
+			continue
+		}
+		result = append(result, Headcount{Session: *session, Count: counts[session.Id]})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Session.Date > result[j].Session.Date })
+	return result
+}
+
+// Absent lists active members who have not been checked in to any session
+// of kind (every kind when empty) in the last weeks weeks, each shown as
+// present would show them.
+func (attendance *Attendance) Absent(weeks int, kind string, allowed func(*members.Member) bool, present func(members.Member) interface{}) []Absentee {
+	since := time.Now().AddDate(0, 0, -7*weeks).Format(dateLayout)
+	last := make(map[string]string)
+	for _, record := range attendance.records.All() {
+		session := attendance.session(record.SessionId)
+		if session == nil || (len(kind) != 0 && !strings.EqualFold(session.Kind, kind)) {
+			continue
+		}
+		if session.Date > last[record.MemberId] {
+			last[record.MemberId] = session.Date
+		}
+	}
+	result := make([]Absentee, 0)
+	for _, member := range attendance.members.List() {
+		if !member.Active || !allowed(&member) {
+			continue
+		}
+		if seen := last[member.Id]; seen < since {
+			result = append(result, Absentee{Member: present(member), LastSeen: seen})
+		}
+	}
+	return result
+}
+
+// present shows found members as the caller on r may see them.
+func (attendance *Attendance) present(r *http.Request, found []members.Member) []interface{} {
+	view := attendance.members.Presenter(r)
+	result := make([]interface{}, 0, len(found))
+	for _, member := range found {
+		result = append(result, view(member))
+	}
+	return result
+}
+
+func (attendance *Attendance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	caller, _ := attendance.members.Caller(r)
+	if strings.EqualFold(r.URL.Path, "/attendance/checkin") {
+		switch r.Method {
+		case http.MethodGet:
+			{
+				response.OK(w, attendance.present(r, attendance.members.Search(r.URL.Query().Get("q"))))
+				return
+			}
+		case http.MethodPost:
+			{
+				var checkin struct {
+					SessionId string
+					MemberId  string
+					Name      string
+				}
+				if !response.Decode(w, r, &checkin) {
+					return
+				}
+				if len(checkin.MemberId) == 0 {
+					found := attendance.members.Search(checkin.Name)
+					if len(found) != 1 {
+						status, body := response.Describe(w, response.Errorf(http.StatusConflict, "%d members match %q, check in by Id", len(found), checkin.Name))
+						response.JSON(w, status, struct {
+							response.Body
+							Candidates []interface{}
+						}{body, attendance.present(r, found)})
+						return
+					}
+					checkin.MemberId = found[0].Id
+				}
+				u, err := attendance.checkin(checkin.SessionId, checkin.MemberId, caller.Id)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		case http.MethodDelete:
+			{
+				var record Record
+				if !response.Decode(w, r, &record) {
+					return
+				}
+				u, err := attendance.checkout(record.SessionId, record.MemberId)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		}
+	} else if strings.EqualFold(r.URL.Path, "/attendance/history") {
+		member := r.URL.Query().Get("member")
+		switch attendance.members.RoleOf(caller.Email) {
+		case roles.Admin:
+		case roles.DistrictElder, roles.GroupLeader:
+			target, ok := attendance.members.Get(member)
+			if !ok || !attendance.members.Scope(r)(&target) {
+				response.Failf(w, http.StatusForbidden, "member is outside your district or group")
+				return
+			}
+		default:
+			member = caller.Id
+		}
+		response.OK(w, attendance.History(member))
+		return
+	} else if strings.EqualFold(r.URL.Path, "/attendance/headcount") {
+		response.OK(w, attendance.Headcounts(r.URL.Query().Get("kind"), r.URL.Query().Get("ref")))
+		return
+	} else if strings.EqualFold(r.URL.Path, "/attendance/absent") {
+		weeks, err := strconv.Atoi(r.URL.Query().Get("weeks"))
+		if err != nil || weeks < 1 {
+			weeks = 4
+		}
+		response.OK(w, attendance.Absent(weeks, r.URL.Query().Get("kind"), attendance.members.Scope(r), attendance.members.Presenter(r)))
+		return
+	} else if strings.EqualFold(r.URL.Path, "/attendance") {
+		switch r.Method {
+		case http.MethodPost:
+			{
+				var newsession Session
+				if !response.Decode(w, r, &newsession) {
+					return
+				}
+				newsession.Id = uuid.NewString()
+				u, err := attendance.addSession(&newsession)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		case http.MethodGet:
+			{
+				response.OK(w, attendance.Headcounts(r.URL.Query().Get("kind"), r.URL.Query().Get("ref")))
+				return
+			}
+		case http.MethodDelete:
+			{
+				var oldsession Session
+				if !response.Decode(w, r, &oldsession) {
+					return
+				}
+				u, err := attendance.deleteSession(&oldsession)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		}
+	}
+}
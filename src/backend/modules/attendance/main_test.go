@@ -0,0 +1,74 @@
+package attendance
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"example.com/members"
+	"example.com/members/memberstest"
+	"example.com/roles"
+	"example.com/store"
+)
+
+func TestCheckinCountsOnce(t *testing.T) {
+	m := memberstest.New(t, members.Member{Id: "wanjiku", Email: "wanjiku@example.com", Role: roles.Member})
+	records := store.NewMemory[Record]("Id")
+	attendance := NewAttendance(store.NewMemory[Session]("Id"), records, m)
+	session, err := attendance.addSession(&Session{Id: "s1", Kind: Service})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := "wanjiku"
+			if i%2 == 0 {
+				id = strings.ToUpper(id)
+			}
+			if _, err := attendance.checkin(strings.ToUpper(session.Id), id, "usher"); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if all, _ := records.All(); len(all) != 1 || all[0].MemberId != "wanjiku" || all[0].SessionId != "s1" {
+		t.Fatalf("store holds %+v; want one check-in spelled as the records are", all)
+	}
+	if _, err := attendance.checkout("s1", "WANJIKU"); err != nil {
+		t.Fatalf("checkout in another case: %s", err)
+	}
+}
+
+func TestHistoryInScope(t *testing.T) {
+	m := memberstest.New(t,
+		members.Member{Id: "admin", Email: "admin@example.com", Role: roles.Admin},
+		members.Member{Id: "elder", Email: "elder@example.com", Role: roles.DistrictElder, District: "d1"},
+		members.Member{Id: "leader", Email: "leader@example.com", Role: roles.GroupLeader, Groups: "g1"},
+		members.Member{Id: "wanjiku", Email: "wanjiku@example.com", Role: roles.Member, District: "d1", Groups: "g1"},
+		members.Member{Id: "kamau", Email: "kamau@example.com", Role: roles.Member, District: "d2", Groups: "g2"},
+	)
+	m.Lead(memberstest.Leads{"elder": {"d1"}}, memberstest.Leads{"leader": {"g1"}})
+	attendance := NewAttendance(store.NewMemory[Session]("Id"), store.NewMemory[Record]("Id"), m)
+	for _, test := range []struct {
+		caller, member string
+		want           int
+	}{
+		{"admin", "kamau", http.StatusOK},
+		{"elder", "wanjiku", http.StatusOK},
+		{"elder", "kamau", http.StatusForbidden},
+		{"elder", "unknown", http.StatusForbidden},
+		{"leader", "wanjiku", http.StatusOK},
+		{"leader", "kamau", http.StatusForbidden},
+		{"wanjiku", "kamau", http.StatusOK},
+	} {
+		session := memberstest.Login(t, m, test.caller+"@example.com")
+		if code := memberstest.Request(t, attendance, session, http.MethodGet, "/attendance/history?member="+test.member, "", nil); code != test.want {
+			t.Errorf("%s reading the history of %s = %d; want %d", test.caller, test.member, code, test.want)
+		}
+	}
+}
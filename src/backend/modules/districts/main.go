@@ -1,17 +1,15 @@
 package districts
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
-	"reflect"
 	"strings"
+	"sync"
 
+	"example.com/response"
+	"example.com/store"
 	"github.com/google/uuid"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type District struct {
@@ -20,103 +18,166 @@ type District struct {
 	Email       string `bson:"Email"`
 	Description string `bson:"Description"`
 	Passport    string `bson:"Passport"`
+	Leaders     string `bson:"Leaders"`
+}
+
+// Dependent holds records that reference districts by Id.
+type Dependent interface {
+	DistrictReferences(id string) int
+	DistrictMoved(from, to string) error
 }
 
 type Districts struct {
-	districts []*District
-	db        *mongo.Database
+	districts  *store.Cache[District]
+	store      store.Store[District]
+	dependents []Dependent
+	// mutex serializes add, delete and update; reads use cache snapshots
+	mutex sync.Mutex
 }
 
-const districtCollection = "district"
+// DistrictCollection is where the Mongo store keeps districts.
+const DistrictCollection = "district"
 
-func NewDistricts(db *mongo.Database) *Districts {
-	districts := make([]*District, 0)
-	col := db.Collection(districtCollection)
-	result, err := col.Find(context.TODO(), bson.M{})
+func NewDistricts(records store.Store[District]) *Districts {
+	districts, err := records.All()
 	if err != nil {
-		log.Fatal(err.Error())
-	} else {
-		if err = result.All(context.TODO(), &districts); err != nil {
-			log.Fatal("error loading districts data " + err.Error())
+		log.Fatal("error loading districts data " + err.Error())
+	}
+	cache := store.NewCache(districts, func(district *District) string { return district.Id })
+	cache.Follow("districts", records)
+	return &Districts{districts: cache, store: records}
+}
+
+// Led returns the Ids of the districts whose Leaders list memberId.
+func (districts *Districts) Led(memberId string) []string {
+	led := make([]string, 0)
+	for _, district := range districts.districts.All() {
+		if leads(district.Leaders, memberId) {
+			led = append(led, district.Id)
 		}
 	}
-	return &Districts{districts: districts, db: db}
+	return led
 }
 
-func (districts *Districts) add(newdistrict *District) (*District, error) {
-	bsonData, err := bson.Marshal(newdistrict)
-	if err != nil {
-		return nil, fmt.Errorf("error processing district details")
+// leads reports whether memberId is in a semicolon separated Leaders list.
+func leads(leaders, memberId string) bool {
+	for _, leader := range strings.Split(leaders, ";") {
+		if len(leader) != 0 && strings.EqualFold(leader, memberId) {
+			return true
+		}
 	}
-	col := districts.db.Collection(districtCollection)
-	_, err = col.InsertOne(context.TODO(), bsonData)
+	return false
+}
+
+// Leaders maps each district Id to the member Ids in its Leaders list.
+func (districts *Districts) Leaders() map[string][]string {
+	leaders := make(map[string][]string)
+	for _, district := range districts.districts.All() {
+		for _, leader := range strings.Split(district.Leaders, ";") {
+			if leader = strings.TrimSpace(leader); len(leader) != 0 {
+				leaders[district.Id] = append(leaders[district.Id], leader)
+			}
+		}
+	}
+	return leaders
+}
+
+// Notify registers a dependent whose references are checked and moved when
+// districts are deleted or renamed.
+func (districts *Districts) Notify(dependent Dependent) {
+	districts.dependents = append(districts.dependents, dependent)
+}
+
+// Exists reports whether a district with the given Id is registered.
+func (districts *Districts) Exists(id string) bool {
+	for _, district := range districts.districts.All() {
+		if strings.EqualFold(district.Id, id) {
+			return true
+		}
+	}
+	return false
+}
+
+func (districts *Districts) add(newdistrict *District) (*District, error) {
+	districts.mutex.Lock()
+	defer districts.mutex.Unlock()
+	err := districts.store.Insert(newdistrict)
 	if err != nil {
 		return nil, fmt.Errorf("error registering user")
 	}
-	go func() {
-		districts.districts = append(districts.districts, newdistrict)
-	}()
+	saved := *newdistrict
+	districts.districts.Put(&saved)
 	return newdistrict, nil
 }
 
-func (districts *Districts) delete(olddistrict *District) (*District, error) {
-	for x, district := range districts.districts {
+// delete refuses to remove a district that members, households, events or
+// announcements still refer to unless reassign names another district to
+// move them to first.
+func (districts *Districts) delete(olddistrict *District, reassign string) (*District, error) {
+	districts.mutex.Lock()
+	defer districts.mutex.Unlock()
+	for _, district := range districts.districts.All() {
 		if strings.EqualFold(district.Id, olddistrict.Id) {
-			bsonData, err := bson.Marshal(olddistrict)
-			if err != nil {
-				return nil, fmt.Errorf("error processing district details")
+			references := 0
+			for _, dependent := range districts.dependents {
+				references += dependent.DistrictReferences(district.Id)
 			}
-			col := districts.db.Collection(districtCollection)
-			_, err = col.DeleteOne(context.TODO(), bsonData)
+			if references != 0 {
+				if len(reassign) == 0 {
+					return nil, response.Errorf(http.StatusConflict, "%d records such as members, households, events and announcements still refer to the district, choose a district to reassign them to", references)
+				}
+				if strings.EqualFold(reassign, district.Id) || !districts.Exists(reassign) {
+					return nil, response.Invalid("reassign district does not exists", map[string]string{"Reassign": "no such district"})
+				}
+				for _, dependent := range districts.dependents {
+					if err := dependent.DistrictMoved(district.Id, reassign); err != nil {
+						return nil, fmt.Errorf("error reassigning district members %s", err)
+					}
+				}
+			}
+			err := districts.store.Delete(district.Id)
 			if err != nil {
 				return nil, fmt.Errorf("error deleting user")
 			}
-			go func() {
-				districts.districts = append(districts.districts[:x], districts.districts[x+1:]...)
-			}()
+			districts.districts.Remove(district.Id)
 			return olddistrict, nil
 		}
 	}
-	return nil, fmt.Errorf("district account does not exists")
+	return nil, response.Errorf(http.StatusNotFound, "district account does not exists")
 }
 
+// update applies only the fields present in the request so that edits from
+// forms that do not carry every field leave the rest untouched.
 func (districts *Districts) update(update map[string]interface{}) (*District, error) {
-	var usr District
-	var fields []string
-	rv := reflect.ValueOf(usr)
-	for key, value := range update {
-		field := rv.FieldByName(key)
-		if field.IsValid() && field.CanSet() {
-			val := reflect.ValueOf(value)
-			if field.Type() == val.Type() {
-				field.Set(val)
-			} else {
-				return nil, fmt.Errorf("update field with type mismatch %v ", key)
+	districts.mutex.Lock()
+	defer districts.mutex.Unlock()
+	id, _ := update["Id"].(string)
+	for _, district := range districts.districts.All() {
+		if strings.EqualFold(district.Id, id) {
+			usr := *district
+			set, err := store.Apply(&usr, update)
+			if err != nil {
+				return nil, err
 			}
-			fields = append(fields, key)
-		}
-	}
-	req := 0
-	for i, v := range fields {
-		if strings.EqualFold(v, "Id") {
-			req += 1
-		}
-		if i == len(fields)-1 && req != 1 {
-			return nil, fmt.Errorf("update missing important fields")
-		}
-	}
-	for _, district := range districts.districts {
-		if strings.EqualFold(district.Id, usr.Id) {
-
-			col := districts.db.Collection(districtCollection)
-			_, err := col.UpdateByID(context.TODO(), usr.Id, usr)
+			err = districts.store.Update(usr.Id, set)
 			if err != nil {
-				return nil, fmt.Errorf("error updating district")
+				return nil, fmt.Errorf("error updating district %s", err)
 			}
+			// Members reference the Id, which a rename keeps. Records saved
+			// with the district name instead are moved onto the Id.
+			if !strings.EqualFold(district.Name, usr.Name) && len(district.Name) != 0 {
+				for _, dependent := range districts.dependents {
+					if err := dependent.DistrictMoved(district.Name, usr.Id); err != nil {
+						return nil, fmt.Errorf("district renamed but members were not updated %s", err)
+					}
+				}
+			}
+			saved := usr
+			districts.districts.Put(&saved)
 			return &usr, nil
 		}
 	}
-	return nil, fmt.Errorf("district account does not exists")
+	return nil, response.Errorf(http.StatusNotFound, "district account does not exists")
 }
 
 func (districts *Districts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -125,59 +186,67 @@ func (districts *Districts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		case http.MethodPost:
 			{
 				var newdistrict District
-				err := json.NewDecoder(r.Body).Decode(&newdistrict)
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
+				if !response.Decode(w, r, &newdistrict) {
 					return
 				}
 				newdistrict.Id = uuid.NewString()
 				u, err := districts.add(&newdistrict)
 				if err != nil {
-					json.NewEncoder(w).Encode(fmt.Sprintf("{error: %s}", err.Error()))
+					response.Fail(w, err)
 					return
 				}
-				json.NewEncoder(w).Encode(u)
+				response.OK(w, u)
 				return
 			}
 		case http.MethodGet:
 			{
+				query, err := store.ParseQuery(r.URL.Query(), "Name", "Email")
+				if err != nil {
+					response.Failf(w, http.StatusBadRequest, "%s", err)
+					return
+				}
+				leader := r.URL.Query().Get("leader")
 				result := make([]District, 0)
-				for _, m := range districts.districts {
-					result = append(result, *m)
+				for _, m := range districts.districts.All() {
+					if len(leader) != 0 && !leads(m.Leaders, leader) {
+						continue
+					}
+					if query.Matches(m.Name, m.Email, m.Description) {
+						result = append(result, *m)
+					}
 				}
-				json.NewEncoder(w).Encode(result)
+				response.OK(w, store.Paginate(result, query))
 				return
 			}
 		case http.MethodDelete:
 			{
-				var newdistrict District
-				err := json.NewDecoder(r.Body).Decode(&newdistrict)
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
-
+				var newdistrict struct {
+					District
+					Reassign string
 				}
-				u, err := districts.delete(&newdistrict)
+				if !response.Decode(w, r, &newdistrict) {
+					return
+				}
+				u, err := districts.delete(&newdistrict.District, newdistrict.Reassign)
 				if err != nil {
-					json.NewEncoder(w).Encode(fmt.Sprintf("{error: %s}", err.Error()))
+					response.Fail(w, err)
 					return
 				}
-				json.NewEncoder(w).Encode(u)
+				response.OK(w, u)
 				return
 			}
 		case http.MethodPut:
 			{
 				updatedistrict := make(map[string]interface{}, 0)
-				err := json.NewDecoder(r.Body).Decode(&updatedistrict)
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
+				if !response.Decode(w, r, &updatedistrict) {
 					return
 				}
 				u, err := districts.update(updatedistrict)
 				if err != nil {
-					json.NewEncoder(w).Encode(fmt.Sprintf("{error: %s}", err.Error()))
+					response.Fail(w, err)
 					return
 				}
-				json.NewEncoder(w).Encode(u)
+				response.OK(w, u)
 				return
 
 			}
@@ -0,0 +1,52 @@
+package districts
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"example.com/store"
+)
+
+// serve sends a JSON request to the handler and returns the reply.
+func serve(handler http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+type counter map[string]int
+
+func (c counter) DistrictReferences(id string) int { return c[id] }
+func (c counter) DistrictMoved(from, to string) error {
+	c[to] += c[from]
+	delete(c, from)
+	return nil
+}
+
+func TestDeleteCountsEveryReference(t *testing.T) {
+	records := store.NewMemory[District]("Id")
+	records.Insert(&District{Id: "d1", Name: "Kasarani"})
+	records.Insert(&District{Id: "d2", Name: "Ruaraka"})
+	districts := NewDistricts(records)
+	members, households := counter{"d1": 2}, counter{"d1": 1}
+	districts.Notify(members)
+	districts.Notify(households)
+
+	rec := serve(districts, http.MethodDelete, "/district", `{"Id":"d1"}`)
+	var body struct{ Error string }
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusConflict || !strings.HasPrefix(body.Error, "3 records") {
+		t.Fatalf("DELETE of a district in use = %d %q; want 409 counting 3 records", rec.Code, body.Error)
+	}
+	if rec := serve(districts, http.MethodDelete, "/district", `{"Id":"d1","Reassign":"d2"}`); rec.Code != http.StatusOK {
+		t.Fatalf("DELETE with Reassign = %d", rec.Code)
+	}
+	if members["d2"] != 2 || households["d2"] != 1 || districts.Exists("d1") {
+		t.Fatalf("references were not moved to d2: %v %v", members, households)
+	}
+}
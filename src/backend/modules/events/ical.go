@@ -0,0 +1,73 @@
+package events
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const icalTime = "20060102T150405Z"
+
+// icalText escapes a value for an iCalendar TEXT property.
+func icalText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// icalLine writes one content line, folding it at 75 octets as RFC 5545
+// asks.
+func icalLine(w io.Writer, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		io.WriteString(w, line[:cut]+"\r\n ")
+		line = line[cut:]
+		// continuation lines spend one octet on the leading space
+		limit = 74
+	}
+	io.WriteString(w, line+"\r\n")
+}
+
+// writeCalendar writes the events as an iCalendar feed. Repeating events
+// become an RRULE so calendar apps expand them themselves.
+func writeCalendar(w io.Writer, events []*Event) {
+	stamp := time.Now().UTC().Format(icalTime)
+	icalLine(w, "BEGIN:VCALENDAR")
+	icalLine(w, "VERSION:2.0")
+	icalLine(w, "PRODID:-//PCEA Elijah Wathika Memorial Church//Events//EN")
+	icalLine(w, "CALSCALE:GREGORIAN")
+	for _, event := range events {
+		start, err := time.ParseInLocation(timeLayout, event.Start, time.Local)
+		if err != nil {
+			continue
+		}
+		end, err := time.ParseInLocation(timeLayout, event.End, time.Local)
+		if err != nil {
+			continue
+		}
+		icalLine(w, "BEGIN:VEVENT")
+		icalLine(w, "UID:"+event.Id)
+		icalLine(w, "DTSTAMP:"+stamp)
+		icalLine(w, "DTSTART:"+start.UTC().Format(icalTime))
+		icalLine(w, "DTEND:"+end.UTC().Format(icalTime))
+		icalLine(w, "SUMMARY:"+icalText(event.Title))
+		if len(event.Description) != 0 {
+			icalLine(w, "DESCRIPTION:"+icalText(event.Description))
+		}
+		if len(event.Location) != 0 {
+			icalLine(w, "LOCATION:"+icalText(event.Location))
+		}
+		if len(event.Repeat) != 0 {
+			rule := "RRULE:FREQ=" + strings.ToUpper(event.Repeat)
+			if until, err := time.ParseInLocation(dateLayout, event.Until, time.Local); err == nil {
+				rule += fmt.Sprintf(";UNTIL=%s", until.AddDate(0, 0, 1).Add(-time.Second).UTC().Format(icalTime))
+			}
+			icalLine(w, rule)
+		}
+		icalLine(w, "END:VEVENT")
+	}
+	icalLine(w, "END:VCALENDAR")
+}
@@ -0,0 +1,492 @@
+package events
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/members"
+	"example.com/response"
+	"example.com/roles"
+	"example.com/store"
+	"example.com/users"
+	"github.com/google/uuid"
+)
+
+// Repeat rules an event may follow. An empty Repeat is a one off event.
+var repeats = []string{"", "daily", "weekly", "monthly"}
+
+const (
+	timeLayout = "2006-01-02T15:04"
+	dateLayout = "2006-01-02"
+)
+
+// maxDays caps how far ahead /event/upcoming looks.
+const maxDays = 366
+
+// Event is a calendar entry. Start and End are local times in the form the
+// browser datetime-local input sends; Until optionally ends a repeating
+// event. At most one of District or Group owns the event. A Capacity of zero
+// takes any number of RSVPs.
+type Event struct {
+	Id          string `bson:"Id"`
+	Title       string `bson:"Title"`
+	Description string `bson:"Description"`
+	Location    string `bson:"Location"`
+	Start       string `bson:"Start"`
+	End         string `bson:"End"`
+	Repeat      string `bson:"Repeat"`
+	Until       string `bson:"Until"`
+	District    string `bson:"District"`
+	Group       string `bson:"Group"`
+	Capacity    int    `bson:"Capacity"`
+}
+
+// Occurrence is one dated instance of an event.
+type Occurrence struct {
+	Event Event
+	Start string
+	End   string
+}
+
+// Owners are the districts or groups an event can belong to.
+type Owners interface {
+	Exists(id string) bool
+	Led(memberId string) []string
+}
+
+// Visitors recognises visitors logged in with their own account, see
+// users.Users.
+type Visitors interface {
+	Caller(r *http.Request) (users.UserView, bool)
+}
+
+type Events struct {
+	events    *store.Cache[Event]
+	rsvps     *store.Cache[RSVP]
+	store     store.Store[Event]
+	rsvpStore store.Store[RSVP]
+	members   *members.Members
+	districts Owners
+	groups    Owners
+	visitors  Visitors
+	// mutex serializes changes to events and RSVPs; reads use cache
+	// snapshots
+	mutex sync.Mutex
+}
+
+// EventCollection is where the Mongo store keeps events.
+const EventCollection = "event"
+
+func NewEvents(records store.Store[Event], rsvpStore store.Store[RSVP], m *members.Members, districts, groups Owners) *Events {
+	events, err := records.All()
+	if err != nil {
+		log.Fatal("error loading events data " + err.Error())
+	}
+	rsvps, err := rsvpStore.All()
+	if err != nil {
+		log.Fatal("error loading rsvp data " + err.Error())
+	}
+	eventCache := store.NewCache(events, func(event *Event) string { return event.Id })
+	eventCache.Follow("events", records)
+	rsvpCache := store.NewCache(rsvps, func(rsvp *RSVP) string { return rsvp.Id })
+	rsvpCache.Follow("rsvps", rsvpStore)
+	return &Events{events: eventCache, rsvps: rsvpCache, store: records, rsvpStore: rsvpStore, members: m, districts: districts, groups: groups}
+}
+
+// Visitors wires in the visitor accounts, so visitors who are logged in
+// register for events under their account.
+func (events *Events) Visitors(visitors Visitors) {
+	events.visitors = visitors
+}
+
+// DistrictReferences counts the events owned by a district.
+func (events *Events) DistrictReferences(id string) int {
+	return events.references("District", id)
+}
+
+// DistrictMoved hands the events of district from to district to.
+func (events *Events) DistrictMoved(from, to string) error {
+	return events.moved("District", from, to)
+}
+
+// GroupReferences counts the events owned by a group.
+func (events *Events) GroupReferences(id string) int {
+	return events.references("Group", id)
+}
+
+// GroupMoved hands the events of group from to group to.
+func (events *Events) GroupMoved(from, to string) error {
+	return events.moved("Group", from, to)
+}
+
+func owner(event *Event, field string) *string {
+	if field == "District" {
+		return &event.District
+	}
+	return &event.Group
+}
+
+func (events *Events) references(field, id string) int {
+	count := 0
+	for _, event := range events.events.All() {
+		if strings.EqualFold(*owner(event, field), id) {
+			count++
+		}
+	}
+	return count
+}
+
+func (events *Events) moved(field, from, to string) error {
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+	_, err := events.store.UpdateWhere(map[string]interface{}{field: from}, map[string]interface{}{field: to})
+	if err != nil {
+		return fmt.Errorf("error moving events %s", err)
+	}
+	for _, event := range events.events.All() {
+		if *owner(event, field) == from {
+			moved := *event
+			*owner(&moved, field) = to
+			events.events.Put(&moved)
+		}
+	}
+	return nil
+}
+
+func (events *Events) validate(event *Event) error {
+	event.Repeat = strings.ToLower(event.Repeat)
+	if len(strings.TrimSpace(event.Title)) == 0 {
+		return response.Errorf(http.StatusBadRequest, "event title is required")
+	}
+	start, err := time.ParseInLocation(timeLayout, event.Start, time.Local)
+	if err != nil {
+		return response.Errorf(http.StatusBadRequest, "start must look like %s", timeLayout)
+	}
+	end, err := time.ParseInLocation(timeLayout, event.End, time.Local)
+	if err != nil {
+		return response.Errorf(http.StatusBadRequest, "end must look like %s", timeLayout)
+	}
+	if end.Before(start) {
+		return response.Errorf(http.StatusBadRequest, "event cannot end before it starts")
+	}
+	found := false
+	for _, repeat := range repeats {
+		found = found || repeat == event.Repeat
+	}
+	if !found {
+		return response.Errorf(http.StatusBadRequest, "repeat must be one of daily, weekly, monthly or empty")
+	}
+	if len(event.Until) != 0 {
+		if _, err := time.Parse(dateLayout, event.Until); err != nil {
+			return response.Errorf(http.StatusBadRequest, "until must look like %s", dateLayout)
+		}
+	}
+	if len(event.District) != 0 && len(event.Group) != 0 {
+		return response.Errorf(http.StatusBadRequest, "an event belongs to a district or a group, not both")
+	}
+	if len(event.District) != 0 && !events.districts.Exists(event.District) {
+		return response.Errorf(http.StatusBadRequest, "district does not exists")
+	}
+	if len(event.Group) != 0 && !events.groups.Exists(event.Group) {
+		return response.Errorf(http.StatusBadRequest, "group does not exists")
+	}
+	if event.Capacity < 0 {
+		return response.Errorf(http.StatusBadRequest, "capacity cannot be negative")
+	}
+	return nil
+}
+
+// allowed reports whether the caller may change an event. Admins change any
+// event; district elders and group leaders only those their district or
+// group owns.
+func (events *Events) allowed(r *http.Request, event *Event) bool {
+	caller, ok := events.members.Caller(r)
+	if !ok {
+		return false
+	}
+	switch events.members.RoleOf(caller.Email) {
+	case roles.Admin:
+		return true
+	case roles.DistrictElder:
+		return len(event.District) != 0 && contains(events.districts.Led(caller.Id), event.District)
+	case roles.GroupLeader:
+		return len(event.Group) != 0 && contains(events.groups.Led(caller.Id), event.Group)
+	}
+	return false
+}
+
+func contains(list []string, value string) bool {
+	for _, x := range list {
+		if strings.EqualFold(x, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (events *Events) add(newevent *Event) (*Event, error) {
+	if err := events.validate(newevent); err != nil {
+		return nil, err
+	}
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+	err := events.store.Insert(newevent)
+	if err != nil {
+		return nil, fmt.Errorf("error registering event")
+	}
+	saved := *newevent
+	events.events.Put(&saved)
+	return newevent, nil
+}
+
+func (events *Events) delete(oldevent *Event) (*Event, error) {
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+	if event := events.find(oldevent.Id); event != nil {
+		err := events.store.Delete(event.Id)
+		if err != nil {
+			return nil, fmt.Errorf("error deleting event")
+		}
+		events.events.Remove(event.Id)
+		if err := events.dropRSVPs(event.Id); err != nil {
+			return nil, err
+		}
+		return event, nil
+	}
+	return nil, response.Errorf(http.StatusNotFound, "event does not exists")
+}
+
+func (events *Events) update(update map[string]interface{}) (*Event, error) {
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+	event := events.find(fmt.Sprint(update["Id"]))
+	if event == nil {
+		return nil, response.Errorf(http.StatusNotFound, "event does not exists")
+	}
+	usr := *event
+	set, err := store.Apply(&usr, update, "Id")
+	if err != nil {
+		return nil, err
+	}
+	if err := events.validate(&usr); err != nil {
+		return nil, err
+	}
+	set["Repeat"] = usr.Repeat
+	err = events.store.Update(usr.Id, set)
+	if err != nil {
+		return nil, fmt.Errorf("error updating event %s", err)
+	}
+	saved := usr
+	events.events.Put(&saved)
+	if err := events.promote(&saved); err != nil {
+		return nil, err
+	}
+	return &usr, nil
+}
+
+func (events *Events) find(id string) *Event {
+	return events.events.Get(id)
+}
+
+// occurrence returns the nth instance of an event first held at start, and
+// whether it falls on a real date. Instances are counted from the first, as
+// RRULE does, so a monthly event on the 31st skips the shorter months
+// instead of drifting to an earlier day.
+func occurrence(start time.Time, repeat string, n int) (time.Time, bool) {
+	switch repeat {
+	case "daily":
+		return start.AddDate(0, 0, n), true
+	case "weekly":
+		return start.AddDate(0, 0, 7*n), true
+	default:
+		t := start.AddDate(0, n, 0)
+		return t, t.Day() == start.Day()
+	}
+}
+
+// skip returns how many instances of an event first held at start surely
+// begin before from, so expanding it need not count up from the first. It
+// stays one period short to allow for daylight saving shifts.
+func skip(start time.Time, repeat string, from time.Time) int {
+	if !from.After(start) {
+		return 0
+	}
+	var n int
+	switch repeat {
+	case "daily":
+		n = int(from.Sub(start) / (24 * time.Hour))
+	case "weekly":
+		n = int(from.Sub(start) / (7 * 24 * time.Hour))
+	case "monthly":
+		n = (from.Year()-start.Year())*12 + int(from.Month()) - int(start.Month())
+	}
+	return max(n-1, 0)
+}
+
+// Occurrences expands every event into the instances starting within
+// [from, to), ordered by start.
+func (events *Events) Occurrences(from, to time.Time) []Occurrence {
+	result := make([]Occurrence, 0)
+	for _, event := range events.events.All() {
+		start, err := time.ParseInLocation(timeLayout, event.Start, time.Local)
+		if err != nil {
+			continue
+		}
+		end, err := time.ParseInLocation(timeLayout, event.End, time.Local)
+		if err != nil {
+			continue
+		}
+		length := end.Sub(start)
+		last := to
+		if until, err := time.ParseInLocation(dateLayout, event.Until, time.Local); err == nil && until.AddDate(0, 0, 1).Before(last) {
+			last = until.AddDate(0, 0, 1)
+		}
+		for n := skip(start, event.Repeat, from); ; n++ {
+			t, ok := occurrence(start, event.Repeat, n)
+			if !t.Before(last) {
+				break
+			}
+			if ok && !t.Before(from) {
+				result = append(result, Occurrence{Event: *event, Start: t.Format(timeLayout), End: t.Add(length).Format(timeLayout)})
+			}
+			if len(event.Repeat) == 0 {
+				break
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Start < result[j].Start })
+	return result
+}
+
+// filter keeps the events owned by the district or group asked for, or all
+// of them when neither is given.
+func filter(r *http.Request, event *Event) bool {
+	district, group := r.URL.Query().Get("district"), r.URL.Query().Get("group")
+	if len(district) != 0 && !strings.EqualFold(event.District, district) {
+		return false
+	}
+	if len(group) != 0 && !strings.EqualFold(event.Group, group) {
+		return false
+	}
+	return true
+}
+
+func (events *Events) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.URL.Path, "/event/upcoming") {
+		days, err := strconv.Atoi(r.URL.Query().Get("days"))
+		if err != nil || days <= 0 {
+			days = 30
+		}
+		days = min(days, maxDays)
+		now := time.Now()
+		result := make([]Occurrence, 0)
+		for _, occurrence := range events.Occurrences(now, now.AddDate(0, 0, days)) {
+			if filter(r, &occurrence.Event) {
+				result = append(result, occurrence)
+			}
+		}
+		response.OK(w, result)
+		return
+	} else if strings.EqualFold(r.URL.Path, "/event/calendar.ics") {
+		selected := make([]*Event, 0)
+		for _, event := range events.events.All() {
+			if filter(r, event) {
+				selected = append(selected, event)
+			}
+		}
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("Content-Disposition", `inline; filename="calendar.ics"`)
+		writeCalendar(w, selected)
+		return
+	} else if strings.EqualFold(r.URL.Path, "/event/rsvp") {
+		events.serveRSVP(w, r)
+		return
+	} else if strings.EqualFold(r.URL.Path, "/event/attendees.csv") {
+		events.serveAttendees(w, r)
+		return
+	} else if strings.EqualFold(r.URL.Path, "/event") {
+		switch r.Method {
+		case http.MethodPost:
+			{
+				var newevent Event
+				if !response.Decode(w, r, &newevent) {
+					return
+				}
+				if !events.allowed(r, &newevent) {
+					response.Failf(w, http.StatusForbidden, "only the owning district or group may add this event")
+					return
+				}
+				newevent.Id = uuid.NewString()
+				u, err := events.add(&newevent)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		case http.MethodGet:
+			{
+				result := make([]Event, 0)
+				for _, event := range events.events.All() {
+					if filter(r, event) {
+						result = append(result, *event)
+					}
+				}
+				response.OK(w, result)
+				return
+			}
+		case http.MethodDelete:
+			{
+				var oldevent Event
+				if !response.Decode(w, r, &oldevent) {
+					return
+				}
+				if event := events.find(oldevent.Id); event != nil && !events.allowed(r, event) {
+					response.Failf(w, http.StatusForbidden, "only the owning district or group may delete this event")
+					return
+				}
+				u, err := events.delete(&oldevent)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		case http.MethodPut:
+			{
+				updateevent := make(map[string]interface{}, 0)
+				if !response.Decode(w, r, &updateevent) {
+					return
+				}
+				if event := events.find(fmt.Sprint(updateevent["Id"])); event != nil {
+					moved := *event
+					if district, ok := updateevent["District"].(string); ok {
+						moved.District = district
+					}
+					if group, ok := updateevent["Group"].(string); ok {
+						moved.Group = group
+					}
+					if !events.allowed(r, event) || !events.allowed(r, &moved) {
+						response.Failf(w, http.StatusForbidden, "only the owning district or group may change this event")
+						return
+					}
+				}
+				u, err := events.update(updateevent)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package events
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/members"
+	"example.com/members/memberstest"
+	"example.com/roles"
+	"example.com/store"
+)
+
+// owners is a district or group list for events to belong to.
+type owners map[string][]string
+
+func (o owners) Exists(id string) bool { _, ok := o[id]; return ok }
+func (o owners) Led(memberId string) []string {
+	led := make([]string, 0)
+	for id, leaders := range o {
+		if contains(leaders, memberId) {
+			led = append(led, id)
+		}
+	}
+	return led
+}
+
+func newEvents(t *testing.T) (*Events, *http.Cookie) {
+	t.Helper()
+	m := memberstest.New(t, members.Member{Id: "admin", Email: "admin@example.com", Role: roles.Admin})
+	events := NewEvents(store.NewMemory[Event]("Id"), store.NewMemory[RSVP]("Id"), m, owners{"d1": {"elder"}}, owners{})
+	return events, memberstest.Login(t, m, "admin@example.com")
+}
+
+// TestEventsConcurrent is meant for go test -race: visitors register while
+// lists and calendars are read and events are deleted.
+func TestEventsConcurrent(t *testing.T) {
+	events, admin := newEvents(t)
+	ids := make([]string, 4)
+	for i := range ids {
+		var event Event
+		body := fmt.Sprintf(`{"Title":"Choir practice %d","Start":"2030-01-0%dT18:00","End":"2030-01-0%dT20:00","Repeat":"weekly"}`, i, i+1, i+1)
+		if code := memberstest.Request(t, events, admin, http.MethodPost, "/event", body, &event); code != http.StatusOK {
+			t.Fatalf("POST /event = %d", code)
+		}
+		ids[i] = event.Id
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < 10; n++ {
+				body := fmt.Sprintf(`{"EventId":"%s","Name":"Visitor","Email":"v%d-%d@example.com"}`, ids[n%len(ids)], i, n)
+				memberstest.Request(t, events, nil, http.MethodPost, "/event/rsvp", body, nil)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 10; n++ {
+				memberstest.Request(t, events, nil, http.MethodGet, "/event/upcoming?days=30", "", nil)
+				memberstest.Request(t, events, admin, http.MethodGet, "/event/rsvp?event="+ids[0], "", nil)
+				memberstest.Request(t, events, admin, http.MethodGet, "/event/calendar.ics", "", nil)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		memberstest.Request(t, events, admin, http.MethodDelete, "/event", `{"Id":"`+ids[3]+`"}`, nil)
+	}()
+	wg.Wait()
+	for _, rsvp := range events.rsvps.All() {
+		if rsvp.EventId == ids[3] {
+			t.Fatalf("an RSVP outlived its deleted event: %+v", rsvp)
+		}
+	}
+}
+
+func TestMonthlyOccurrencesKeepTheirDay(t *testing.T) {
+	events, _ := newEvents(t)
+	events.events.Put(&Event{Id: "e1", Title: "Elders' meeting", Start: "2031-01-31T18:00", End: "2031-01-31T20:00", Repeat: "monthly"})
+	from := time.Date(2031, 1, 1, 0, 0, 0, 0, time.Local)
+	starts := make([]string, 0)
+	for _, occurrence := range events.Occurrences(from, from.AddDate(0, 6, 0)) {
+		starts = append(starts, occurrence.Start)
+	}
+	want := []string{"2031-01-31T18:00", "2031-03-31T18:00", "2031-05-31T18:00"}
+	if fmt.Sprint(starts) != fmt.Sprint(want) {
+		t.Fatalf("monthly event on the 31st falls on %v; want %v", starts, want)
+	}
+}
+
+func TestSkipStopsJustShortOfFrom(t *testing.T) {
+	from := time.Date(2031, 3, 30, 12, 0, 0, 0, time.Local)
+	for _, start := range []time.Time{
+		time.Date(1950, 1, 31, 6, 0, 0, 0, time.Local),
+		time.Date(2030, 10, 27, 23, 30, 0, 0, time.Local),
+		time.Date(2031, 3, 30, 11, 0, 0, 0, time.Local),
+	} {
+		for _, repeat := range []string{"daily", "weekly", "monthly"} {
+			n := skip(start, repeat, from)
+			if t1, _ := occurrence(start, repeat, n); n != 0 && !t1.Before(from) {
+				t.Errorf("skip(%s, %s) = %d starts at %s, past %s", start, repeat, n, t1, from)
+			}
+			if t2, _ := occurrence(start, repeat, n+3); t2.Before(from) {
+				t.Errorf("skip(%s, %s) = %d leaves instances to count up to %s", start, repeat, n, from)
+			}
+		}
+	}
+	if n := skip(from, "daily", from.AddDate(0, 0, -5)); n != 0 {
+		t.Errorf("skip before the first instance = %d", n)
+	}
+
+	events, _ := newEvents(t)
+	events.events.Put(&Event{Id: "e1", Title: "Morning prayer", Start: "1950-01-01T06:00", End: "1950-01-01T07:00", Repeat: "daily"})
+	occurrences := events.Occurrences(from, from.AddDate(0, 0, 3))
+	starts := make([]string, 0)
+	for _, occurrence := range occurrences {
+		starts = append(starts, occurrence.Start)
+	}
+	want := []string{"2031-03-31T06:00", "2031-04-01T06:00", "2031-04-02T06:00"}
+	if fmt.Sprint(starts) != fmt.Sprint(want) {
+		t.Fatalf("daily event since 1950 falls on %v; want %v", starts, want)
+	}
+}
+
+func TestUpcomingIsCapped(t *testing.T) {
+	events, admin := newEvents(t)
+	memberstest.Request(t, events, admin, http.MethodPost, "/event", `{"Title":"Prayer","Start":"2020-01-01T06:00","End":"2020-01-01T07:00","Repeat":"daily"}`, nil)
+	var upcoming []Occurrence
+	memberstest.Request(t, events, nil, http.MethodGet, "/event/upcoming?days=100000000", "", &upcoming)
+	if len(upcoming) > maxDays+1 {
+		t.Fatalf("upcoming listed %d daily occurrences; want at most %d", len(upcoming), maxDays+1)
+	}
+}
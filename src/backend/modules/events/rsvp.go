@@ -0,0 +1,269 @@
+package events
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"example.com/response"
+	"example.com/users"
+	"github.com/google/uuid"
+)
+
+// RSVP statuses. Registrations past an event's capacity wait in line and
+// are confirmed in the order they came in as seats free up.
+const (
+	Confirmed  = "confirmed"
+	Waitlisted = "waitlisted"
+)
+
+// RSVP is a registration for an event. Members and visitors with an account
+// are recognised from their session; other visitors leave a name and email,
+// as with messages.
+type RSVP struct {
+	Id         string `bson:"Id"`
+	EventId    string `bson:"EventId"`
+	MemberId   string `bson:"MemberId"`
+	UserId     string `bson:"UserId"`
+	Name       string `bson:"Name"`
+	Email      string `bson:"Email"`
+	Status     string `bson:"Status"`
+	Registered string `bson:"Registered"`
+}
+
+// RSVPCollection is where the Mongo store keeps registrations.
+const RSVPCollection = "rsvp"
+
+// RSVPs lists the registrations for an event in the order they came in.
+func (events *Events) RSVPs(eventId string) []RSVP {
+	result := make([]RSVP, 0)
+	for _, rsvp := range events.rsvps.All() {
+		if strings.EqualFold(rsvp.EventId, eventId) {
+			result = append(result, *rsvp)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Registered < result[j].Registered })
+	return result
+}
+
+func (events *Events) confirmed(eventId string) int {
+	count := 0
+	for _, rsvp := range events.rsvps.All() {
+		if strings.EqualFold(rsvp.EventId, eventId) && rsvp.Status == Confirmed {
+			count++
+		}
+	}
+	return count
+}
+
+func (events *Events) rsvp(newrsvp *RSVP) (*RSVP, error) {
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+	event := events.find(newrsvp.EventId)
+	if event == nil {
+		return nil, response.Errorf(http.StatusNotFound, "event does not exists")
+	}
+	if len(newrsvp.MemberId) == 0 {
+		if len(strings.TrimSpace(newrsvp.Name)) == 0 {
+			return nil, response.Errorf(http.StatusBadRequest, "name is required")
+		}
+		if _, err := mail.ParseAddress(newrsvp.Email); err != nil {
+			return nil, response.Errorf(http.StatusBadRequest, "a valid email is required")
+		}
+	}
+	for _, rsvp := range events.rsvps.All() {
+		if !strings.EqualFold(rsvp.EventId, event.Id) {
+			continue
+		}
+		if (len(newrsvp.MemberId) != 0 && rsvp.MemberId == newrsvp.MemberId) || (len(newrsvp.Email) != 0 && strings.EqualFold(rsvp.Email, newrsvp.Email)) {
+			return nil, response.Errorf(http.StatusConflict, "already registered for this event as %s", rsvp.Status)
+		}
+	}
+	newrsvp.Status = Confirmed
+	if event.Capacity > 0 && events.confirmed(event.Id) >= event.Capacity {
+		newrsvp.Status = Waitlisted
+	}
+	newrsvp.Registered = time.Now().Format(time.RFC3339Nano)
+	err := events.rsvpStore.Insert(newrsvp)
+	if err != nil {
+		return nil, fmt.Errorf("error registering rsvp")
+	}
+	saved := *newrsvp
+	events.rsvps.Put(&saved)
+	return newrsvp, nil
+}
+
+func (events *Events) cancel(id string) (*RSVP, error) {
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+	if rsvp := events.rsvps.Get(id); rsvp != nil {
+		err := events.rsvpStore.Delete(rsvp.Id)
+		if err != nil {
+			return nil, fmt.Errorf("error cancelling rsvp")
+		}
+		events.rsvps.Remove(rsvp.Id)
+		if event := events.find(rsvp.EventId); event != nil {
+			if err := events.promote(event); err != nil {
+				return nil, err
+			}
+		}
+		return rsvp, nil
+	}
+	return nil, response.Errorf(http.StatusNotFound, "rsvp does not exists")
+}
+
+// promote confirms waitlisted RSVPs, oldest first, while the event has
+// seats left. The caller holds the mutex.
+func (events *Events) promote(event *Event) error {
+	waiting := make([]*RSVP, 0)
+	for _, rsvp := range events.rsvps.All() {
+		if strings.EqualFold(rsvp.EventId, event.Id) && rsvp.Status == Waitlisted {
+			waiting = append(waiting, rsvp)
+		}
+	}
+	sort.SliceStable(waiting, func(i, j int) bool { return waiting[i].Registered < waiting[j].Registered })
+	free := len(waiting)
+	if event.Capacity > 0 {
+		free = event.Capacity - events.confirmed(event.Id)
+	}
+	for _, rsvp := range waiting {
+		if free <= 0 {
+			break
+		}
+		err := events.rsvpStore.Update(rsvp.Id, map[string]interface{}{"Status": Confirmed})
+		if err != nil {
+			return fmt.Errorf("error confirming rsvp %s", err)
+		}
+		confirmed := *rsvp
+		confirmed.Status = Confirmed
+		events.rsvps.Put(&confirmed)
+		free--
+	}
+	return nil
+}
+
+// dropRSVPs removes the registrations of a deleted event. The caller holds
+// the mutex.
+func (events *Events) dropRSVPs(eventId string) error {
+	for _, rsvp := range events.rsvps.All() {
+		if strings.EqualFold(rsvp.EventId, eventId) {
+			if err := events.rsvpStore.Delete(rsvp.Id); err != nil {
+				return fmt.Errorf("error deleting rsvps %s", err)
+			}
+			events.rsvps.Remove(rsvp.Id)
+		}
+	}
+	return nil
+}
+
+// visitor returns the visitor account logged in on r, if any.
+func (events *Events) visitor(r *http.Request) (users.UserView, bool) {
+	if events.visitors == nil {
+		return users.UserView{}, false
+	}
+	return events.visitors.Caller(r)
+}
+
+func (events *Events) serveRSVP(w http.ResponseWriter, r *http.Request) {
+	caller, member := events.members.Caller(r)
+	visitor, account := events.visitor(r)
+	switch r.Method {
+	case http.MethodPost:
+		{
+			var newrsvp RSVP
+			if !response.Decode(w, r, &newrsvp) {
+				return
+			}
+			newrsvp.Id = uuid.NewString()
+			newrsvp.MemberId, newrsvp.UserId = "", ""
+			if member {
+				newrsvp.MemberId, newrsvp.Name, newrsvp.Email = caller.Id, caller.Name, caller.Email
+			} else if account {
+				newrsvp.UserId, newrsvp.Name, newrsvp.Email = visitor.Id, visitor.Name, visitor.Email
+			}
+			u, err := events.rsvp(&newrsvp)
+			if err != nil {
+				response.Fail(w, err)
+				return
+			}
+			response.OK(w, u)
+			return
+		}
+	case http.MethodGet:
+		{
+			event := events.find(r.URL.Query().Get("event"))
+			if event == nil {
+				response.Failf(w, http.StatusNotFound, "event does not exists")
+				return
+			}
+			if !events.allowed(r, event) {
+				response.Failf(w, http.StatusForbidden, "only the owning district or group may list registrations")
+				return
+			}
+			response.OK(w, events.RSVPs(event.Id))
+			return
+		}
+	case http.MethodDelete:
+		{
+			// Visitors cancel with the Id and email they registered with;
+			// members and visitors with an account cancel their own and
+			// leaders any for their events.
+			var oldrsvp RSVP
+			if !response.Decode(w, r, &oldrsvp) {
+				return
+			}
+			found := events.rsvps.Get(oldrsvp.Id)
+			if found == nil {
+				response.Failf(w, http.StatusNotFound, "rsvp does not exists")
+				return
+			}
+			own := (member && found.MemberId == caller.Id) || (account && found.UserId == visitor.Id) || (len(found.MemberId) == 0 && len(found.UserId) == 0 && strings.EqualFold(found.Email, oldrsvp.Email))
+			if event := events.find(found.EventId); !own && (event == nil || !events.allowed(r, event)) {
+				response.Failf(w, http.StatusForbidden, "only the registrant or the event owner may cancel")
+				return
+			}
+			u, err := events.cancel(found.Id)
+			if err != nil {
+				response.Fail(w, err)
+				return
+			}
+			response.OK(w, u)
+			return
+		}
+	}
+}
+
+// serveAttendees exports the registrations of an event as CSV for its
+// leaders.
+func (events *Events) serveAttendees(w http.ResponseWriter, r *http.Request) {
+	event := events.find(r.URL.Query().Get("event"))
+	if event == nil {
+		response.Failf(w, http.StatusNotFound, "event does not exists")
+		return
+	}
+	if !events.allowed(r, event) {
+		response.Failf(w, http.StatusForbidden, "only the owning district or group may export attendees")
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "attendees-"+event.Id+".csv"))
+	out := csv.NewWriter(w)
+	out.Write([]string{"Name", "Email", "Member", "Status", "Registered"})
+	for _, rsvp := range events.RSVPs(event.Id) {
+		out.Write([]string{cell(rsvp.Name), cell(rsvp.Email), cell(rsvp.MemberId), rsvp.Status, rsvp.Registered})
+	}
+	out.Flush()
+}
+
+// cell quotes a value visitors typed in so spreadsheet programs show it as
+// text instead of running it as a formula.
+func cell(value string) string {
+	if len(value) != 0 && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,88 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"example.com/members/memberstest"
+	"example.com/users"
+)
+
+// visitors logs in whoever sends the visitor header.
+type visitors map[string]users.UserView
+
+func (v visitors) Caller(r *http.Request) (users.UserView, bool) {
+	visitor, ok := v[r.Header.Get("Visitor")]
+	return visitor, ok
+}
+
+func addEvent(t *testing.T, events *Events, admin *http.Cookie, body string) Event {
+	t.Helper()
+	var event Event
+	if code := memberstest.Request(t, events, admin, http.MethodPost, "/event", body, &event); code != http.StatusOK {
+		t.Fatalf("POST /event = %d", code)
+	}
+	return event
+}
+
+func TestCapacityHoldsUnderConcurrentRSVPs(t *testing.T) {
+	events, admin := newEvents(t)
+	event := addEvent(t, events, admin, `{"Title":"Retreat","Start":"2030-03-01T09:00","End":"2030-03-01T17:00","Capacity":3}`)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"EventId":"%s","Name":"Visitor %d","Email":"v%d@example.com"}`, event.Id, i, i)
+			memberstest.Request(t, events, nil, http.MethodPost, "/event/rsvp", body, nil)
+		}(i)
+	}
+	wg.Wait()
+	if confirmed := events.confirmed(event.Id); confirmed != 3 {
+		t.Fatalf("%d RSVPs confirmed for 3 seats", confirmed)
+	}
+	if all := events.RSVPs(event.Id); len(all) != 20 {
+		t.Fatalf("%d RSVPs recorded; want 20", len(all))
+	}
+}
+
+func TestAttendeesExportQuotesFormulas(t *testing.T) {
+	events, admin := newEvents(t)
+	event := addEvent(t, events, admin, `{"Title":"Harambee","Start":"2030-03-01T09:00","End":"2030-03-01T17:00"}`)
+	memberstest.Request(t, events, nil, http.MethodPost, "/event/rsvp", `{"EventId":"`+event.Id+`","Name":"=HYPERLINK(\"http://x\")","Email":"v@example.com"}`, nil)
+	req := httptest.NewRequest(http.MethodGet, "/event/attendees.csv?event="+event.Id, nil)
+	req.AddCookie(admin)
+	rec := httptest.NewRecorder()
+	events.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), `'=HYPERLINK`) {
+		t.Fatalf("a formula left unquoted in the export:\n%s", rec.Body.String())
+	}
+}
+
+func TestVisitorAccountRSVP(t *testing.T) {
+	events, admin := newEvents(t)
+	events.Visitors(visitors{"amina": {Id: "u1", Name: "Amina", Email: "amina@example.com"}})
+	event := addEvent(t, events, admin, `{"Title":"Open day","Start":"2030-03-01T09:00","End":"2030-03-01T17:00"}`)
+
+	send := func(method, body string) (int, RSVP) {
+		req := httptest.NewRequest(method, "/event/rsvp", strings.NewReader(body))
+		req.Header.Set("Visitor", "amina")
+		rec := httptest.NewRecorder()
+		events.ServeHTTP(rec, req)
+		var rsvp RSVP
+		json.Unmarshal(rec.Body.Bytes(), &rsvp)
+		return rec.Code, rsvp
+	}
+	code, rsvp := send(http.MethodPost, `{"EventId":"`+event.Id+`","Name":"Someone else","Email":"other@example.com"}`)
+	if code != http.StatusOK || rsvp.UserId != "u1" || rsvp.Email != "amina@example.com" {
+		t.Fatalf("visitor RSVP = %d %+v; want it under the account", code, rsvp)
+	}
+	if code, _ := send(http.MethodDelete, `{"Id":"`+rsvp.Id+`"}`); code != http.StatusOK {
+		t.Fatalf("visitor cancelling their own RSVP without an email = %d; want 200", code)
+	}
+}
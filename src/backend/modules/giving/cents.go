@@ -0,0 +1,74 @@
+package giving
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// Cents is an amount of money in cents, kept whole so that totals add up
+// exactly. JSON carries it as a decimal number of shillings, such as
+// 1500.50, with at most two decimals.
+type Cents int64
+
+var decimal = regexp.MustCompile(`^(-?)(\d{1,15})(?:\.(\d{1,2}))?$`)
+
+// ParseCents reads a decimal amount of shillings, refusing fractions of a
+// cent.
+func ParseCents(amount string) (Cents, error) {
+	parts := decimal.FindStringSubmatch(amount)
+	if parts == nil {
+		return 0, fmt.Errorf("amount %s must be a number with at most two decimals", amount)
+	}
+	whole, _ := strconv.ParseInt(parts[2], 10, 64)
+	fraction, _ := strconv.ParseInt((parts[3] + "00")[:2], 10, 64)
+	cents := Cents(whole*100 + fraction)
+	if parts[1] == "-" {
+		cents = -cents
+	}
+	return cents, nil
+}
+
+func (cents Cents) String() string {
+	sign, value := "", int64(cents)
+	if value < 0 {
+		sign, value = "-", -value
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, value/100, value%100)
+}
+
+func (cents Cents) MarshalJSON() ([]byte, error) {
+	return []byte(cents.String()), nil
+}
+
+func (cents *Cents) UnmarshalJSON(data []byte) error {
+	parsed, err := ParseCents(string(data))
+	if err != nil {
+		return err
+	}
+	*cents = parsed
+	return nil
+}
+
+// UnmarshalBSONValue reads amounts stored as cents. Entries saved before
+// amounts were kept in cents hold a double of shillings and are converted.
+func (cents *Cents) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	value := bson.RawValue{Type: t, Value: data}
+	switch t {
+	case bsontype.Int64:
+		*cents = Cents(value.Int64())
+	case bsontype.Int32:
+		*cents = Cents(value.Int32())
+	case bsontype.Double:
+		*cents = Cents(math.Round(value.Double() * 100))
+	case bsontype.Null:
+		*cents = 0
+	default:
+		return fmt.Errorf("amount is stored as %s", t)
+	}
+	return nil
+}
@@ -0,0 +1,382 @@
+package giving
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/members"
+	"example.com/response"
+	"example.com/roles"
+	"example.com/store"
+	"github.com/google/uuid"
+)
+
+// Categories and payment methods accepted on the ledger.
+var (
+	categories = []string{"tithe", "offering", "pledge", "harambee"}
+	methods    = []string{"cash", "mpesa", "bank"}
+)
+
+const dateLayout = "2006-01-02"
+
+// Entry is one contribution. Reference holds the M-Pesa code or bank slip
+// number and is required for anything but cash.
+type Entry struct {
+	Id        string `bson:"Id"`
+	MemberId  string `bson:"MemberId"`
+	Date      string `bson:"Date"`
+	Amount    Cents  `bson:"Amount"`
+	Category  string `bson:"Category"`
+	Method    string `bson:"Method"`
+	Reference string `bson:"Reference"`
+	Notes     string `bson:"Notes"`
+}
+
+// Change is the audit trail of edits made to the ledger.
+type Change struct {
+	Id      string `bson:"Id"`
+	EntryId string `bson:"EntryId"`
+	Action  string `bson:"Action"`
+	By      string `bson:"By"`
+	At      string `bson:"At"`
+	Before  *Entry `bson:"Before"`
+	After   *Entry `bson:"After"`
+}
+
+// Statement is a member's giving for one year.
+type Statement struct {
+	Member  interface{}
+	Year    int
+	Entries []Entry
+	Totals  map[string]Cents
+	Total   Cents
+}
+
+// MonthTotals are the category totals for one month, keyed "2006-01".
+type MonthTotals struct {
+	Month  string
+	Totals map[string]Cents
+	Total  Cents
+}
+
+type Giving struct {
+	entries *store.Cache[Entry]
+	store   store.Store[Entry]
+	changes store.Store[Change]
+	members *members.Members
+	// mutex serializes add, delete and update; reads use cache snapshots
+	mutex sync.Mutex
+}
+
+// Collections the Mongo stores keep the ledger and its audit trail in.
+const (
+	EntryCollection  = "giving"
+	ChangeCollection = "givinglog"
+)
+
+func NewGiving(records store.Store[Entry], changes store.Store[Change], m *members.Members) *Giving {
+	entries, err := records.All()
+	if err != nil {
+		log.Fatal("error loading giving data " + err.Error())
+	}
+	cache := store.NewCache(entries, func(entry *Entry) string { return entry.Id })
+	cache.Follow("giving", records)
+	return &Giving{entries: cache, store: records, changes: changes, members: m}
+}
+
+func oneOf(list []string, value string) bool {
+	for _, x := range list {
+		if x == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (giving *Giving) validate(entry *Entry) error {
+	entry.Category = strings.ToLower(entry.Category)
+	entry.Method = strings.ToLower(entry.Method)
+	if _, ok := giving.members.Get(entry.MemberId); !ok {
+		return response.Errorf(http.StatusBadRequest, "member does not exists")
+	}
+	if _, err := time.Parse(dateLayout, entry.Date); err != nil {
+		return response.Errorf(http.StatusBadRequest, "date must look like %s", dateLayout)
+	}
+	if entry.Amount <= 0 {
+		return response.Errorf(http.StatusBadRequest, "amount must be greater than zero")
+	}
+	if !oneOf(categories, entry.Category) {
+		return response.Errorf(http.StatusBadRequest, "category must be one of %s", strings.Join(categories, ", "))
+	}
+	if !oneOf(methods, entry.Method) {
+		return response.Errorf(http.StatusBadRequest, "payment method must be one of %s", strings.Join(methods, ", "))
+	}
+	if entry.Method != "cash" && len(strings.TrimSpace(entry.Reference)) == 0 {
+		return response.Errorf(http.StatusBadRequest, "a %s payment needs a reference", entry.Method)
+	}
+	return nil
+}
+
+// log records who changed an entry and how. It is written before the
+// ledger is touched, so no change goes unrecorded; a ledger write that then
+// fails takes its record back with unlog.
+func (giving *Giving) log(action, by string, before, after *Entry) (*Change, error) {
+	change := Change{Id: uuid.NewString(), Action: action, By: by, At: time.Now().Format(time.RFC3339), Before: before, After: after}
+	if before != nil {
+		change.EntryId = before.Id
+	} else if after != nil {
+		change.EntryId = after.Id
+	}
+	err := giving.changes.Insert(&change)
+	if err != nil {
+		return nil, fmt.Errorf("error logging ledger change")
+	}
+	return &change, nil
+}
+
+// unlog removes the record of a change that did not reach the ledger.
+func (giving *Giving) unlog(change *Change) {
+	if err := giving.changes.Delete(change.Id); err != nil {
+		log.Println("error removing ledger change", change.Id, err)
+	}
+}
+
+// Changes lists the audit trail in the order the changes were made, for
+// one entry when entryId is given.
+func (giving *Giving) Changes(entryId string) ([]Change, error) {
+	changes, err := giving.changes.All()
+	if err != nil {
+		return nil, fmt.Errorf("error loading ledger changes")
+	}
+	result := make([]Change, 0)
+	for _, change := range changes {
+		if len(entryId) == 0 || strings.EqualFold(change.EntryId, entryId) {
+			result = append(result, *change)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].At < result[j].At })
+	return result, nil
+}
+
+func (giving *Giving) add(newentry *Entry, by string) (*Entry, error) {
+	if err := giving.validate(newentry); err != nil {
+		return nil, err
+	}
+	giving.mutex.Lock()
+	defer giving.mutex.Unlock()
+	change, err := giving.log("create", by, nil, newentry)
+	if err != nil {
+		return nil, err
+	}
+	err = giving.store.Insert(newentry)
+	if err != nil {
+		giving.unlog(change)
+		return nil, fmt.Errorf("error recording giving")
+	}
+	saved := *newentry
+	giving.entries.Put(&saved)
+	return newentry, nil
+}
+
+func (giving *Giving) delete(oldentry *Entry, by string) (*Entry, error) {
+	giving.mutex.Lock()
+	defer giving.mutex.Unlock()
+	if entry := giving.entries.Get(oldentry.Id); entry != nil {
+		change, err := giving.log("delete", by, entry, nil)
+		if err != nil {
+			return nil, err
+		}
+		err = giving.store.Delete(entry.Id)
+		if err != nil {
+			giving.unlog(change)
+			return nil, fmt.Errorf("error deleting giving")
+		}
+		giving.entries.Remove(entry.Id)
+		return entry, nil
+	}
+	return nil, response.Errorf(http.StatusNotFound, "giving entry does not exists")
+}
+
+func (giving *Giving) update(update map[string]interface{}, by string) (*Entry, error) {
+	giving.mutex.Lock()
+	defer giving.mutex.Unlock()
+	id, _ := update["Id"].(string)
+	for _, entry := range giving.entries.All() {
+		if strings.EqualFold(entry.Id, id) {
+			usr := *entry
+			if amount, ok := update["Amount"]; ok {
+				// JSON numbers arrive as floats, read back as the decimal sent
+				number, ok := amount.(float64)
+				if !ok {
+					return nil, response.Invalid("type mismatch for field Amount", map[string]string{"Amount": "wrong type"})
+				}
+				cents, err := ParseCents(strconv.FormatFloat(number, 'f', -1, 64))
+				if err != nil {
+					return nil, response.Invalid(err.Error(), map[string]string{"Amount": "at most two decimals"})
+				}
+				update["Amount"] = cents
+			}
+			if _, err := store.Apply(&usr, update); err != nil {
+				return nil, err
+			}
+			if err := giving.validate(&usr); err != nil {
+				return nil, err
+			}
+			set := map[string]interface{}{"MemberId": usr.MemberId, "Date": usr.Date, "Amount": usr.Amount, "Category": usr.Category, "Method": usr.Method, "Reference": usr.Reference, "Notes": usr.Notes}
+			change, err := giving.log("update", by, entry, &usr)
+			if err != nil {
+				return nil, err
+			}
+			err = giving.store.Update(usr.Id, set)
+			if err != nil {
+				giving.unlog(change)
+				return nil, fmt.Errorf("error updating giving %s", err)
+			}
+			saved := usr
+			giving.entries.Put(&saved)
+			return &usr, nil
+		}
+	}
+	return nil, response.Errorf(http.StatusNotFound, "giving entry does not exists")
+}
+
+// Statement gathers a member's entries for a year with category totals,
+// showing the member as present would show them.
+func (giving *Giving) Statement(memberId string, year int, present func(members.Member) interface{}) (Statement, error) {
+	member, ok := giving.members.Get(memberId)
+	if !ok {
+		return Statement{}, response.Errorf(http.StatusNotFound, "member does not exists")
+	}
+	statement := Statement{Member: present(member), Year: year, Entries: make([]Entry, 0), Totals: make(map[string]Cents)}
+	prefix := strconv.Itoa(year) + "-"
+	for _, entry := range giving.entries.All() {
+		if strings.EqualFold(entry.MemberId, memberId) && strings.HasPrefix(entry.Date, prefix) {
+			statement.Entries = append(statement.Entries, *entry)
+			statement.Totals[entry.Category] += entry.Amount
+			statement.Total += entry.Amount
+		}
+	}
+	sort.Slice(statement.Entries, func(i, j int) bool { return statement.Entries[i].Date < statement.Entries[j].Date })
+	return statement, nil
+}
+
+// Monthly totals every category per month of a year.
+func (giving *Giving) Monthly(year int) []MonthTotals {
+	months := make([]MonthTotals, 12)
+	for i := range months {
+		months[i] = MonthTotals{Month: fmt.Sprintf("%04d-%02d", year, i+1), Totals: make(map[string]Cents)}
+		for _, category := range categories {
+			months[i].Totals[category] = 0
+		}
+	}
+	for _, entry := range giving.entries.All() {
+		date, err := time.Parse(dateLayout, entry.Date)
+		if err != nil || date.Year() != year {
+			continue
+		}
+		month := &months[date.Month()-1]
+		month.Totals[entry.Category] += entry.Amount
+		month.Total += entry.Amount
+	}
+	return months
+}
+
+func year(r *http.Request) int {
+	y, err := strconv.Atoi(r.URL.Query().Get("year"))
+	if err != nil {
+		return time.Now().Year()
+	}
+	return y
+}
+
+func (giving *Giving) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	caller, _ := giving.members.Caller(r)
+	if strings.EqualFold(r.URL.Path, "/giving/statement") {
+		member := r.URL.Query().Get("member")
+		if role := giving.members.RoleOf(caller.Email); role != roles.Treasurer && role != roles.Admin {
+			member = caller.Id
+		}
+		statement, err := giving.Statement(member, year(r), giving.members.Presenter(r))
+		if err != nil {
+			response.Fail(w, err)
+			return
+		}
+		response.OK(w, statement)
+		return
+	} else if strings.EqualFold(r.URL.Path, "/giving/totals") {
+		response.OK(w, giving.Monthly(year(r)))
+		return
+	} else if strings.EqualFold(r.URL.Path, "/giving/log") {
+		changes, err := giving.Changes(r.URL.Query().Get("entry"))
+		if err != nil {
+			response.Fail(w, err)
+			return
+		}
+		response.OK(w, changes)
+		return
+	} else if strings.EqualFold(r.URL.Path, "/giving") {
+		switch r.Method {
+		case http.MethodPost:
+			{
+				var newentry Entry
+				if !response.Decode(w, r, &newentry) {
+					return
+				}
+				newentry.Id = uuid.NewString()
+				u, err := giving.add(&newentry, caller.Id)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		case http.MethodGet:
+			{
+				member := r.URL.Query().Get("member")
+				result := make([]Entry, 0)
+				for _, e := range giving.entries.All() {
+					if len(member) == 0 || strings.EqualFold(e.MemberId, member) {
+						result = append(result, *e)
+					}
+				}
+				response.OK(w, result)
+				return
+			}
+		case http.MethodDelete:
+			{
+				var oldentry Entry
+				if !response.Decode(w, r, &oldentry) {
+					return
+				}
+				u, err := giving.delete(&oldentry, caller.Id)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		case http.MethodPut:
+			{
+				updateentry := make(map[string]interface{}, 0)
+				if !response.Decode(w, r, &updateentry) {
+					return
+				}
+				u, err := giving.update(updateentry, caller.Id)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		}
+	}
+}
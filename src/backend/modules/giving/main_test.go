@@ -0,0 +1,107 @@
+package giving
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"example.com/members"
+	"example.com/members/memberstest"
+	"example.com/roles"
+	"example.com/store"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// failing is a ledger store whose writes fail once broken is set.
+type failing struct {
+	store.Store[Entry]
+	broken bool
+}
+
+func (f *failing) Insert(entry *Entry) error {
+	if f.broken {
+		return errors.New("disk full")
+	}
+	return f.Store.Insert(entry)
+}
+
+func TestLedgerChangesAreLogged(t *testing.T) {
+	m := memberstest.New(t,
+		members.Member{Id: "treasurer", Email: "treasurer@example.com", Role: roles.Treasurer},
+		members.Member{Id: "mwangi", Email: "mwangi@example.com", Role: roles.Member},
+	)
+	records := &failing{Store: store.NewMemory[Entry]("Id")}
+	changes := store.NewMemory[Change]("Id")
+	giving := NewGiving(records, changes, m)
+	treasurer := memberstest.Login(t, m, "treasurer@example.com")
+
+	var entry Entry
+	if code := memberstest.Request(t, giving, treasurer, http.MethodPost, "/giving", `{"MemberId":"mwangi","Date":"2024-03-03","Amount":500,"Category":"tithe","Method":"cash"}`, &entry); code != http.StatusOK {
+		t.Fatalf("POST /giving = %d", code)
+	}
+	memberstest.Request(t, giving, treasurer, http.MethodPut, "/giving", `{"Id":"`+entry.Id+`","Amount":600}`, nil)
+
+	records.broken = true
+	if code := memberstest.Request(t, giving, treasurer, http.MethodPost, "/giving", `{"MemberId":"mwangi","Date":"2024-03-10","Amount":100,"Category":"offering","Method":"cash"}`, nil); code != http.StatusInternalServerError {
+		t.Fatalf("POST /giving on a failing store = %d; want 500", code)
+	}
+
+	var trail []Change
+	memberstest.Request(t, giving, treasurer, http.MethodGet, "/giving/log?entry="+entry.Id, "", &trail)
+	if len(trail) != 2 || trail[0].By != "treasurer" || trail[1].Action != "update" || trail[1].After.Amount != 60000 {
+		t.Fatalf("GET /giving/log = %+v; want the create then the update", trail)
+	}
+	if all, _ := changes.All(); len(all) != 2 {
+		t.Fatalf("the audit trail holds %d changes; the failed write must leave none", len(all))
+	}
+}
+
+func TestAmountsAreKeptInCents(t *testing.T) {
+	m := memberstest.New(t,
+		members.Member{Id: "treasurer", Email: "treasurer@example.com", Role: roles.Treasurer},
+		members.Member{Id: "mwangi", Email: "mwangi@example.com", Role: roles.Member},
+	)
+	giving := NewGiving(store.NewMemory[Entry]("Id"), store.NewMemory[Change]("Id"), m)
+	treasurer := memberstest.Login(t, m, "treasurer@example.com")
+	var entry Entry
+	for _, amount := range []string{"0.1", "0.2"} {
+		if code := memberstest.Request(t, giving, treasurer, http.MethodPost, "/giving", `{"MemberId":"mwangi","Date":"2024-03-03","Amount":`+amount+`,"Category":"offering","Method":"cash"}`, &entry); code != http.StatusOK {
+			t.Fatalf("POST /giving of %s = %d", amount, code)
+		}
+	}
+	if code := memberstest.Request(t, giving, treasurer, http.MethodPost, "/giving", `{"MemberId":"mwangi","Date":"2024-03-03","Amount":1.005,"Category":"offering","Method":"cash"}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("POST /giving of a fraction of a cent = %d; want 400", code)
+	}
+	if code := memberstest.Request(t, giving, treasurer, http.MethodPut, "/giving", `{"Id":"`+entry.Id+`","Amount":2.345}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("PUT /giving of a fraction of a cent = %d; want 400", code)
+	}
+	var statement map[string]json.RawMessage
+	memberstest.Request(t, giving, treasurer, http.MethodGet, "/giving/statement?member=mwangi&year=2024", "", &statement)
+	if total := string(statement["Total"]); total != "0.30" {
+		t.Fatalf("statement total = %s; want 0.30", total)
+	}
+
+	// entries saved before amounts were kept in cents hold shillings
+	legacy, err := bson.Marshal(bson.M{"Id": "old", "Amount": 1500.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var old Entry
+	if err := bson.Unmarshal(legacy, &old); err != nil || old.Amount != 150050 {
+		t.Fatalf("legacy amount read as %d cents %v; want 150050", old.Amount, err)
+	}
+}
+
+func TestParseCents(t *testing.T) {
+	for amount, want := range map[string]Cents{"1500": 150000, "1500.5": 150050, "0.05": 5, "-2.10": -210} {
+		if got, err := ParseCents(amount); err != nil || got != want {
+			t.Errorf("ParseCents(%s) = %d %v; want %d", amount, got, err, want)
+		}
+	}
+	for _, amount := range []string{"1.005", "1e3", "", ".5", "12.", "1234567890123456"} {
+		if _, err := ParseCents(amount); err == nil {
+			t.Errorf("ParseCents(%q) succeeded", amount)
+		}
+	}
+}
@@ -1,17 +1,15 @@
 package groups
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
-	"reflect"
 	"strings"
+	"sync"
 
+	"example.com/response"
+	"example.com/store"
 	"github.com/google/uuid"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type Group struct {
@@ -20,97 +18,144 @@ type Group struct {
 	Email       string `bson:"Email"`
 	Description string `bson:"Description"`
 	Passport    string `bson:"Passport"`
+	Leaders     string `bson:"Leaders"`
+}
+
+// Dependent holds records that reference groups by Id.
+type Dependent interface {
+	GroupReferences(id string) int
+	GroupMoved(from, to string) error
 }
 
 type Groups struct {
-	groups []*Group
-	db     *mongo.Database
+	groups     *store.Cache[Group]
+	store      store.Store[Group]
+	dependents []Dependent
+	// mutex serializes add, delete and update; reads use cache snapshots
+	mutex sync.Mutex
 }
 
-const groupCollection = "group"
+// GroupCollection is where the Mongo store keeps groups.
+const GroupCollection = "group"
 
-func NewGroups(db *mongo.Database) *Groups {
-	groups := make([]*Group, 0)
-	col := db.Collection(groupCollection)
-	result, err := col.Find(context.TODO(), bson.M{})
+func NewGroups(records store.Store[Group]) *Groups {
+	groups, err := records.All()
 	if err != nil {
-		log.Fatal(err.Error())
-	} else {
-		if err = result.All(context.TODO(), &groups); err != nil {
-			log.Fatal("error loading groups data " + err.Error())
+		log.Fatal("error loading groups data " + err.Error())
+	}
+	cache := store.NewCache(groups, func(group *Group) string { return group.Id })
+	cache.Follow("groups", records)
+	return &Groups{groups: cache, store: records}
+}
+
+// Led returns the Ids of the groups whose Leaders list memberId.
+func (groups *Groups) Led(memberId string) []string {
+	led := make([]string, 0)
+	for _, group := range groups.groups.All() {
+		if leads(group.Leaders, memberId) {
+			led = append(led, group.Id)
 		}
 	}
-	return &Groups{groups: groups, db: db}
+	return led
 }
 
-func (groups *Groups) add(newgroup *Group) (*Group, error) {
-	bsonData, err := bson.Marshal(newgroup)
-	if err != nil {
-		return nil, fmt.Errorf("error processing group details")
+// leads reports whether memberId is in a semicolon separated Leaders list.
+func leads(leaders, memberId string) bool {
+	for _, leader := range strings.Split(leaders, ";") {
+		if len(leader) != 0 && strings.EqualFold(leader, memberId) {
+			return true
+		}
 	}
-	col := groups.db.Collection(groupCollection)
-	_, err = col.InsertOne(context.TODO(), bsonData)
+	return false
+}
+
+// Notify registers a dependent whose references are checked and moved when
+// groups are deleted.
+func (groups *Groups) Notify(dependent Dependent) {
+	groups.dependents = append(groups.dependents, dependent)
+}
+
+// Exists reports whether a group with the given Id is registered.
+func (groups *Groups) Exists(id string) bool {
+	for _, group := range groups.groups.All() {
+		if strings.EqualFold(group.Id, id) {
+			return true
+		}
+	}
+	return false
+}
+
+func (groups *Groups) add(newgroup *Group) (*Group, error) {
+	groups.mutex.Lock()
+	defer groups.mutex.Unlock()
+	err := groups.store.Insert(newgroup)
 	if err != nil {
 		return nil, fmt.Errorf("error registering user")
 	}
-	go func() {
-		groups.groups = append(groups.groups, newgroup)
-	}()
+	saved := *newgroup
+	groups.groups.Put(&saved)
 	return newgroup, nil
 }
 
-func (groups *Groups) delete(oldgroup *Group) (*Group, error) {
-	for x, group := range groups.groups {
+// delete refuses to remove a group that memberships, events or
+// announcements still refer to unless reassign names another group to move
+// them to first.
+func (groups *Groups) delete(oldgroup *Group, reassign string) (*Group, error) {
+	groups.mutex.Lock()
+	defer groups.mutex.Unlock()
+	for _, group := range groups.groups.All() {
 		if strings.EqualFold(group.Id, oldgroup.Id) {
-			bsonData, err := bson.Marshal(oldgroup)
-			if err != nil {
-				return nil, fmt.Errorf("error processing group details")
+			references := 0
+			for _, dependent := range groups.dependents {
+				references += dependent.GroupReferences(group.Id)
 			}
-			col := groups.db.Collection(groupCollection)
-			_, err = col.DeleteOne(context.TODO(), bsonData)
+			if references != 0 {
+				if len(reassign) == 0 {
+					return nil, response.Errorf(http.StatusConflict, "%d records such as memberships, events and announcements still refer to the group, choose a group to reassign them to", references)
+				}
+				if strings.EqualFold(reassign, group.Id) || !groups.Exists(reassign) {
+					return nil, response.Invalid("reassign group does not exists", map[string]string{"Reassign": "no such group"})
+				}
+				for _, dependent := range groups.dependents {
+					if err := dependent.GroupMoved(group.Id, reassign); err != nil {
+						return nil, fmt.Errorf("error reassigning group members %s", err)
+					}
+				}
+			}
+			err := groups.store.Delete(group.Id)
 			if err != nil {
 				return nil, fmt.Errorf("error deleting user")
 			}
-			go func() {
-				groups.groups = append(groups.groups[:x], groups.groups[x+1:]...)
-			}()
+			groups.groups.Remove(group.Id)
 			return oldgroup, nil
 		}
 	}
-	return nil, fmt.Errorf("group account does not exists")
+	return nil, response.Errorf(http.StatusNotFound, "group account does not exists")
 }
 
+// update applies only the fields present in the request so that edits from
+// forms that do not carry every field leave the rest untouched.
 func (groups *Groups) update(update map[string]interface{}) (*Group, error) {
-	usr := Group{}
-	for key, value := range update {
-		field := reflect.ValueOf(&usr).Elem().FieldByName(key)
-		if field.IsValid() && field.CanSet() {
-			val := reflect.ValueOf(value)
-			if field.Type() == val.Type() {
-				field.Set(val)
-			} else {
-				return nil, fmt.Errorf("Type mismatch for field %s", key)
-			}
-		}
-	}
-	for _, group := range groups.groups {
-		if strings.EqualFold(group.Id, usr.Id) {
-			update := bson.M{}
-			bsonData, err := bson.Marshal(usr)
+	groups.mutex.Lock()
+	defer groups.mutex.Unlock()
+	id, _ := update["Id"].(string)
+	for _, group := range groups.groups.All() {
+		if strings.EqualFold(group.Id, id) {
+			usr := *group
+			set, err := store.Apply(&usr, update)
 			if err != nil {
-				return nil, fmt.Errorf("error updating group %s", err)
+				return nil, err
 			}
-			bson.Unmarshal(bsonData, &update)
-			col := groups.db.Collection(groupCollection)
-			_, err = col.UpdateOne(context.TODO(), bson.M{"Id": usr.Id}, bson.M{"$set": update})
+			err = groups.store.Update(usr.Id, set)
 			if err != nil {
 				return nil, fmt.Errorf("error updating group %s", err)
 			}
-			*group = usr
+			saved := usr
+			groups.groups.Put(&saved)
 			return &usr, nil
 		}
 	}
-	return nil, fmt.Errorf("group account does not exists")
+	return nil, response.Errorf(http.StatusNotFound, "group account does not exists")
 }
 
 func (groups *Groups) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -119,60 +164,67 @@ func (groups *Groups) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		case http.MethodPost:
 			{
 				var newgroup Group
-				err := json.NewDecoder(r.Body).Decode(&newgroup)
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
+				if !response.Decode(w, r, &newgroup) {
 					return
 				}
 				newgroup.Id = uuid.NewString()
 				u, err := groups.add(&newgroup)
 				if err != nil {
-					json.NewEncoder(w).Encode(fmt.Sprintf("{error: %s}", err.Error()))
+					response.Fail(w, err)
 					return
 				}
-				json.NewEncoder(w).Encode(u)
+				response.OK(w, u)
 				return
 			}
 		case http.MethodGet:
 			{
-
+				query, err := store.ParseQuery(r.URL.Query(), "Name", "Email")
+				if err != nil {
+					response.Failf(w, http.StatusBadRequest, "%s", err)
+					return
+				}
+				leader := r.URL.Query().Get("leader")
 				result := make([]Group, 0)
-				for _, m := range groups.groups {
-					result = append(result, *m)
+				for _, m := range groups.groups.All() {
+					if len(leader) != 0 && !leads(m.Leaders, leader) {
+						continue
+					}
+					if query.Matches(m.Name, m.Email, m.Description) {
+						result = append(result, *m)
+					}
 				}
-				json.NewEncoder(w).Encode(result)
+				response.OK(w, store.Paginate(result, query))
 				return
 			}
 		case http.MethodDelete:
 			{
-				var newgroup Group
-				err := json.NewDecoder(r.Body).Decode(&newgroup)
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
-
+				var newgroup struct {
+					Group
+					Reassign string
+				}
+				if !response.Decode(w, r, &newgroup) {
+					return
 				}
-				u, err := groups.delete(&newgroup)
+				u, err := groups.delete(&newgroup.Group, newgroup.Reassign)
 				if err != nil {
-					json.NewEncoder(w).Encode(fmt.Sprintf("{error: %s}", err.Error()))
+					response.Fail(w, err)
 					return
 				}
-				json.NewEncoder(w).Encode(u)
+				response.OK(w, u)
 				return
 			}
 		case http.MethodPut:
 			{
 				updategroup := make(map[string]interface{}, 0)
-				err := json.NewDecoder(r.Body).Decode(&updategroup)
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
+				if !response.Decode(w, r, &updategroup) {
 					return
 				}
 				u, err := groups.update(updategroup)
 				if err != nil {
-					json.NewEncoder(w).Encode(fmt.Sprintf("{error: %s}", err.Error()))
+					response.Fail(w, err)
 					return
 				}
-				json.NewEncoder(w).Encode(u)
+				response.OK(w, u)
 				return
 
 			}
@@ -0,0 +1,82 @@
+package groups
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"example.com/store"
+)
+
+// serve sends a JSON request to the handler and decodes the reply into v.
+func serve(t *testing.T, handler http.Handler, method, target, body string, v interface{}) int {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: decoding %q: %s", method, target, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+type counter map[string]int
+
+func (c counter) GroupReferences(id string) int { return c[id] }
+func (c counter) GroupMoved(from, to string) error {
+	c[to] += c[from]
+	delete(c, from)
+	return nil
+}
+
+func TestGroupsCRUD(t *testing.T) {
+	records := store.NewMemory[Group]("Id")
+	groups := NewGroups(records)
+
+	var youth Group
+	if code := serve(t, groups, http.MethodPost, "/group", `{"Name":"Youth","Leaders":"m1"}`, &youth); code != http.StatusOK || len(youth.Id) == 0 {
+		t.Fatalf("POST /group = %d %+v", code, youth)
+	}
+	var choir Group
+	serve(t, groups, http.MethodPost, "/group", `{"Name":"Choir"}`, &choir)
+
+	var page store.Page[Group]
+	serve(t, groups, http.MethodGet, "/group?leader=m1", "", &page)
+	if page.Total != 1 || page.Items[0].Name != "Youth" {
+		t.Fatalf("GET /group?leader=m1 = %+v", page)
+	}
+
+	var updated Group
+	if code := serve(t, groups, http.MethodPut, "/group", `{"Id":"`+youth.Id+`","Description":"Under 30s"}`, &updated); code != http.StatusOK || updated.Name != "Youth" || updated.Description != "Under 30s" {
+		t.Fatalf("PUT /group = %d %+v", code, updated)
+	}
+	if saved, _ := records.Find(map[string]interface{}{"Id": youth.Id}); saved[0].Description != "Under 30s" {
+		t.Fatalf("PUT /group did not reach the store: %+v", saved[0])
+	}
+	if code := serve(t, groups, http.MethodPut, "/group", `{"Id":"`+youth.Id+`","Name":7}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("PUT /group with a number for Name = %d; want 400", code)
+	}
+	if code := serve(t, groups, http.MethodPut, "/group", `{"Id":"`+youth.Id+`","Name":null}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("PUT /group with null for Name = %d; want 400", code)
+	}
+
+	members := counter{youth.Id: 3}
+	groups.Notify(members)
+	if code := serve(t, groups, http.MethodDelete, "/group", `{"Id":"`+youth.Id+`"}`, nil); code != http.StatusConflict {
+		t.Fatalf("DELETE of a group with members = %d; want 409", code)
+	}
+	if code := serve(t, groups, http.MethodDelete, "/group", `{"Id":"`+youth.Id+`","Reassign":"`+choir.Id+`"}`, nil); code != http.StatusOK {
+		t.Fatalf("DELETE with Reassign = %d; want 200", code)
+	}
+	if members[choir.Id] != 3 || groups.Exists(youth.Id) {
+		t.Fatalf("members were not moved to the choir: %v", members)
+	}
+	if all, _ := records.All(); len(all) != 1 {
+		t.Fatalf("store holds %d groups after delete; want 1", len(all))
+	}
+}
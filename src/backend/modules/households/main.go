@@ -0,0 +1,304 @@
+package households
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"example.com/members"
+	"example.com/response"
+	"example.com/store"
+	"github.com/google/uuid"
+)
+
+// Household groups the members of one family. Head and Spouse hold a
+// member Id, Children and Dependents semicolon separated member Ids.
+type Household struct {
+	Id         string `bson:"Id"`
+	Name       string `bson:"Name"`
+	Head       string `bson:"Head"`
+	Spouse     string `bson:"Spouse"`
+	Children   string `bson:"Children"`
+	Dependents string `bson:"Dependents"`
+	Contacts   string `bson:"Contacts"`
+	Email      string `bson:"Email"`
+	Address    string `bson:"Address"`
+	District   string `bson:"District"`
+}
+
+// Relative is a household member together with their place in it.
+type Relative struct {
+	Relation string
+	Member   interface{}
+}
+
+// Family is the per household view: the household and its people.
+type Family struct {
+	Household Household
+	Members   []Relative
+}
+
+type Households struct {
+	households *store.Cache[Household]
+	store      store.Store[Household]
+	members    *members.Members
+	// mutex serializes add, delete and update; reads use cache snapshots
+	mutex sync.Mutex
+}
+
+// HouseholdCollection is where the Mongo store keeps households.
+const HouseholdCollection = "household"
+
+func NewHouseholds(records store.Store[Household], m *members.Members) *Households {
+	households, err := records.All()
+	if err != nil {
+		log.Fatal("error loading households data " + err.Error())
+	}
+	cache := store.NewCache(households, func(household *Household) string { return household.Id })
+	cache.Follow("households", records)
+	return &Households{households: cache, store: records, members: m}
+}
+
+// DistrictReferences counts the households placed in a district.
+func (households *Households) DistrictReferences(id string) int {
+	count := 0
+	for _, household := range households.households.All() {
+		if strings.EqualFold(household.District, id) {
+			count++
+		}
+	}
+	return count
+}
+
+// DistrictMoved points every household of district from at district to.
+func (households *Households) DistrictMoved(from, to string) error {
+	households.mutex.Lock()
+	defer households.mutex.Unlock()
+	_, err := households.store.UpdateWhere(map[string]interface{}{"District": from}, map[string]interface{}{"District": to})
+	if err != nil {
+		return fmt.Errorf("error moving households %s", err)
+	}
+	for _, household := range households.households.All() {
+		if household.District == from {
+			moved := *household
+			moved.District = to
+			households.households.Put(&moved)
+		}
+	}
+	return nil
+}
+
+// family expands a household into its member records, each shown as
+// present would show them.
+func (households *Households) family(household *Household, present func(members.Member) interface{}) Family {
+	family := Family{Household: *household, Members: make([]Relative, 0)}
+	relations := []struct {
+		relation string
+		ids      string
+	}{
+		{"head", household.Head},
+		{"spouse", household.Spouse},
+		{"child", household.Children},
+		{"dependent", household.Dependents},
+	}
+	for _, relation := range relations {
+		for _, id := range strings.Split(relation.ids, ";") {
+			if member, ok := households.members.Get(id); ok {
+				family.Members = append(family.Members, Relative{Relation: relation.relation, Member: present(member)})
+			}
+		}
+	}
+	return family
+}
+
+// visible reports whether the caller's scope covers the household's
+// district, so district elders see only the families in their district.
+func visible(allowed func(*members.Member) bool, household *Household) bool {
+	return allowed(&members.Member{District: household.District})
+}
+
+// people checks that the named fields of household list only registered
+// members. Spouse and Head hold one Id, the others any number.
+func (households *Households) people(household *Household, fields ...string) error {
+	invalid := map[string]string{}
+	for _, key := range fields {
+		ids := reflect.ValueOf(household).Elem().FieldByName(key).String()
+		for _, id := range strings.Split(ids, ";") {
+			if id = strings.TrimSpace(id); len(id) == 0 {
+				continue
+			}
+			if (key == "Head" || key == "Spouse") && strings.Contains(ids, ";") {
+				invalid[key] = "only one member"
+			} else if _, ok := households.members.Get(id); !ok {
+				invalid[key] = "no such member " + id
+			}
+		}
+	}
+	if len(invalid) != 0 {
+		return response.Invalid("household names people who are not members", invalid)
+	}
+	return nil
+}
+
+// relations are the household fields holding member Ids.
+var relations = []string{"Head", "Spouse", "Children", "Dependents"}
+
+func (households *Households) add(newhousehold *Household) (*Household, error) {
+	if err := households.people(newhousehold, relations...); err != nil {
+		return nil, err
+	}
+	households.mutex.Lock()
+	defer households.mutex.Unlock()
+	err := households.store.Insert(newhousehold)
+	if err != nil {
+		return nil, fmt.Errorf("error registering household")
+	}
+	saved := *newhousehold
+	households.households.Put(&saved)
+	return newhousehold, nil
+}
+
+func (households *Households) delete(oldhousehold *Household) (*Household, error) {
+	households.mutex.Lock()
+	defer households.mutex.Unlock()
+	if household := households.households.Get(oldhousehold.Id); household != nil {
+		err := households.store.Delete(household.Id)
+		if err != nil {
+			return nil, fmt.Errorf("error deleting household")
+		}
+		households.households.Remove(household.Id)
+		return household, nil
+	}
+	return nil, response.Errorf(http.StatusNotFound, "household does not exists")
+}
+
+func (households *Households) update(update map[string]interface{}) (*Household, error) {
+	households.mutex.Lock()
+	defer households.mutex.Unlock()
+	id, _ := update["Id"].(string)
+	for _, household := range households.households.All() {
+		if strings.EqualFold(household.Id, id) {
+			usr := *household
+			set, err := store.Apply(&usr, update)
+			if err != nil {
+				return nil, err
+			}
+			// members removed since the household was saved do not block
+			// other edits, only the relations being changed are checked
+			changed := make([]string, 0)
+			for _, key := range relations {
+				if _, ok := set[key]; ok {
+					changed = append(changed, key)
+				}
+			}
+			if err := households.people(&usr, changed...); err != nil {
+				return nil, err
+			}
+			err = households.store.Update(usr.Id, set)
+			if err != nil {
+				return nil, fmt.Errorf("error updating household %s", err)
+			}
+			saved := usr
+			households.households.Put(&saved)
+			return &usr, nil
+		}
+	}
+	return nil, response.Errorf(http.StatusNotFound, "household does not exists")
+}
+
+func (households *Households) find(id string) *Household {
+	return households.households.Get(id)
+}
+
+func (households *Households) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.URL.Path, "/household") {
+		allowed := households.members.Scope(r)
+		switch r.Method {
+		case http.MethodPost:
+			{
+				var newhousehold Household
+				if !response.Decode(w, r, &newhousehold) {
+					return
+				}
+				if !visible(allowed, &newhousehold) {
+					response.Failf(w, http.StatusForbidden, "household is outside your district")
+					return
+				}
+				newhousehold.Id = uuid.NewString()
+				u, err := households.add(&newhousehold)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		case http.MethodGet:
+			{
+				if id := r.URL.Query().Get("id"); len(id) != 0 {
+					household := households.find(id)
+					if household == nil || !visible(allowed, household) {
+						response.Failf(w, http.StatusNotFound, "household does not exists")
+						return
+					}
+					response.OK(w, households.family(household, households.members.Presenter(r)))
+					return
+				}
+				result := make([]Household, 0)
+				for _, h := range households.households.All() {
+					if visible(allowed, h) {
+						result = append(result, *h)
+					}
+				}
+				response.OK(w, result)
+				return
+			}
+		case http.MethodDelete:
+			{
+				var oldhousehold Household
+				if !response.Decode(w, r, &oldhousehold) {
+					return
+				}
+				if household := households.find(oldhousehold.Id); household != nil && !visible(allowed, household) {
+					response.Failf(w, http.StatusForbidden, "household is outside your district")
+					return
+				}
+				u, err := households.delete(&oldhousehold)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		case http.MethodPut:
+			{
+				updatehousehold := make(map[string]interface{}, 0)
+				if !response.Decode(w, r, &updatehousehold) {
+					return
+				}
+				id, _ := updatehousehold["Id"].(string)
+				if household := households.find(id); household != nil {
+					moved := *household
+					if district, ok := updatehousehold["District"].(string); ok {
+						moved.District = district
+					}
+					if !visible(allowed, household) || !visible(allowed, &moved) {
+						response.Failf(w, http.StatusForbidden, "household is outside your district")
+						return
+					}
+				}
+				u, err := households.update(updatehousehold)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package households
+
+import (
+	"net/http"
+	"testing"
+
+	"example.com/members"
+	"example.com/members/memberstest"
+	"example.com/roles"
+	"example.com/store"
+)
+
+func TestHouseholdsInDistrictScope(t *testing.T) {
+	m := memberstest.New(t,
+		members.Member{Id: "admin", Email: "admin@example.com", Role: roles.Admin},
+		members.Member{Id: "elder", Email: "elder@example.com", Role: roles.DistrictElder, District: "d1"},
+		members.Member{Id: "otieno", Email: "otieno@example.com", Role: roles.Member, District: "d1"},
+	)
+	m.Lead(memberstest.Leads{"elder": {"d1"}}, memberstest.Leads{})
+	records := store.NewMemory[Household]("Id")
+	households := NewHouseholds(records, m)
+	admin := memberstest.Login(t, m, "admin@example.com")
+	elder := memberstest.Login(t, m, "elder@example.com")
+
+	var otieno Household
+	if code := memberstest.Request(t, households, admin, http.MethodPost, "/household", `{"Name":"Otieno","Head":"otieno","District":"d1"}`, &otieno); code != http.StatusOK {
+		t.Fatalf("POST /household = %d", code)
+	}
+	memberstest.Request(t, households, admin, http.MethodPost, "/household", `{"Name":"Kariuki","District":"d2"}`, nil)
+	if code := memberstest.Request(t, households, elder, http.MethodPost, "/household", `{"Name":"Njoroge","District":"d2"}`, nil); code != http.StatusForbidden {
+		t.Fatalf("elder of d1 adding a d2 household = %d; want 403", code)
+	}
+
+	var listed []Household
+	memberstest.Request(t, households, elder, http.MethodGet, "/household", "", &listed)
+	if len(listed) != 1 || listed[0].Id != otieno.Id {
+		t.Fatalf("elder of d1 sees %+v; want the Otieno household only", listed)
+	}
+	var family Family
+	memberstest.Request(t, households, elder, http.MethodGet, "/household?id="+otieno.Id, "", &family)
+	if len(family.Members) != 1 || family.Members[0].Relation != "head" {
+		t.Fatalf("family = %+v; want otieno as head", family.Members)
+	}
+
+	if err := households.DistrictMoved("d1", "d3"); err != nil {
+		t.Fatal(err)
+	}
+	if moved, _ := records.Find(map[string]interface{}{"District": "d3"}); len(moved) != 1 || households.DistrictReferences("d1") != 0 {
+		t.Fatalf("DistrictMoved left %d households in d3", len(moved))
+	}
+}
+
+func TestFamilyShowsMembersByRole(t *testing.T) {
+	m := memberstest.New(t,
+		members.Member{Id: "otieno", Email: "otieno@example.com", Role: roles.Member, District: "d1"},
+		members.Member{Id: "akinyi", Email: "akinyi@example.com", Role: roles.Member, District: "d1", DateofBirth: "1992-03-04"},
+	)
+	m.Lead(memberstest.Leads{}, memberstest.Leads{})
+	records := store.NewMemory[Household]("Id")
+	records.Insert(&Household{Id: "h1", Name: "Otieno", Head: "otieno", Spouse: "akinyi", District: "d1"})
+	households := NewHouseholds(records, m)
+
+	var family struct {
+		Members []struct {
+			Relation string
+			Member   map[string]interface{}
+		}
+	}
+	if code := memberstest.Request(t, households, memberstest.Login(t, m, "otieno@example.com"), http.MethodGet, "/household?id=h1", "", &family); code != http.StatusOK || len(family.Members) != 2 {
+		t.Fatalf("GET /household = %d %+v", code, family)
+	}
+	for _, relative := range family.Members {
+		if _, ok := relative.Member["Password"]; ok {
+			t.Fatalf("a password hash left the server: %v", relative.Member)
+		}
+		if _, ok := relative.Member["DateofBirth"]; ok && relative.Relation == "spouse" {
+			t.Fatalf("a member sees another's birth date: %v", relative.Member)
+		}
+	}
+}
+
+func TestHouseholdsNameMembers(t *testing.T) {
+	m := memberstest.New(t,
+		members.Member{Id: "admin", Email: "admin@example.com", Role: roles.Admin},
+		members.Member{Id: "otieno", Email: "otieno@example.com", Role: roles.Member},
+		members.Member{Id: "akinyi", Email: "akinyi@example.com", Role: roles.Member},
+	)
+	m.Lead(memberstest.Leads{}, memberstest.Leads{})
+	households := NewHouseholds(store.NewMemory[Household]("Id"), m)
+	admin := memberstest.Login(t, m, "admin@example.com")
+
+	refused := []string{
+		`{"Name":"Otieno","Head":"ghost"}`,
+		`{"Name":"Otieno","Head":"otieno;akinyi"}`,
+		`{"Name":"Otieno","Head":"otieno","Children":"akinyi;ghost"}`,
+	}
+	for _, body := range refused {
+		if code := memberstest.Request(t, households, admin, http.MethodPost, "/household", body, nil); code != http.StatusBadRequest {
+			t.Errorf("POST /household %s = %d; want 400", body, code)
+		}
+	}
+	var otieno Household
+	if code := memberstest.Request(t, households, admin, http.MethodPost, "/household", `{"Name":"Otieno","Head":"otieno","Spouse":"akinyi"}`, &otieno); code != http.StatusOK {
+		t.Fatalf("POST /household = %d", code)
+	}
+	if code := memberstest.Request(t, households, admin, http.MethodPut, "/household", `{"Id":"`+otieno.Id+`","Spouse":"ghost"}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("PUT /household with an unknown spouse = %d; want 400", code)
+	}
+}
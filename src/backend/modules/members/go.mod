@@ -3,9 +3,11 @@ module example.com/members
 go 1.21.3
 
 require (
+	example.com/response v0.0.0-00010101000000-000000000000
+	example.com/roles v0.0.0-00010101000000-000000000000
+	example.com/store v0.0.0-00010101000000-000000000000
 	github.com/astaxie/beego v1.12.3
 	github.com/google/uuid v1.6.0
-	go.mongodb.org/mongo-driver v1.17.3
 	golang.org/x/crypto v0.26.0
 )
 
@@ -17,6 +19,13 @@ require (
 	github.com/xdg-go/scram v1.1.2 // indirect
 	github.com/xdg-go/stringprep v1.0.4 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20240726163527-a2c0da244d78 // indirect
+	go.mongodb.org/mongo-driver v1.17.3 // indirect
 	golang.org/x/sync v0.8.0 // indirect
 	golang.org/x/text v0.17.0 // indirect
 )
+
+replace (
+	example.com/response => ../response
+	example.com/roles => ../roles
+	example.com/store => ../store
+)
@@ -1,19 +1,22 @@
 package members
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"example.com/response"
+	"example.com/roles"
+	"example.com/store"
 	"github.com/astaxie/beego/session"
 	"github.com/google/uuid"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -32,46 +35,100 @@ type Member struct {
 	Active          bool   `bson:"Active"`
 	Role            int    `bson:"Role"`
 	Gender          string `bson:"Gender"`
+	Verified        bool   `bson:"Verified"`
+	Deactivated     string `bson:"Deactivated"`
+	// Revoked ends every session started before it, set when the password
+	// changes or the account is deactivated
+	Revoked string `bson:"Revoked"`
+}
+
+// Leadership resolves the districts or groups led by a member.
+type Leadership interface {
+	Led(memberId string) []string
+}
+
+// GroupLinks is the member to group relation that replaces the semicolon
+// separated Groups string.
+type GroupLinks interface {
+	GroupsOf(memberId string) []string
+	Validate(memberId string, groupIds []string) error
+	Sync(memberId string, groupIds []string) error
+}
+
+// Mailer queues a templated mail, see notifications.Notifications.
+type Mailer interface {
+	Queue(to, template string, data interface{}) error
 }
 
 type Members struct {
-	members        []*Member
-	db             *mongo.Database
+	members        *store.Cache[Member]
+	store          store.Store[Member]
+	tokens         store.Store[Token]
 	globalSessions *session.Manager
+	districts      Leadership
+	groups         Leadership
+	links          GroupLinks
+	mailer         Mailer
+	limits         limiter
+	// mutex serializes writes, so checks such as the duplicate email one
+	// still hold when the write lands
+	mutex sync.Mutex
 }
 
-const memberCollection = "member"
+// Collections the members module keeps its stores in.
+const (
+	MemberCollection = "member"
+	TokenCollection  = "membertoken"
+)
+
+// adminFields are the account fields only an administrator may set through
+// POST or PUT /member. Members change their own password through /me/password.
+var adminFields = []string{"Role", "Verified", "Deactivated", "Active", "Password", "Revoked"}
+
+// stampLayout is fixed width and always UTC, so session starts and
+// revocations compare as strings.
+const stampLayout = "2006-01-02T15:04:05.000000000Z"
+
+func stamp() string {
+	return time.Now().UTC().Format(stampLayout)
+}
 
-func NewMembers(db *mongo.Database, globalSessions *session.Manager) *Members {
-	members := make([]*Member, 0)
-	col := db.Collection(memberCollection)
-	result, err := col.Find(context.TODO(), bson.M{})
+// NewMembers loads the members from store. Tokens holds the password reset
+// and verification tokens, keyed by their hash.
+func NewMembers(records store.Store[Member], tokens store.Store[Token], globalSessions *session.Manager) *Members {
+	// members registered before addresses were verified keep logging in
+	err := records.Default("Verified", true)
 	if err != nil {
-		log.Fatal(err.Error())
-	} else {
-		if err = result.All(context.TODO(), &members); err != nil {
-			log.Fatal("error loading members data " + err.Error())
-		}
+		log.Fatal("error migrating members data " + err.Error())
+	}
+	members, err := records.All()
+	if err != nil {
+		log.Fatal("error loading members data " + err.Error())
 	}
-	return &Members{members: members, db: db, globalSessions: globalSessions}
+	cache := store.NewCache(members, func(member *Member) string { return member.Id })
+	cache.Follow("members", records)
+	return &Members{members: cache, store: records, tokens: tokens, globalSessions: globalSessions}
 }
 
 func (members *Members) login(username, userpassword string) (*Member, error) {
-	for _, user := range members.members {
+	for _, user := range members.members.All() {
+		if len(user.Email) == 0 || len(user.Password) == 0 {
+			continue
+		}
 		if strings.EqualFold(username, user.Name) || strings.EqualFold(username, user.Email) {
 			err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(userpassword))
 			if err != nil {
-				return nil, fmt.Errorf("wrong password provided")
+				return nil, response.Errorf(http.StatusUnauthorized, "wrong password provided")
 			}
 			return user, nil
 		}
 	}
-	return nil, fmt.Errorf("account does not exist")
+	return nil, response.Errorf(http.StatusUnauthorized, "account does not exist")
 }
 
 func (members *Members) SuperUser(useremail string) bool {
 	canedit := false
-	for _, user := range members.members {
+	for _, user := range members.members.All() {
 		if strings.EqualFold(useremail, user.Email) {
 			if user.Active {
 				canedit = true
@@ -82,84 +139,457 @@ func (members *Members) SuperUser(useremail string) bool {
 	return canedit
 }
 
-func (members *Members) Add(newmember *Member) (*Member, error) {
-	for _, member := range members.members {
-		if strings.EqualFold(member.Email, newmember.Email) {
-			return nil, fmt.Errorf("member already exists")
+// Find returns the member registered under useremail or nil.
+func (members *Members) Find(useremail string) *Member {
+	if len(useremail) == 0 {
+		return nil
+	}
+	for _, user := range members.members.All() {
+		if strings.EqualFold(useremail, user.Email) {
+			return user
 		}
 	}
-	hash, err := bcrypt.GenerateFromPassword([]byte(newmember.Password), bcrypt.DefaultCost)
+	return nil
+}
+
+// RoleOf returns the role used for permission checks. Records saved before
+// roles were enforced carry Role 0 and are treated as ordinary members;
+// inactive and deactivated accounts are guests.
+func (members *Members) RoleOf(useremail string) int {
+	user := members.Find(useremail)
+	if user == nil || !user.Active || len(user.Deactivated) != 0 {
+		return roles.Guest
+	}
+	if user.Role == roles.Guest {
+		return roles.Member
+	}
+	return user.Role
+}
+
+// Caller returns a copy of the member logged in on r, without the password
+// hash, for other modules that act on the caller's behalf.
+func (members *Members) Caller(r *http.Request) (Member, bool) {
+	caller := members.caller(r)
+	if caller == nil {
+		return Member{}, false
+	}
+	result := members.view(caller)
+	result.Password = ""
+	return result, true
+}
+
+// caller resolves the member behind the session cookie on r. Sessions of
+// deactivated accounts and sessions started before the member's sessions
+// were revoked resolve to no one.
+func (members *Members) caller(r *http.Request) *Member {
+	c, err := r.Cookie(os.Getenv("Session_Cookie"))
 	if err != nil {
-		return nil, fmt.Errorf("error processing user password")
+		return nil
+	}
+	store, err := members.globalSessions.GetProvider().SessionRead(c.Value)
+	if err != nil {
+		return nil
+	}
+	useremail, _ := store.Get("useremail").(string)
+	member := members.Find(useremail)
+	if member == nil || len(member.Deactivated) != 0 {
+		return nil
+	}
+	if since, _ := store.Get("since").(string); since < member.Revoked {
+		return nil
+	}
+	return member
+}
+
+// Lead wires in the district and group leadership used to scope what
+// district elders and group leaders can see and edit.
+func (members *Members) Lead(districts, groups Leadership) {
+	members.districts = districts
+	members.groups = groups
+}
+
+// Mail wires in the mailer used to welcome newly registered members.
+func (members *Members) Mail(mailer Mailer) {
+	members.mailer = mailer
+}
+
+// Link wires in the group membership relation and moves any Groups string
+// saved before it existed over to it. The string is cleared once moved, so a
+// member later taken out of every group is not put back on the next start.
+func (members *Members) Link(links GroupLinks) error {
+	members.mutex.Lock()
+	defer members.mutex.Unlock()
+	members.links = links
+	for _, member := range members.members.All() {
+		if len(member.Groups) == 0 {
+			continue
+		}
+		if len(links.GroupsOf(member.Id)) == 0 {
+			if err := links.Sync(member.Id, strings.Split(member.Groups, ";")); err != nil {
+				return err
+			}
+		}
+		if err := members.store.Update(member.Id, map[string]interface{}{"Groups": ""}); err != nil {
+			return err
+		}
+		saved := *member
+		saved.Groups = ""
+		members.members.Put(&saved)
+	}
+	return nil
+}
+
+// view returns a copy of member with Groups filled from the membership
+// relation, keeping the field readable for existing clients.
+func (members *Members) view(member *Member) Member {
+	result := *member
+	if members.links != nil {
+		result.Groups = strings.Join(members.links.GroupsOf(member.Id), ";")
+	}
+	return result
+}
+
+// filters pairs each member list filter with the field it reads.
+var filters = [][2]string{{"district", "District"}, {"group", "Groups"}, {"gender", "Gender"}, {"role", "Role"}, {"active", "Active"}}
+
+// filter reads the member list filters: district, group, gender, role and
+// active, allowing those reading one of fields only. Active members are
+// those marked active that have not deactivated their account.
+func filter(values url.Values, fields []string) (func(*Member) bool, error) {
+	for _, f := range filters {
+		if len(values.Get(f[0])) != 0 && !contains(fields, f[1]) {
+			return nil, fmt.Errorf("%s is not a filter you may use", f[0])
+		}
+	}
+	role := -1
+	if value := values.Get("role"); len(value) != 0 {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("role must be a role number")
+		}
+		role = n
+	}
+	active := values.Get("active")
+	if len(active) != 0 {
+		if _, err := strconv.ParseBool(active); err != nil {
+			return nil, fmt.Errorf("active must be true or false")
+		}
+	}
+	district, group, gender := values.Get("district"), values.Get("group"), values.Get("gender")
+	return func(member *Member) bool {
+		if len(district) != 0 && !strings.EqualFold(member.District, district) {
+			return false
+		}
+		if len(group) != 0 && !contains(strings.Split(member.Groups, ";"), group) {
+			return false
+		}
+		if len(gender) != 0 && !strings.EqualFold(member.Gender, gender) {
+			return false
+		}
+		if role != -1 && member.Role != role {
+			return false
+		}
+		if len(active) != 0 {
+			want, _ := strconv.ParseBool(active)
+			return want == (member.Active && len(member.Deactivated) == 0)
+		}
+		return true
+	}, nil
+}
+
+// Scope returns the check deciding which members the caller may list, add
+// and edit. District elders are limited to the districts they lead and
+// group leaders to members of their groups; everyone else is unrestricted.
+func (members *Members) Scope(r *http.Request) func(*Member) bool {
+	caller := members.caller(r)
+	if caller == nil {
+		return func(*Member) bool { return true }
+	}
+	switch members.RoleOf(caller.Email) {
+	case roles.DistrictElder:
+		led := make([]string, 0)
+		if members.districts != nil {
+			led = members.districts.Led(caller.Id)
+		}
+		return func(member *Member) bool {
+			return contains(led, member.District)
+		}
+	case roles.GroupLeader:
+		led := make([]string, 0)
+		if members.groups != nil {
+			led = members.groups.Led(caller.Id)
+		}
+		return func(member *Member) bool {
+			for _, group := range strings.Split(member.Groups, ";") {
+				if contains(led, group) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return func(*Member) bool { return true }
+}
+
+func contains(ids []string, id string) bool {
+	if len(id) == 0 {
+		return false
+	}
+	for _, x := range ids {
+		if strings.EqualFold(x, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Exists reports whether a member with the given Id is registered.
+func (members *Members) Exists(id string) bool {
+	return members.find(id) != nil
+}
+
+// Get returns a copy of the member with the given Id, with Groups filled
+// in and the password hash removed.
+func (members *Members) Get(id string) (Member, bool) {
+	member := members.find(id)
+	if member == nil {
+		return Member{}, false
+	}
+	result := members.view(member)
+	result.Password = ""
+	return result, true
+}
+
+// List returns copies of every member without password hashes.
+func (members *Members) List() []Member {
+	result := make([]Member, 0)
+	for _, member := range members.members.All() {
+		view := members.view(member)
+		view.Password = ""
+		result = append(result, view)
+	}
+	return result
+}
+
+// Search returns the members whose name contains q, ignoring case.
+func (members *Members) Search(q string) []Member {
+	result := make([]Member, 0)
+	q = strings.ToLower(strings.TrimSpace(q))
+	if len(q) == 0 {
+		return result
 	}
-	newmember.Password = string(hash)
-	bsonData, err := bson.Marshal(newmember)
+	for _, member := range members.List() {
+		if strings.Contains(strings.ToLower(member.Name), q) {
+			result = append(result, member)
+		}
+	}
+	return result
+}
+
+// find returns the member with the given Id or nil.
+func (members *Members) find(id string) *Member {
+	return members.members.Get(id)
+}
+
+// DistrictReferences counts the members belonging to a district.
+func (members *Members) DistrictReferences(id string) int {
+	count := 0
+	for _, member := range members.members.All() {
+		if strings.EqualFold(member.District, id) {
+			count++
+		}
+	}
+	return count
+}
+
+// DistrictMoved points every member of district from at district to.
+func (members *Members) DistrictMoved(from, to string) error {
+	members.mutex.Lock()
+	defer members.mutex.Unlock()
+	_, err := members.store.UpdateWhere(map[string]interface{}{"District": from}, map[string]interface{}{"District": to})
 	if err != nil {
-		return nil, fmt.Errorf("error processing member details")
+		return fmt.Errorf("error moving members %s", err)
+	}
+	for _, member := range members.members.All() {
+		if member.District == from {
+			moved := *member
+			moved.District = to
+			members.members.Put(&moved)
+		}
 	}
-	col := members.db.Collection(memberCollection)
-	_, err = col.InsertOne(context.TODO(), bsonData)
+	return nil
+}
+
+// Orphan is a member referencing districts or groups that do not exist.
+type Orphan struct {
+	Id       string
+	Name     string
+	Email    string
+	District string
+	Groups   []string
+}
+
+// Orphans lists members whose district or groups fail the given checks.
+func (members *Members) Orphans(district, group func(id string) bool) []Orphan {
+	result := make([]Orphan, 0)
+	for _, member := range members.members.All() {
+		orphan := Orphan{Id: member.Id, Name: member.Name, Email: member.Email, Groups: make([]string, 0)}
+		if len(member.District) != 0 && !district(member.District) {
+			orphan.District = member.District
+		}
+		for _, id := range strings.Split(members.view(member).Groups, ";") {
+			if len(id) != 0 && !group(id) {
+				orphan.Groups = append(orphan.Groups, id)
+			}
+		}
+		if len(orphan.District) != 0 || len(orphan.Groups) != 0 {
+			result = append(result, orphan)
+		}
+	}
+	return result
+}
+
+// admin reports whether the request was made by an administrator.
+func (members *Members) admin(r *http.Request) bool {
+	caller := members.caller(r)
+	return caller != nil && members.RoleOf(caller.Email) == roles.Admin
+}
+
+// Add registers a member. Children and dependents may be added without an
+// email or password; they are kept on record but cannot log in.
+func (members *Members) Add(newmember *Member) (*Member, error) {
+	if len(newmember.Password) != 0 {
+		hash, err := bcrypt.GenerateFromPassword([]byte(newmember.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("error processing user password")
+		}
+		newmember.Password = string(hash)
+	}
+	return members.insert(newmember)
+}
+
+// AddHashed registers a member whose Password is already a bcrypt hash, as
+// when a visitor account is promoted to membership.
+func (members *Members) AddHashed(newmember *Member) (*Member, error) {
+	return members.insert(newmember)
+}
+
+func (members *Members) insert(newmember *Member) (*Member, error) {
+	members.mutex.Lock()
+	defer members.mutex.Unlock()
+	for _, member := range members.members.All() {
+		if len(newmember.Email) != 0 && strings.EqualFold(member.Email, newmember.Email) {
+			return nil, response.Errorf(http.StatusConflict, "member already exists")
+		}
+	}
+	saved := *newmember
+	if members.links != nil {
+		if err := members.links.Validate(newmember.Id, strings.Split(newmember.Groups, ";")); err != nil {
+			return nil, err
+		}
+		// groups live in the membership relation, not on the record
+		saved.Groups = ""
+	}
+	err := members.store.Insert(&saved)
 	if err != nil {
 		return nil, fmt.Errorf("error registering user")
 	}
-	go func() {
-		members.members = append(members.members, newmember)
-	}()
+	members.members.Put(&saved)
+	if members.links != nil && len(newmember.Groups) != 0 {
+		if err := members.links.Sync(newmember.Id, strings.Split(newmember.Groups, ";")); err != nil {
+			return nil, fmt.Errorf("member registered but groups were not saved: %s", err)
+		}
+	}
+	if members.mailer != nil && len(newmember.Email) != 0 {
+		var err error
+		if newmember.Verified {
+			welcome := struct{ Name, Login, Link, Valid string }{Name: newmember.Name, Login: os.Getenv("Site_URL") + "/login"}
+			err = members.mailer.Queue(newmember.Email, "welcome", welcome)
+		} else {
+			err = members.sendVerification(newmember, "welcome")
+		}
+		if err != nil {
+			log.Println("welcome mail:", err)
+		}
+	}
 	return newmember, nil
 }
 
 func (members *Members) delete(oldmember *Member) (*Member, error) {
-	for x, member := range members.members {
+	members.mutex.Lock()
+	defer members.mutex.Unlock()
+	for _, member := range members.members.All() {
 		if strings.EqualFold(member.Email, oldmember.Email) && strings.EqualFold(member.Id, oldmember.Id) {
-			bsonData, err := bson.Marshal(oldmember)
-			if err != nil {
-				return nil, fmt.Errorf("error processing member details")
-			}
-			col := members.db.Collection(memberCollection)
-			_, err = col.DeleteOne(context.TODO(), bsonData)
+			err := members.store.Delete(member.Id)
 			if err != nil {
 				return nil, fmt.Errorf("error deleting user")
 			}
-			go func() {
-				members.members = append(members.members[:x], members.members[x+1:]...)
-			}()
+			members.members.Remove(member.Id)
+			if members.links != nil {
+				if err := members.links.Sync(member.Id, nil); err != nil {
+					return nil, fmt.Errorf("member deleted but group memberships were not removed: %s", err)
+				}
+			}
 			return oldmember, nil
 		}
 	}
-	return nil, fmt.Errorf("member account does not exists")
+	return nil, response.Errorf(http.StatusNotFound, "member account does not exists")
 }
 
+// update applies only the fields present in the request to the stored
+// member, so a partial edit cannot reset its role or password.
 func (members *Members) update(update map[string]interface{}) (*Member, error) {
-	usr := Member{}
-	for key, value := range update {
-		field := reflect.ValueOf(&usr).Elem().FieldByName(key)
-		if field.IsValid() && field.CanSet() {
-			val := reflect.ValueOf(value)
-			if field.Type() == val.Type() {
-				field.Set(val)
-			} else {
-				return nil, fmt.Errorf("type mismatch for field %s", key)
-			}
-		}
-	}
-	for _, member := range members.members {
-		if strings.EqualFold(member.Email, usr.Email) && strings.EqualFold(member.Id, usr.Id) {
-			update := bson.M{}
-			bsonData, err := bson.Marshal(usr)
+	members.mutex.Lock()
+	defer members.mutex.Unlock()
+	id, _ := update["Id"].(string)
+	email, _ := update["Email"].(string)
+	for _, member := range members.members.All() {
+		if strings.EqualFold(member.Email, email) && strings.EqualFold(member.Id, id) {
+			usr := *member
+			set, err := store.Apply(&usr, update)
 			if err != nil {
-				return nil, fmt.Errorf("error updating member %s", err)
+				return nil, err
+			}
+			_, revoke := set["Password"]
+			if revoke {
+				hash, err := bcrypt.GenerateFromPassword([]byte(usr.Password), bcrypt.DefaultCost)
+				if err != nil {
+					return nil, fmt.Errorf("error processing user password")
+				}
+				usr.Password = string(hash)
+				set["Password"] = usr.Password
 			}
-			bson.Unmarshal(bsonData, &update)
-			col := members.db.Collection(memberCollection)
-			_, err = col.UpdateOne(context.TODO(), bson.M{"Id": usr.Id}, bson.M{"$set": update})
+			if _, ok := set["Deactivated"]; ok && len(usr.Deactivated) != 0 {
+				revoke = true
+			}
+			if revoke {
+				usr.Revoked = stamp()
+				set["Revoked"] = usr.Revoked
+			}
+			_, regroup := set["Groups"]
+			saved := usr
+			if regroup && members.links != nil {
+				if err := members.links.Validate(usr.Id, strings.Split(usr.Groups, ";")); err != nil {
+					return nil, err
+				}
+				// groups live in the membership relation, not on the record
+				saved.Groups = ""
+				set["Groups"] = ""
+			}
+			err = members.store.Update(usr.Id, set)
 			if err != nil {
 				return nil, fmt.Errorf("error updating member %s", err)
 			}
-			*member = usr
+			members.members.Put(&saved)
+			if regroup && members.links != nil {
+				if err := members.links.Sync(usr.Id, strings.Split(usr.Groups, ";")); err != nil {
+					return nil, fmt.Errorf("member updated but groups were not saved: %s", err)
+				}
+			}
 			return &usr, nil
 		}
 	}
-	return nil, fmt.Errorf("member account does not exists")
+	return nil, response.Errorf(http.StatusNotFound, "member account does not exists")
 }
 
 func (members *Members) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -168,92 +598,168 @@ func (members *Members) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			NameEmail string
 			Password  string
 		}
-		err := json.NewDecoder(r.Body).Decode(&credentials)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+		if !response.Decode(w, r, &credentials) {
 			return
 		}
 		user, err := members.login(credentials.NameEmail, credentials.Password)
 		if err != nil {
-			res := struct{ Error string }{Error: err.Error()}
-			json.NewEncoder(w).Encode(res)
+			response.Fail(w, err)
+			return
+		}
+		if len(user.Deactivated) != 0 {
+			response.Failf(w, http.StatusForbidden, "this account has been deactivated, contact the church office to restore it")
+			return
+		}
+		if !user.Verified {
+			response.Failf(w, http.StatusForbidden, "confirm your email address with the link mailed to you before logging in")
 			return
 		}
-		if user.Role == 1 && !user.Active {
-			res := struct{ Error string }{Error: fmt.Errorf("contact the system administrator to activate account").Error()}
-			json.NewEncoder(w).Encode(res)
+		if !user.Active {
+			response.Failf(w, http.StatusForbidden, "contact the system administrator to activate account")
 			return
 		}
 		sess, err := members.globalSessions.SessionStart(w, r)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			response.Fail(w, err)
 			return
 		}
 		defer sess.SessionRelease(w)
 		sess.Set("useremail", user.Email)
+		sess.Set("since", stamp())
 		http.SetCookie(w, &http.Cookie{Name: os.Getenv("Session_Cookie"), Value: sess.SessionID(), Path: "/", HttpOnly: false, Secure: true})
-		json.NewEncoder(w).Encode(user)
+		response.OK(w, user.Admin())
 		return
 	} else if strings.EqualFold(r.URL.Path, "/logout") {
 		members.globalSessions.SessionDestroy(w, r)
+	} else if strings.EqualFold(r.URL.Path, "/me") || strings.HasPrefix(strings.ToLower(r.URL.Path), "/me/") {
+		members.me(w, r)
+	} else if strings.HasPrefix(strings.ToLower(r.URL.Path), "/password/") || strings.HasPrefix(strings.ToLower(r.URL.Path), "/verify") {
+		members.recovery(w, r)
 	} else if strings.EqualFold(r.URL.Path, "/member") {
 		switch r.Method {
 		case http.MethodPost:
 			{
 				var newmember Member
-				err := json.NewDecoder(r.Body).Decode(&newmember)
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
+				if !response.Decode(w, r, &newmember) {
 					return
 				}
 				newmember.Id = uuid.NewString()
+				if !members.admin(r) {
+					// elders and leaders register members an admin still has to activate
+					record := reflect.ValueOf(&newmember).Elem()
+					for _, field := range adminFields {
+						value := record.FieldByName(field)
+						value.Set(reflect.Zero(value.Type()))
+					}
+				}
+				if newmember.Role == roles.Guest {
+					newmember.Role = roles.Member
+				}
+				if !members.Scope(r)(&newmember) {
+					response.Failf(w, http.StatusForbidden, "member is outside your district or group")
+					return
+				}
 				u, err := members.Add(&newmember)
 				if err != nil {
-					json.NewEncoder(w).Encode(fmt.Sprintf("{error: %s}", err.Error()))
+					response.Fail(w, err)
 					return
 				}
-				json.NewEncoder(w).Encode(u)
+				response.OK(w, members.Presenter(r)(members.view(u)))
 				return
 			}
 		case http.MethodGet:
 			{
+				fields := members.listable(r)
+				query, err := store.ParseQuery(r.URL.Query(), fields...)
+				if err != nil {
+					response.Failf(w, http.StatusBadRequest, "%s", err)
+					return
+				}
+				match, err := filter(r.URL.Query(), fields)
+				if err != nil {
+					response.Failf(w, http.StatusBadRequest, "%s", err)
+					return
+				}
+				allowed := members.Scope(r)
 				result := make([]Member, 0)
-				for _, m := range members.members {
-					result = append(result, *m)
+				for _, m := range members.members.All() {
+					if v := members.view(m); allowed(&v) && match(&v) && query.Matches(v.Name, v.Email, v.Contacts) {
+						result = append(result, v)
+					}
 				}
-				json.NewEncoder(w).Encode(result)
+				page, err := store.SelectPage(store.Convert(store.Paginate(result, query), members.Presenter(r)), store.Fields(r.URL.Query()))
+				if err != nil {
+					response.Failf(w, http.StatusBadRequest, "%s", err)
+					return
+				}
+				response.OK(w, page)
 				return
 			}
 		case http.MethodDelete:
 			{
 				var newmember Member
-				err := json.NewDecoder(r.Body).Decode(&newmember)
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
-
+				if !response.Decode(w, r, &newmember) {
+					return
+				}
+				if target := members.find(newmember.Id); target != nil {
+					if current := members.view(target); !members.Scope(r)(&current) {
+						response.Failf(w, http.StatusForbidden, "member is outside your district or group")
+						return
+					}
 				}
 				u, err := members.delete(&newmember)
 				if err != nil {
-					json.NewEncoder(w).Encode(fmt.Sprintf("{error: %s}", err.Error()))
+					response.Fail(w, err)
 					return
 				}
-				json.NewEncoder(w).Encode(u)
+				response.OK(w, members.Presenter(r)(members.view(u)))
 				return
 			}
 		case http.MethodPut:
 			{
 				updatemember := make(map[string]interface{}, 0)
-				err := json.NewDecoder(r.Body).Decode(&updatemember)
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
+				if !response.Decode(w, r, &updatemember) {
 					return
 				}
+				admin := members.admin(r)
+				if !admin {
+					for _, field := range adminFields {
+						if _, ok := updatemember[field]; ok {
+							response.Failf(w, http.StatusForbidden, "only an administrator can change %s", field)
+							return
+						}
+					}
+				}
+				id, _ := updatemember["Id"].(string)
+				if target := members.find(id); target != nil {
+					if !admin && members.RoleOf(target.Email) == roles.Admin {
+						response.Failf(w, http.StatusForbidden, "only an administrator can edit an administrator")
+						return
+					}
+					if email, ok := updatemember["Email"].(string); ok && !admin && !strings.EqualFold(email, target.Email) {
+						response.Failf(w, http.StatusForbidden, "only an administrator can change Email")
+						return
+					}
+					allowed := members.Scope(r)
+					current := members.view(target)
+					moved := current
+					if district, ok := updatemember["District"].(string); ok {
+						moved.District = district
+					}
+					if groups, ok := updatemember["Groups"].(string); ok {
+						moved.Groups = groups
+					}
+					if !allowed(&current) || !allowed(&moved) {
+						response.Failf(w, http.StatusForbidden, "member is outside your district or group")
+						return
+					}
+				}
 				u, err := members.update(updatemember)
 				if err != nil {
-					json.NewEncoder(w).Encode(fmt.Sprintf("{error: %s}", err.Error()))
+					response.Fail(w, err)
 					return
 				}
-				json.NewEncoder(w).Encode(u)
+				response.OK(w, members.Presenter(r)(members.view(u)))
 				return
 
 			}
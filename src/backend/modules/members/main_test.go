@@ -0,0 +1,296 @@
+package members_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"example.com/members"
+	"example.com/members/memberstest"
+	"example.com/response"
+	"example.com/roles"
+	"example.com/store"
+)
+
+var request = memberstest.Request
+var login = memberstest.Login
+
+// newMembers holds an admin, an elder of district d1 and members in
+// districts d1 and d2.
+func newMembers(t *testing.T) *members.Members {
+	m := memberstest.New(t,
+		members.Member{Id: "admin", Name: "Admin", Email: "admin@example.com", Role: roles.Admin, District: "d2"},
+		members.Member{Id: "elder", Name: "Elder", Email: "elder@example.com", Role: roles.DistrictElder, District: "d1"},
+		members.Member{Id: "wanjiru", Name: "Wanjiru", Email: "wanjiru@example.com", Role: roles.Member, District: "d1", DateofBirth: "1990-01-01"},
+		members.Member{Id: "kamau", Name: "Kamau", Email: "kamau@example.com", Role: roles.Member, District: "d2"},
+	)
+	m.Lead(memberstest.Leads{"elder": {"d1"}}, memberstest.Leads{})
+	return m
+}
+
+func TestLogin(t *testing.T) {
+	m := newMembers(t)
+	login(t, m, "wanjiru@example.com")
+	if code := request(t, m, nil, http.MethodPost, "/login", `{"NameEmail":"wanjiru@example.com","Password":"wrong"}`, nil); code != http.StatusUnauthorized {
+		t.Fatalf("login with a wrong password = %d; want 401", code)
+	}
+}
+
+func TestMemberListScopeAndViews(t *testing.T) {
+	m := newMembers(t)
+
+	elder := login(t, m, "elder@example.com")
+	var elderPage store.Page[members.AdminMember]
+	request(t, m, elder, http.MethodGet, "/member?sort=Name", "", &elderPage)
+	if elderPage.Total != 2 || elderPage.Items[0].Id != "elder" || elderPage.Items[1].DateofBirth != "1990-01-01" {
+		t.Fatalf("elder of d1 sees %+v; want the two d1 members in full", elderPage.Items)
+	}
+
+	var memberPage store.Page[map[string]interface{}]
+	request(t, m, login(t, m, "kamau@example.com"), http.MethodGet, "/member?district=d1", "", &memberPage)
+	if memberPage.Total != 2 {
+		t.Fatalf("member filtering on d1 got %d; want 2", memberPage.Total)
+	}
+	for _, item := range memberPage.Items {
+		if _, ok := item["DateofBirth"]; ok {
+			t.Fatalf("a member sees another's birth date: %v", item)
+		}
+		if _, ok := item["Password"]; ok {
+			t.Fatalf("a password hash left the server: %v", item)
+		}
+	}
+
+	kamau := login(t, m, "kamau@example.com")
+	for _, target := range []string{"/member?sort=DateofBirth", "/member?sort=-Role", "/member?gender=F", "/member?role=1", "/member?active=true"} {
+		if code := request(t, m, kamau, http.MethodGet, target, "", nil); code != http.StatusBadRequest {
+			t.Fatalf("member asking for %s = %d; want 400", target, code)
+		}
+	}
+	if code := request(t, m, nil, http.MethodGet, "/member?district=d1", "", nil); code != http.StatusBadRequest {
+		t.Fatalf("guest filtering by district = %d; want 400", code)
+	}
+	if code := request(t, m, elder, http.MethodGet, "/member?sort=DateofBirth&role=2", "", nil); code != http.StatusOK {
+		t.Fatalf("elder sorting by birth date = %d; want 200", code)
+	}
+
+	var picker store.Page[map[string]interface{}]
+	if code := request(t, m, nil, http.MethodGet, "/member?fields=Id,Name&limit=1", "", &picker); code != http.StatusOK || len(picker.Items) != 1 || len(picker.Items[0]) != 2 {
+		t.Fatalf("guest picker = %d %+v", code, picker)
+	}
+	if code := request(t, m, nil, http.MethodGet, "/member?fields=Email", "", nil); code != http.StatusBadRequest {
+		t.Fatalf("guest asking for emails = %d; want 400", code)
+	}
+}
+
+func TestPresenterKeepsElderToScope(t *testing.T) {
+	m := newMembers(t)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(login(t, m, "elder@example.com"))
+	present := m.Presenter(r)
+	for id, whole := range map[string]bool{"elder": true, "wanjiru": true, "kamau": false, "admin": false} {
+		member, _ := m.Get(id)
+		if _, ok := present(member).(members.AdminMember); ok != whole {
+			t.Fatalf("elder of d1 sees %s as %T", id, present(member))
+		}
+	}
+}
+
+func TestMemberUpdateInScope(t *testing.T) {
+	m := newMembers(t)
+	elder := login(t, m, "elder@example.com")
+
+	var updated members.AdminMember
+	if code := request(t, m, elder, http.MethodPut, "/member", `{"Id":"wanjiru","Email":"wanjiru@example.com","Contacts":"0712345678"}`, &updated); code != http.StatusOK || updated.Contacts != "0712345678" {
+		t.Fatalf("elder editing a d1 member = %d %+v", code, updated)
+	}
+	if member, _ := m.Get("wanjiru"); member.Contacts != "0712345678" || member.Role != roles.Member {
+		t.Fatalf("edit was not saved as given: %+v", member)
+	}
+	if code := request(t, m, elder, http.MethodPut, "/member", `{"Id":"kamau","Email":"kamau@example.com","Contacts":"0700000000"}`, nil); code != http.StatusForbidden {
+		t.Fatalf("elder editing a d2 member = %d; want 403", code)
+	}
+	if code := request(t, m, elder, http.MethodPut, "/member", `{"Id":"wanjiru","Email":"wanjiru@example.com","Role":1}`, nil); code != http.StatusForbidden {
+		t.Fatalf("elder changing a role = %d; want 403", code)
+	}
+}
+
+func TestMemberUpdateAccountFields(t *testing.T) {
+	m := newMembers(t)
+	elder := login(t, m, "elder@example.com")
+	refused := []string{
+		`{"Id":"wanjiru","Email":"wanjiru@example.com","Password":"taken"}`,
+		`{"Id":"wanjiru","Email":"wanjiru@example.com","Active":false}`,
+		`{"Id":"wanjiru","Email":"elder@example.com"}`,
+	}
+	for _, body := range refused {
+		if code := request(t, m, elder, http.MethodPut, "/member", body, nil); code != http.StatusForbidden {
+			t.Errorf("elder sending %s = %d; want 403", body, code)
+		}
+	}
+	if member, _ := m.Get("wanjiru"); !member.Active {
+		t.Fatalf("a refused edit reached the record: %+v", member)
+	}
+
+	// an admin placed in the elder's district is still out of reach
+	admin := login(t, m, "admin@example.com")
+	request(t, m, admin, http.MethodPut, "/member", `{"Id":"admin","Email":"admin@example.com","District":"d1"}`, nil)
+	if code := request(t, m, elder, http.MethodPut, "/member", `{"Id":"admin","Email":"admin@example.com","Contacts":"0700000000"}`, nil); code != http.StatusForbidden {
+		t.Fatalf("elder editing an admin = %d; want 403", code)
+	}
+	if code := request(t, m, admin, http.MethodPut, "/member", `{"Id":"wanjiru","Email":"wanjiru@example.com","Active":false}`, nil); code != http.StatusOK {
+		t.Fatalf("admin deactivating a member = %d; want 200", code)
+	}
+}
+
+func TestMemberRegistrationAccountFields(t *testing.T) {
+	m := newMembers(t)
+	elder := login(t, m, "elder@example.com")
+	body := `{"Name":"Otieno","Email":"otieno@example.com","District":"d1","Role":3,"Verified":true,"Active":true,"Password":"chosen","Revoked":"x","Deactivated":"x"}`
+	if code := request(t, m, elder, http.MethodPost, "/member", body, nil); code != http.StatusOK {
+		t.Fatalf("elder registering a d1 member = %d", code)
+	}
+	member := m.Find("otieno@example.com")
+	if member == nil {
+		t.Fatal("member was not registered")
+	}
+	if member.Role != roles.Member || member.Verified || member.Active || len(member.Password) != 0 || len(member.Revoked) != 0 || len(member.Deactivated) != 0 {
+		t.Fatalf("elder set account fields on a new member: %+v", member)
+	}
+	if code := request(t, m, nil, http.MethodPost, "/login", `{"NameEmail":"otieno@example.com","Password":"chosen"}`, nil); code == http.StatusOK {
+		t.Fatal("a member registered by an elder logged in with the password the elder chose")
+	}
+
+	admin := login(t, m, "admin@example.com")
+	body = `{"Name":"Akinyi","Email":"akinyi@example.com","Role":3,"Verified":true,"Active":true,"Password":"chosen"}`
+	if code := request(t, m, admin, http.MethodPost, "/member", body, nil); code != http.StatusOK {
+		t.Fatalf("admin registering a member = %d", code)
+	}
+	if member := m.Find("akinyi@example.com"); member == nil || member.Role != roles.DistrictElder || !member.Active || !member.Verified {
+		t.Fatalf("admin registration lost its account fields: %+v", member)
+	}
+}
+
+func TestPasswordChangeEndsOtherSessions(t *testing.T) {
+	m := newMembers(t)
+	phone := login(t, m, "wanjiru@example.com")
+	laptop := login(t, m, "wanjiru@example.com")
+	body := `{"Current":"` + memberstest.Password + `","Password":"a new password"}`
+	if code := request(t, m, laptop, http.MethodPut, "/me/password", body, nil); code != http.StatusOK {
+		t.Fatalf("PUT /me/password = %d", code)
+	}
+	if code := request(t, m, phone, http.MethodGet, "/me", "", nil); code != http.StatusUnauthorized {
+		t.Fatalf("a session from before the change = %d; want 401", code)
+	}
+	if code := request(t, m, laptop, http.MethodGet, "/me", "", nil); code != http.StatusOK {
+		t.Fatalf("the session that changed the password = %d; want 200", code)
+	}
+}
+
+func TestDeactivatedMembersAreGuests(t *testing.T) {
+	m := newMembers(t)
+	admin := login(t, m, "admin@example.com")
+	session := login(t, m, "kamau@example.com")
+	request(t, m, admin, http.MethodPut, "/member", `{"Id":"kamau","Email":"kamau@example.com","Deactivated":"2030-01-01T00:00:00Z"}`, nil)
+	if m.RoleOf("kamau@example.com") != roles.Guest {
+		t.Fatalf("a deactivated member has role %d; want guest", m.RoleOf("kamau@example.com"))
+	}
+	if code := request(t, m, session, http.MethodGet, "/me", "", nil); code != http.StatusUnauthorized {
+		t.Fatalf("a deactivated member's session = %d; want 401", code)
+	}
+	// restoring the account does not bring the old session back
+	request(t, m, admin, http.MethodPut, "/member", `{"Id":"kamau","Email":"kamau@example.com","Deactivated":""}`, nil)
+	if code := request(t, m, session, http.MethodGet, "/me", "", nil); code != http.StatusUnauthorized {
+		t.Fatalf("a session from before deactivation = %d; want 401", code)
+	}
+	login(t, m, "kamau@example.com")
+}
+
+// links is a members.GroupLinks kept in a map.
+type links map[string][]string
+
+func (l links) GroupsOf(memberId string) []string { return l[memberId] }
+
+// Validate refuses the group named gone, standing in for a deleted one.
+func (l links) Validate(memberId string, groupIds []string) error {
+	for _, id := range groupIds {
+		if id == "gone" {
+			return response.Invalid("member names an unknown group", map[string]string{"Groups": "no such group gone"})
+		}
+	}
+	return nil
+}
+func (l links) Sync(memberId string, groupIds []string) error {
+	l[memberId] = groupIds
+	return nil
+}
+
+func TestLinkMovesLegacyGroupsOnce(t *testing.T) {
+	m := memberstest.New(t, members.Member{Id: "wanjiru", Name: "Wanjiru", Email: "wanjiru@example.com", Groups: "choir;youth"})
+	relation := links{}
+	if err := m.Link(relation); err != nil {
+		t.Fatal(err)
+	}
+	if got := relation["wanjiru"]; len(got) != 2 {
+		t.Fatalf("Link moved %v; want both groups", got)
+	}
+	// leaving every group must survive the next start
+	relation.Sync("wanjiru", nil)
+	if err := m.Link(relation); err != nil {
+		t.Fatal(err)
+	}
+	if got := relation["wanjiru"]; len(got) != 0 {
+		t.Fatalf("Link imported the old string again: %v", got)
+	}
+}
+
+func TestUnknownGroupsAreRefused(t *testing.T) {
+	m := newMembers(t)
+	relation := links{}
+	if err := m.Link(relation); err != nil {
+		t.Fatal(err)
+	}
+	admin := login(t, m, "admin@example.com")
+	if code := request(t, m, admin, http.MethodPost, "/member", `{"Name":"Akinyi","Email":"akinyi@example.com","Groups":"choir;gone"}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("registering a member in a deleted group = %d; want 400", code)
+	}
+	if m.Find("akinyi@example.com") != nil {
+		t.Fatal("a refused registration was saved")
+	}
+	request(t, m, admin, http.MethodPut, "/member", `{"Id":"kamau","Email":"kamau@example.com","Groups":"choir"}`, nil)
+	if code := request(t, m, admin, http.MethodPut, "/member", `{"Id":"kamau","Email":"kamau@example.com","Groups":"gone"}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("moving a member into a deleted group = %d; want 400", code)
+	}
+	if kamau, _ := m.Get("kamau"); kamau.Groups != "choir" {
+		t.Fatalf("a refused edit moved the member to %q", kamau.Groups)
+	}
+}
+
+// mailbox is a members.Mailer counting the mail queued per address.
+type mailbox struct {
+	mutex  sync.Mutex
+	queued map[string]int
+}
+
+func (box *mailbox) Queue(to, template string, data interface{}) error {
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+	box.queued[to]++
+	return nil
+}
+
+func TestRecoveryIsRateLimited(t *testing.T) {
+	m := newMembers(t)
+	box := &mailbox{queued: map[string]int{}}
+	m.Mail(box)
+	codes := make([]int, 0)
+	for i := 0; i < 12; i++ {
+		codes = append(codes, request(t, m, nil, http.MethodPost, "/password/forgot", `{"Email":"wanjiru@example.com"}`, nil))
+	}
+	if box.queued["wanjiru@example.com"] != 3 {
+		t.Fatalf("%d reset mails went to one address; want 3", box.queued["wanjiru@example.com"])
+	}
+	if codes[9] != http.StatusOK || codes[10] != http.StatusTooManyRequests {
+		t.Fatalf("forgot replies %v; want 429 from the eleventh request", codes)
+	}
+}
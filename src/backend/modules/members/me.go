@@ -0,0 +1,119 @@
+package members
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/response"
+	"example.com/store"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// editable lists the fields members may change on their own record.
+// Email, district, groups, role and the sacramental dates are kept by the
+// church office.
+var editable = map[string]bool{
+	"Name":        true,
+	"Contacts":    true,
+	"DateofBirth": true,
+	"Gender":      true,
+	"Passport":    true,
+}
+
+// me serves the caller's own record: GET and PUT /me, PUT /me/password and
+// POST /me/deactivate. The member always comes from the session, never from
+// the request body.
+func (members *Members) me(w http.ResponseWriter, r *http.Request) {
+	caller := members.caller(r)
+	if caller == nil {
+		response.Failf(w, http.StatusUnauthorized, "log in to manage your profile")
+		return
+	}
+	path := strings.ToLower(r.URL.Path)
+	switch {
+	case path == "/me" && r.Method == http.MethodGet:
+		{
+			result, err := store.Select(members.view(caller).Admin(), store.Fields(r.URL.Query()))
+			if err != nil {
+				response.Failf(w, http.StatusBadRequest, "%s", err)
+				return
+			}
+			response.OK(w, result)
+		}
+	case path == "/me" && r.Method == http.MethodPut:
+		{
+			update := make(map[string]interface{}, 0)
+			if !response.Decode(w, r, &update) {
+				return
+			}
+			delete(update, "Id")
+			delete(update, "Email")
+			for key := range update {
+				if !editable[key] {
+					response.Failf(w, http.StatusForbidden, "%s can only be changed by the church office", key)
+					return
+				}
+			}
+			update["Id"], update["Email"] = caller.Id, caller.Email
+			u, err := members.update(update)
+			if err != nil {
+				response.Fail(w, err)
+				return
+			}
+			response.OK(w, members.view(u).Admin())
+		}
+	case path == "/me/password" && r.Method == http.MethodPut:
+		{
+			var change struct {
+				Current  string
+				Password string
+			}
+			if !response.Decode(w, r, &change) {
+				return
+			}
+			if bcrypt.CompareHashAndPassword([]byte(caller.Password), []byte(change.Current)) != nil {
+				response.Failf(w, http.StatusForbidden, "the current password is wrong")
+				return
+			}
+			if len(change.Password) < 8 {
+				response.Fail(w, response.Invalid("the password must be at least 8 characters long", map[string]string{"Password": "at least 8 characters"}))
+				return
+			}
+			u, err := members.update(map[string]interface{}{"Id": caller.Id, "Email": caller.Email, "Password": change.Password})
+			if err != nil {
+				response.Fail(w, err)
+				return
+			}
+			// every other session was revoked with the old password; this
+			// one carries on
+			if sess, err := members.globalSessions.SessionStart(w, r); err == nil {
+				sess.Set("since", u.Revoked)
+				sess.SessionRelease(w)
+			}
+			response.OK(w, struct{ Message string }{Message: "Your password has been changed."})
+		}
+	case path == "/me/deactivate" && r.Method == http.MethodPost:
+		{
+			// the record stays so sacraments, giving and attendance that
+			// refer to it are kept; only the login is closed
+			var confirm struct{ Password string }
+			if !response.Decode(w, r, &confirm) {
+				return
+			}
+			if bcrypt.CompareHashAndPassword([]byte(caller.Password), []byte(confirm.Password)) != nil {
+				response.Failf(w, http.StatusForbidden, "the password is wrong")
+				return
+			}
+			_, err := members.update(map[string]interface{}{"Id": caller.Id, "Email": caller.Email, "Active": false, "Deactivated": time.Now().Format(time.RFC3339)})
+			if err != nil {
+				response.Fail(w, err)
+				return
+			}
+			members.globalSessions.SessionDestroy(w, r)
+			response.OK(w, struct{ Message string }{Message: "Your account has been deactivated."})
+		}
+	default:
+		response.Failf(w, http.StatusMethodNotAllowed, "%s is not served on %s", r.Method, r.URL.Path)
+	}
+}
@@ -0,0 +1,84 @@
+// Package memberstest runs members on in-memory stores and sessions, for
+// testing the modules that build on members without a database.
+package memberstest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"example.com/members"
+	"example.com/store"
+	"github.com/astaxie/beego/session"
+)
+
+// Cookie names the session cookie used in tests.
+const Cookie = "session"
+
+// Password is the password every member made by New logs in with.
+const Password = "password"
+
+// Leads is a members.Leadership mapping a member Id to what they lead.
+type Leads map[string][]string
+
+func (leads Leads) Led(memberId string) []string { return leads[memberId] }
+
+// New returns Members holding records, each active, verified and with
+// Password.
+func New(t testing.TB, records ...members.Member) *members.Members {
+	t.Helper()
+	os.Setenv("Session_Cookie", Cookie)
+	sessions, err := session.NewManager("memory", &session.ManagerConfig{CookieName: Cookie, Gclifetime: 3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := members.NewMembers(store.NewMemory[members.Member]("Id"), store.NewMemory[members.Token]("_id"), sessions)
+	for _, member := range records {
+		member := member
+		member.Password, member.Active, member.Verified = Password, true, true
+		if _, err := m.Add(&member); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m
+}
+
+// Login logs email in and returns the session cookie.
+func Login(t testing.TB, m *members.Members, email string) *http.Cookie {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"NameEmail":"`+email+`","Password":"`+Password+`"}`))
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login %s = %d %s", email, rec.Code, rec.Body.String())
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == Cookie {
+			return c
+		}
+	}
+	t.Fatalf("login %s set no session cookie", email)
+	return nil
+}
+
+// Request sends a JSON request to handler as the holder of session, nil for
+// a guest, decodes the reply into v unless it is nil and returns the status.
+func Request(t testing.TB, handler http.Handler, session *http.Cookie, method, target, body string, v interface{}) int {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if session != nil {
+		req.AddCookie(session)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: decoding %q: %s", method, target, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
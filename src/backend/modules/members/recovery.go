@@ -0,0 +1,184 @@
+package members
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/response"
+)
+
+// sent is the reply to forgot and resend requests. It is the same whether
+// or not the address is registered so the form cannot be used to find out
+// who is a member.
+var sent = struct{ Message string }{Message: "If that address belongs to an account, a mail with a link is on its way."}
+
+// Limits on the forgot and resend forms. An address is sent at most
+// mailsPerAddress links and a client may post at most requestsPerClient
+// times within recoveryWindow.
+const (
+	recoveryWindow    = time.Hour
+	mailsPerAddress   = 3
+	requestsPerClient = 10
+)
+
+// limiter counts recent requests by key.
+type limiter struct {
+	mutex  sync.Mutex
+	recent map[string][]time.Time
+}
+
+// allow records a request for key and reports whether fewer than max were
+// made within recoveryWindow before it. Requests older than the window are
+// forgotten.
+func (limiter *limiter) allow(key string, max int) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if limiter.recent == nil {
+		limiter.recent = make(map[string][]time.Time)
+	}
+	since := time.Now().Add(-recoveryWindow)
+	for k, times := range limiter.recent {
+		kept := times[:0]
+		for _, t := range times {
+			if t.After(since) {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) == 0 {
+			delete(limiter.recent, k)
+		} else {
+			limiter.recent[k] = kept
+		}
+	}
+	if len(limiter.recent[key]) >= max {
+		return false
+	}
+	limiter.recent[key] = append(limiter.recent[key], time.Now())
+	return true
+}
+
+// client names the address a request came from.
+func client(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// link builds a frontend URL carrying token.
+func link(page, token string) string {
+	return os.Getenv("Site_URL") + "/" + page + "?token=" + url.QueryEscape(token)
+}
+
+// sendVerification mails member a link confirming their email address.
+func (members *Members) sendVerification(member *Member, template string) error {
+	if members.mailer == nil {
+		return fmt.Errorf("mail is not configured")
+	}
+	token, err := members.issue(member.Id, Verify, verifyValid)
+	if err != nil {
+		return err
+	}
+	data := struct{ Name, Login, Link, Valid string }{member.Name, os.Getenv("Site_URL") + "/login", link("verify", token), valid(verifyValid)}
+	return members.mailer.Queue(member.Email, template, data)
+}
+
+// sendReset mails member a link for choosing a new password.
+func (members *Members) sendReset(member *Member) error {
+	if members.mailer == nil {
+		return fmt.Errorf("mail is not configured")
+	}
+	token, err := members.issue(member.Id, Reset, resetValid)
+	if err != nil {
+		return err
+	}
+	data := struct{ Name, Link, Valid string }{member.Name, link("reset", token), valid(resetValid)}
+	return members.mailer.Queue(member.Email, "reset", data)
+}
+
+// recovery serves the password reset and email verification routes, none
+// of which need a session.
+func (members *Members) recovery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.Failf(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	var request struct {
+		Email    string
+		Token    string
+		Password string
+	}
+	if !response.Decode(w, r, &request) {
+		return
+	}
+	path := strings.ToLower(r.URL.Path)
+	switch path {
+	case "/password/forgot", "/verify/resend":
+		{
+			if !members.limits.allow("client/"+client(r), requestsPerClient) {
+				response.Failf(w, http.StatusTooManyRequests, "too many requests, try again later")
+				return
+			}
+			// children and dependents without a password have no login to
+			// recover, and verified members have nothing to confirm. An
+			// address that has had its share of links is answered the same
+			// way, so the limit does not tell members from strangers.
+			member := members.Find(strings.TrimSpace(request.Email))
+			if member != nil && len(member.Password) != 0 && members.limits.allow("address/"+strings.ToLower(member.Email), mailsPerAddress) {
+				var err error
+				if path == "/password/forgot" {
+					err = members.sendReset(member)
+				} else if !member.Verified {
+					err = members.sendVerification(member, "verify")
+				}
+				if err != nil {
+					log.Println(path, err)
+				}
+			}
+			response.OK(w, sent)
+		}
+	case "/password/reset":
+		{
+			if len(request.Password) < 8 {
+				response.Fail(w, response.Invalid("the password must be at least 8 characters long", map[string]string{"Password": "at least 8 characters"}))
+				return
+			}
+			member, err := members.redeem(request.Token, Reset)
+			if err != nil {
+				response.Fail(w, err)
+				return
+			}
+			// following the mailed link proves the address as well
+			_, err = members.update(map[string]interface{}{"Id": member.Id, "Email": member.Email, "Password": request.Password, "Verified": true})
+			if err != nil {
+				response.Fail(w, err)
+				return
+			}
+			response.OK(w, struct{ Message string }{Message: "Your password has been changed. You can now log in."})
+		}
+	case "/verify":
+		{
+			member, err := members.redeem(request.Token, Verify)
+			if err != nil {
+				response.Fail(w, err)
+				return
+			}
+			_, err = members.update(map[string]interface{}{"Id": member.Id, "Email": member.Email, "Verified": true})
+			if err != nil {
+				response.Fail(w, err)
+				return
+			}
+			response.OK(w, struct{ Message string }{Message: "Your email address is confirmed. You can now log in."})
+		}
+	default:
+		response.Failf(w, http.StatusNotFound, "%s is not served", r.URL.Path)
+	}
+}
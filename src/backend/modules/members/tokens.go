@@ -0,0 +1,101 @@
+package members
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"example.com/response"
+)
+
+// Purposes a token can be issued for.
+const (
+	Reset  = "reset"
+	Verify = "verify"
+)
+
+// How long tokens stay valid. Reset links are short lived because they
+// grant access to an existing account.
+const (
+	resetValid  = time.Hour
+	verifyValid = 7 * 24 * time.Hour
+)
+
+// Token is a single use link sent by mail. Only the sha256 of the token is
+// stored, so a leaked database does not hand out working links.
+type Token struct {
+	Hash     string `bson:"_id"`
+	MemberId string `bson:"MemberId"`
+	Purpose  string `bson:"Purpose"`
+	Expires  string `bson:"Expires"`
+	Used     bool   `bson:"Used"`
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issue creates a token for memberId, retiring any earlier unused token for
+// the same purpose so only the latest mail works.
+func (members *Members) issue(memberId, purpose string, valid time.Duration) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("error creating token")
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	_, err := members.tokens.UpdateWhere(map[string]interface{}{"MemberId": memberId, "Purpose": purpose, "Used": false}, map[string]interface{}{"Used": true})
+	if err != nil {
+		return "", fmt.Errorf("error creating token")
+	}
+	record := Token{Hash: hashToken(token), MemberId: memberId, Purpose: purpose, Expires: time.Now().Add(valid).Format(time.RFC3339)}
+	if err = members.tokens.Insert(&record); err != nil {
+		return "", fmt.Errorf("error creating token")
+	}
+	return token, nil
+}
+
+// redeem marks a token used and returns the member it was issued to. The
+// update only matches unused tokens, so two requests racing with the same
+// link cannot both succeed.
+func (members *Members) redeem(token, purpose string) (*Member, error) {
+	match := map[string]interface{}{"_id": hashToken(token), "Purpose": purpose, "Used": false}
+	found, err := members.tokens.Find(match)
+	if err != nil {
+		return nil, fmt.Errorf("error checking link")
+	}
+	claimed := 0
+	if len(found) != 0 {
+		claimed, err = members.tokens.UpdateWhere(match, map[string]interface{}{"Used": true})
+		if err != nil {
+			return nil, fmt.Errorf("error checking link")
+		}
+	}
+	if claimed == 0 {
+		return nil, response.Errorf(http.StatusBadRequest, "this link is invalid or has already been used")
+	}
+	record := found[0]
+	if expires, err := time.Parse(time.RFC3339, record.Expires); err != nil || time.Now().After(expires) {
+		return nil, response.Errorf(http.StatusBadRequest, "this link has expired, ask for a new one")
+	}
+	member := members.find(record.MemberId)
+	if member == nil {
+		return nil, response.Errorf(http.StatusNotFound, "member account does not exists")
+	}
+	return member, nil
+}
+
+// valid describes a duration for the mail templates.
+func valid(d time.Duration) string {
+	if d >= 24*time.Hour {
+		return fmt.Sprintf("%d days", int(d.Hours()/24))
+	}
+	if d > time.Hour {
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	}
+	return "an hour"
+}
@@ -0,0 +1,114 @@
+package members
+
+import (
+	"net/http"
+	"strings"
+
+	"example.com/roles"
+)
+
+// PublicMember is a member as anyone may see them: a name and a photo.
+type PublicMember struct {
+	Id       string
+	Name     string
+	Passport string
+}
+
+// MemberView is a member as other members see them, adding how to reach
+// them and where they belong.
+type MemberView struct {
+	Id       string
+	Name     string
+	Passport string
+	Email    string
+	Contacts string
+	District string
+	Groups   string
+}
+
+// AdminMember is the whole record less the password hash.
+type AdminMember struct {
+	Id              string
+	Name            string
+	Email           string
+	Contacts        string
+	DateofBirth     string
+	DateofBaptism   string
+	DateofCatechism string
+	District        string
+	Groups          string
+	Passport        string
+	Active          bool
+	Role            int
+	Gender          string
+	Verified        bool
+	Deactivated     string
+}
+
+// The fields of other members each view shows. The member list may be
+// sorted and filtered by those of the caller's view only.
+var (
+	publicList  = []string{"Name"}
+	contactList = []string{"Name", "Email", "District", "Groups"}
+	adminList   = []string{"Name", "Email", "District", "Groups", "Gender", "DateofBirth", "Role", "Active"}
+)
+
+// Public is the member as guests see them.
+func (member Member) Public() PublicMember {
+	return PublicMember{Id: member.Id, Name: member.Name, Passport: member.Passport}
+}
+
+// Contact is the member as other members see them.
+func (member Member) Contact() MemberView {
+	return MemberView{Id: member.Id, Name: member.Name, Passport: member.Passport, Email: member.Email, Contacts: member.Contacts, District: member.District, Groups: member.Groups}
+}
+
+// Admin is the member without secrets, for the office and the member.
+func (member Member) Admin() AdminMember {
+	return AdminMember{Id: member.Id, Name: member.Name, Email: member.Email, Contacts: member.Contacts, DateofBirth: member.DateofBirth,
+		DateofBaptism: member.DateofBaptism, DateofCatechism: member.DateofCatechism, District: member.District, Groups: member.Groups,
+		Passport: member.Passport, Active: member.Active, Role: member.Role, Gender: member.Gender, Verified: member.Verified, Deactivated: member.Deactivated}
+}
+
+// Presenter picks the view of members the caller on r gets. Admins see
+// whole records, as do elders and leaders for the members in their scope
+// and everyone looking at their own. Other members see contact details and
+// guests a name and photo. Modules answering with member records pass them
+// through it.
+func (members *Members) Presenter(r *http.Request) func(Member) interface{} {
+	caller := members.caller(r)
+	if caller == nil {
+		return func(member Member) interface{} { return member.Public() }
+	}
+	whole := func(Member) bool { return false }
+	switch members.RoleOf(caller.Email) {
+	case roles.Admin:
+		return func(member Member) interface{} { return member.Admin() }
+	case roles.DistrictElder, roles.GroupLeader:
+		allowed := members.Scope(r)
+		whole = func(member Member) bool {
+			v := members.view(&member)
+			return allowed(&v)
+		}
+	}
+	return func(member Member) interface{} {
+		if strings.EqualFold(member.Id, caller.Id) || whole(member) {
+			return member.Admin()
+		}
+		return member.Contact()
+	}
+}
+
+// listable returns the fields the caller on r sees on every member of their
+// list. Elders and leaders only list the members in their scope.
+func (members *Members) listable(r *http.Request) []string {
+	caller := members.caller(r)
+	if caller == nil {
+		return publicList
+	}
+	switch members.RoleOf(caller.Email) {
+	case roles.Admin, roles.DistrictElder, roles.GroupLeader:
+		return adminList
+	}
+	return contactList
+}
@@ -0,0 +1,336 @@
+package memberships
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/response"
+	"example.com/store"
+	"github.com/google/uuid"
+)
+
+// Membership links one member to one group, replacing the single
+// semicolon separated Member.Groups string.
+type Membership struct {
+	Id       string `bson:"Id"`
+	MemberId string `bson:"MemberId"`
+	GroupId  string `bson:"GroupId"`
+	Role     string `bson:"Role"`
+	Joined   string `bson:"Joined"`
+}
+
+// Registry tells whether a record with an Id exists, such as a member
+// or a group.
+type Registry interface {
+	Exists(id string) bool
+}
+
+type Memberships struct {
+	memberships *store.Cache[Membership]
+	store       store.Store[Membership]
+	members     Registry
+	groups      Registry
+	// mutex serializes add, delete and update; reads use cache snapshots
+	mutex sync.Mutex
+}
+
+// MembershipCollection is where the Mongo store keeps memberships.
+const MembershipCollection = "membership"
+
+// DefaultRole is given to members who join a group without a named office.
+const DefaultRole = "member"
+
+func NewMemberships(records store.Store[Membership]) *Memberships {
+	memberships, err := records.All()
+	if err != nil {
+		log.Fatal("error loading memberships data " + err.Error())
+	}
+	cache := store.NewCache(memberships, func(membership *Membership) string { return membership.Id })
+	cache.Follow("memberships", records)
+	return &Memberships{memberships: cache, store: records}
+}
+
+// Check wires in the members and groups a new membership must name.
+func (memberships *Memberships) Check(members, groups Registry) {
+	memberships.members = members
+	memberships.groups = groups
+}
+
+// Roster returns the memberships of a group.
+func (memberships *Memberships) Roster(groupId string) []Membership {
+	result := make([]Membership, 0)
+	for _, membership := range memberships.memberships.All() {
+		if strings.EqualFold(membership.GroupId, groupId) {
+			result = append(result, *membership)
+		}
+	}
+	return result
+}
+
+// Of returns the memberships held by a member.
+func (memberships *Memberships) Of(memberId string) []Membership {
+	result := make([]Membership, 0)
+	for _, membership := range memberships.memberships.All() {
+		if strings.EqualFold(membership.MemberId, memberId) {
+			result = append(result, *membership)
+		}
+	}
+	return result
+}
+
+// GroupsOf returns the Ids of the groups a member belongs to.
+func (memberships *Memberships) GroupsOf(memberId string) []string {
+	result := make([]string, 0)
+	for _, membership := range memberships.Of(memberId) {
+		result = append(result, membership.GroupId)
+	}
+	return result
+}
+
+// Validate checks that every group Sync would add the member to exists, so
+// an edit naming a misspelt or deleted group is refused before anything is
+// saved.
+func (memberships *Memberships) Validate(memberId string, groupIds []string) error {
+	if memberships.groups == nil {
+		return nil
+	}
+	held := memberships.GroupsOf(memberId)
+	unknown := make([]string, 0)
+	for _, id := range groupIds {
+		if len(id) != 0 && !contains(held, id) && !memberships.groups.Exists(id) {
+			unknown = append(unknown, id)
+		}
+	}
+	if len(unknown) != 0 {
+		return response.Invalid("member names an unknown group", map[string]string{"Groups": "no such group " + strings.Join(unknown, ", ")})
+	}
+	return nil
+}
+
+// Sync makes the member belong to exactly groupIds. New memberships start
+// today with the default role; existing ones keep their role and date.
+func (memberships *Memberships) Sync(memberId string, groupIds []string) error {
+	memberships.mutex.Lock()
+	defer memberships.mutex.Unlock()
+	if err := memberships.Validate(memberId, groupIds); err != nil {
+		return err
+	}
+	wanted := make(map[string]bool)
+	for _, id := range groupIds {
+		if len(id) != 0 {
+			wanted[strings.ToLower(id)] = true
+		}
+	}
+	for _, membership := range memberships.Of(memberId) {
+		if wanted[strings.ToLower(membership.GroupId)] {
+			delete(wanted, strings.ToLower(membership.GroupId))
+			continue
+		}
+		if _, err := memberships.remove(membership.Id); err != nil {
+			return err
+		}
+	}
+	for _, id := range groupIds {
+		if !wanted[strings.ToLower(id)] {
+			continue
+		}
+		delete(wanted, strings.ToLower(id))
+		newmembership := Membership{Id: uuid.NewString(), MemberId: memberId, GroupId: id, Role: DefaultRole, Joined: time.Now().Format("2006-01-02")}
+		if _, err := memberships.insert(&newmembership); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GroupReferences counts the members of a group.
+func (memberships *Memberships) GroupReferences(groupId string) int {
+	return len(memberships.Roster(groupId))
+}
+
+// GroupMoved moves the roster of one group onto another. Members already in
+// the target group keep that membership and drop the old one.
+func (memberships *Memberships) GroupMoved(from, to string) error {
+	memberships.mutex.Lock()
+	defer memberships.mutex.Unlock()
+	for _, membership := range memberships.Roster(from) {
+		if contains(memberships.GroupsOf(membership.MemberId), to) {
+			if _, err := memberships.remove(membership.Id); err != nil {
+				return err
+			}
+			continue
+		}
+		err := memberships.store.Update(membership.Id, map[string]interface{}{"GroupId": to})
+		if err != nil {
+			return fmt.Errorf("error moving membership %s", err)
+		}
+		moved := membership
+		moved.GroupId = to
+		memberships.memberships.Put(&moved)
+	}
+	return nil
+}
+
+func contains(ids []string, id string) bool {
+	for _, x := range ids {
+		if strings.EqualFold(x, id) {
+			return true
+		}
+	}
+	return false
+}
+
+func (memberships *Memberships) add(newmembership *Membership) (*Membership, error) {
+	invalid := map[string]string{}
+	if memberships.members != nil && !memberships.members.Exists(newmembership.MemberId) {
+		invalid["MemberId"] = "no such member"
+	}
+	if memberships.groups != nil && !memberships.groups.Exists(newmembership.GroupId) {
+		invalid["GroupId"] = "no such group"
+	}
+	if len(invalid) != 0 {
+		return nil, response.Invalid("membership names an unknown member or group", invalid)
+	}
+	memberships.mutex.Lock()
+	defer memberships.mutex.Unlock()
+	return memberships.insert(newmembership)
+}
+
+// insert stores a membership unless the member already belongs to the
+// group. The caller holds the mutex.
+func (memberships *Memberships) insert(newmembership *Membership) (*Membership, error) {
+	for _, membership := range memberships.memberships.All() {
+		if strings.EqualFold(membership.MemberId, newmembership.MemberId) && strings.EqualFold(membership.GroupId, newmembership.GroupId) {
+			return nil, response.Errorf(http.StatusConflict, "member already belongs to the group")
+		}
+	}
+	if len(newmembership.Role) == 0 {
+		newmembership.Role = DefaultRole
+	}
+	if len(newmembership.Joined) == 0 {
+		newmembership.Joined = time.Now().Format("2006-01-02")
+	}
+	err := memberships.store.Insert(newmembership)
+	if err != nil {
+		return nil, fmt.Errorf("error registering membership")
+	}
+	saved := *newmembership
+	memberships.memberships.Put(&saved)
+	return newmembership, nil
+}
+
+func (memberships *Memberships) delete(oldmembership *Membership) (*Membership, error) {
+	memberships.mutex.Lock()
+	defer memberships.mutex.Unlock()
+	return memberships.remove(oldmembership.Id)
+}
+
+// remove deletes the membership with id. The caller holds the mutex.
+func (memberships *Memberships) remove(id string) (*Membership, error) {
+	if membership := memberships.memberships.Get(id); membership != nil {
+		err := memberships.store.Delete(membership.Id)
+		if err != nil {
+			return nil, fmt.Errorf("error deleting membership")
+		}
+		memberships.memberships.Remove(membership.Id)
+		return membership, nil
+	}
+	return nil, response.Errorf(http.StatusNotFound, "membership does not exists")
+}
+
+// update changes the role or join date of a membership. The member and
+// group it links are fixed; remove and add a membership to move it.
+func (memberships *Memberships) update(update map[string]interface{}) (*Membership, error) {
+	memberships.mutex.Lock()
+	defer memberships.mutex.Unlock()
+	id, _ := update["Id"].(string)
+	for _, membership := range memberships.memberships.All() {
+		if strings.EqualFold(membership.Id, id) {
+			usr := *membership
+			// only the role and joining date change, the ids are the relation
+			set, err := store.Apply(&usr, update, "Id", "MemberId", "GroupId")
+			if err != nil {
+				return nil, err
+			}
+			err = memberships.store.Update(usr.Id, set)
+			if err != nil {
+				return nil, fmt.Errorf("error updating membership %s", err)
+			}
+			saved := usr
+			memberships.memberships.Put(&saved)
+			return &usr, nil
+		}
+	}
+	return nil, response.Errorf(http.StatusNotFound, "membership does not exists")
+}
+
+func (memberships *Memberships) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.URL.Path, "/membership") {
+		switch r.Method {
+		case http.MethodPost:
+			{
+				var newmembership Membership
+				if !response.Decode(w, r, &newmembership) {
+					return
+				}
+				newmembership.Id = uuid.NewString()
+				u, err := memberships.add(&newmembership)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		case http.MethodGet:
+			{
+				if group := r.URL.Query().Get("group"); len(group) != 0 {
+					response.OK(w, memberships.Roster(group))
+					return
+				}
+				if member := r.URL.Query().Get("member"); len(member) != 0 {
+					response.OK(w, memberships.Of(member))
+					return
+				}
+				result := make([]Membership, 0)
+				for _, m := range memberships.memberships.All() {
+					result = append(result, *m)
+				}
+				response.OK(w, result)
+				return
+			}
+		case http.MethodDelete:
+			{
+				var oldmembership Membership
+				if !response.Decode(w, r, &oldmembership) {
+					return
+				}
+				u, err := memberships.delete(&oldmembership)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		case http.MethodPut:
+			{
+				updatemembership := make(map[string]interface{}, 0)
+				if !response.Decode(w, r, &updatemembership) {
+					return
+				}
+				u, err := memberships.update(updatemembership)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		}
+	}
+}
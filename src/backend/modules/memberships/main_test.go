@@ -0,0 +1,134 @@
+package memberships
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"example.com/response"
+	"example.com/store"
+)
+
+// serve sends a JSON request to the handler and decodes the reply into v.
+func serve(t *testing.T, handler http.Handler, method, target, body string, v interface{}) int {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: decoding %q: %s", method, target, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+// ids is a Registry holding the given Ids.
+type ids map[string]bool
+
+func (ids ids) Exists(id string) bool { return ids[id] }
+
+func TestMembershipsNameKnownMembersAndGroups(t *testing.T) {
+	records := store.NewMemory[Membership]("Id")
+	memberships := NewMemberships(records)
+	memberships.Check(ids{"m1": true}, ids{"choir": true})
+
+	for _, body := range []string{`{"MemberId":"ghost","GroupId":"choir"}`, `{"MemberId":"m1","GroupId":"ghost"}`, `{"GroupId":"choir"}`} {
+		if code := serve(t, memberships, http.MethodPost, "/membership", body, nil); code != http.StatusBadRequest {
+			t.Errorf("POST /membership %s = %d; want 400", body, code)
+		}
+	}
+	if code := serve(t, memberships, http.MethodPost, "/membership", `{"MemberId":"m1","GroupId":"choir"}`, nil); code != http.StatusOK {
+		t.Fatalf("POST /membership for a known member and group = %d", code)
+	}
+	if all, _ := records.All(); len(all) != 1 {
+		t.Fatalf("store holds %d memberships; want 1", len(all))
+	}
+
+	// Sync refuses unknown groups before changing anything
+	var invalid *response.Error
+	if err := memberships.Sync("m1", []string{"ghost"}); !errors.As(err, &invalid) || invalid.Status != http.StatusBadRequest || len(invalid.Fields["Groups"]) == 0 {
+		t.Fatalf("Sync to an unknown group = %v; want a 400 naming Groups", err)
+	}
+	if groups := memberships.GroupsOf("m1"); len(groups) != 1 || groups[0] != "choir" {
+		t.Fatalf("a refused Sync changed the memberships to %v", groups)
+	}
+}
+
+func TestMembershipsAddAndMove(t *testing.T) {
+	records := store.NewMemory[Membership]("Id")
+	memberships := NewMemberships(records)
+
+	var joined Membership
+	if code := serve(t, memberships, http.MethodPost, "/membership", `{"MemberId":"m1","GroupId":"choir"}`, &joined); code != http.StatusOK || joined.Role != DefaultRole {
+		t.Fatalf("POST /membership = %d %+v", code, joined)
+	}
+	if code := serve(t, memberships, http.MethodPost, "/membership", `{"MemberId":"m1","GroupId":"choir"}`, nil); code != http.StatusConflict {
+		t.Fatalf("joining twice = %d; want 409", code)
+	}
+	if err := memberships.Sync("m2", []string{"choir", "youth"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := memberships.Sync("m1", []string{"youth"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := memberships.GroupMoved("youth", "choir"); err != nil {
+		t.Fatal(err)
+	}
+	if got := memberships.GroupsOf("m1"); len(got) != 1 || got[0] != "choir" {
+		t.Fatalf("m1 belongs to %v; want [choir]", got)
+	}
+	if got := memberships.GroupsOf("m2"); len(got) != 1 || got[0] != "choir" {
+		t.Fatalf("m2 belongs to %v; want [choir] once", got)
+	}
+	if all, _ := records.All(); len(all) != 2 {
+		t.Fatalf("store holds %d memberships; want 2", len(all))
+	}
+}
+
+// TestMembershipsConcurrent is meant for go test -race: members are read
+// while their memberships are synced and moved.
+func TestMembershipsConcurrent(t *testing.T) {
+	memberships := NewMemberships(store.NewMemory[Membership]("Id"))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		member := fmt.Sprintf("m%d", i)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 20; n++ {
+				if err := memberships.Sync(member, []string{"choir", fmt.Sprintf("g%d", n%3)}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 20; n++ {
+				memberships.GroupsOf(member)
+				memberships.Roster("choir")
+				serve(t, memberships, http.MethodGet, "/membership", "", nil)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := 0; n < 10; n++ {
+			if err := memberships.GroupMoved("g0", "g1"); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	wg.Wait()
+	if roster := memberships.Roster("choir"); len(roster) != 8 {
+		t.Fatalf("choir has %d members; want 8", len(roster))
+	}
+}
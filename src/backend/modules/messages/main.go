@@ -1,185 +1,311 @@
 package messages
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
-	"reflect"
+	"net/mail"
 	"strings"
+	"sync"
+	"time"
 
+	"example.com/members"
+	"example.com/response"
+	"example.com/roles"
+	"example.com/store"
 	"github.com/google/uuid"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// Message states, in the order a message normally moves through them.
+const (
+	New      = "new"
+	Assigned = "assigned"
+	Replied  = "replied"
+	Closed   = "closed"
+)
+
+var statuses = []string{New, Assigned, Replied, Closed}
+
+// Message is a note sent through the contact form. Assignee holds the Id of
+// the member looking after it and Replies the answers sent so far.
 type Message struct {
-	Id          string `bson:"Id"`
-	Email       string `bson:"Email"`
-	Description string `bson:"Description"`
+	Id          string  `bson:"Id"`
+	Name        string  `bson:"Name"`
+	Email       string  `bson:"Email"`
+	Subject     string  `bson:"Subject"`
+	Description string  `bson:"Description"`
+	Received    string  `bson:"Received"`
+	Status      string  `bson:"Status"`
+	Assignee    string  `bson:"Assignee"`
+	Replies     []Reply `bson:"Replies"`
+}
+
+// Reply is an answer sent back to the author of a message.
+type Reply struct {
+	Id   string `bson:"Id"`
+	By   string `bson:"By"`
+	Body string `bson:"Body"`
+	Sent string `bson:"Sent"`
+}
+
+// Mailer queues a templated mail, see notifications.Notifications.
+type Mailer interface {
+	Queue(to, template string, data interface{}) error
 }
 
 type Messages struct {
-	messages []*Message
-	db       *mongo.Database
+	messages *store.Cache[Message]
+	store    store.Store[Message]
+	members  *members.Members
+	mailer   Mailer
+	// mutex serializes triage and replies on the same message
+	mutex sync.Mutex
 }
 
-const messageCollection = "message"
+// MessageCollection is where the Mongo store keeps messages.
+const MessageCollection = "message"
 
-func NewMessages(db *mongo.Database) *Messages {
-	messages := make([]*Message, 0)
-	col := db.Collection(messageCollection)
-	result, err := col.Find(context.TODO(), bson.M{})
+func NewMessages(records store.Store[Message], m *members.Members, mailer Mailer) *Messages {
+	// messages from before the inbox have no status yet
+	err := records.Default("Status", New)
 	if err != nil {
-		log.Fatal(err.Error())
-	} else {
-		if err = result.All(context.TODO(), &messages); err != nil {
-			log.Fatal("error loading messages data " + err.Error())
-		}
+		log.Fatal("error migrating messages data " + err.Error())
+	}
+	messages, err := records.All()
+	if err != nil {
+		log.Fatal("error loading messages data " + err.Error())
 	}
-	return &Messages{messages: messages, db: db}
+	cache := store.NewCache(messages, func(message *Message) string { return message.Id })
+	cache.Follow("messages", records)
+	return &Messages{messages: cache, store: records, members: m, mailer: mailer}
 }
 
 func (messages *Messages) add(newmessage *Message) (*Message, error) {
-	bsonData, err := bson.Marshal(newmessage)
-	if err != nil {
-		return nil, fmt.Errorf("error processing message details")
+	if _, err := mail.ParseAddress(newmessage.Email); err != nil {
+		return nil, response.Invalid("a valid email is required", map[string]string{"Email": "not a valid address"})
+	}
+	if len(strings.TrimSpace(newmessage.Description)) == 0 {
+		return nil, response.Invalid("message is empty", map[string]string{"Description": "required"})
 	}
-	col := messages.db.Collection(messageCollection)
-	_, err = col.InsertOne(context.TODO(), bsonData)
+	err := messages.store.Insert(newmessage)
 	if err != nil {
-		return nil, fmt.Errorf("error registering user")
+		return nil, fmt.Errorf("error registering message")
 	}
-	go func() {
-		messages.messages = append(messages.messages, newmessage)
-	}()
+	saved := *newmessage
+	messages.messages.Put(&saved)
 	return newmessage, nil
 }
 
 func (messages *Messages) delete(oldmessage *Message) (*Message, error) {
-	for x, message := range messages.messages {
+	messages.mutex.Lock()
+	defer messages.mutex.Unlock()
+	for _, message := range messages.messages.All() {
 		if strings.EqualFold(message.Id, oldmessage.Id) {
-			bsonData, err := bson.Marshal(oldmessage)
-			if err != nil {
-				return nil, fmt.Errorf("error processing message details")
-			}
-			col := messages.db.Collection(messageCollection)
-			_, err = col.DeleteOne(context.TODO(), bsonData)
+			err := messages.store.Delete(message.Id)
 			if err != nil {
-				return nil, fmt.Errorf("error deleting user")
+				return nil, fmt.Errorf("error deleting message")
 			}
-			go func() {
-				messages.messages = append(messages.messages[:x], messages.messages[x+1:]...)
-			}()
-			return oldmessage, nil
+			messages.messages.Remove(message.Id)
+			return message, nil
 		}
 	}
-	return nil, fmt.Errorf("message account does not exists")
+	return nil, response.Errorf(http.StatusNotFound, "message does not exists")
 }
 
+// update changes the triage fields of a message, Status and Assignee. The
+// text a visitor sent is never edited. Assigning a new message marks it
+// assigned.
 func (messages *Messages) update(update map[string]interface{}) (*Message, error) {
-	var usr Message
-	var fields []string
-	rv := reflect.ValueOf(usr)
-	for key, value := range update {
-		field := rv.FieldByName(key)
-		if field.IsValid() && field.CanSet() {
-			val := reflect.ValueOf(value)
-			if field.Type() == val.Type() {
-				field.Set(val)
-			} else {
-				return nil, fmt.Errorf("update field with type mismatch %v ", key)
-			}
-			fields = append(fields, key)
+	messages.mutex.Lock()
+	defer messages.mutex.Unlock()
+	message := messages.find(fmt.Sprint(update["Id"]))
+	if message == nil {
+		return nil, response.Errorf(http.StatusNotFound, "message does not exists")
+	}
+	usr := *message
+	if value, ok := update["Assignee"]; ok {
+		assignee, ok := value.(string)
+		if !ok {
+			return nil, response.Invalid("type mismatch for field Assignee", map[string]string{"Assignee": "wrong type"})
+		}
+		if _, found := messages.members.Get(assignee); len(assignee) != 0 && !found {
+			return nil, response.Invalid("assignee does not exists", map[string]string{"Assignee": "no such member"})
+		}
+		usr.Assignee = assignee
+		if usr.Status == New && len(assignee) != 0 {
+			usr.Status = Assigned
 		}
 	}
-	req := 0
-	for i, v := range fields {
-		if strings.EqualFold(v, "Id") {
-			req += 1
+	if value, ok := update["Status"]; ok {
+		status, ok := value.(string)
+		if !ok {
+			return nil, response.Invalid("type mismatch for field Status", map[string]string{"Status": "wrong type"})
+		}
+		found := false
+		for _, s := range statuses {
+			found = found || s == status
 		}
-		if i == len(fields)-1 && req != 1 {
-			return nil, fmt.Errorf("update missing important fields")
+		if !found {
+			return nil, response.Invalid(fmt.Sprintf("status must be one of %s", strings.Join(statuses, ", ")), map[string]string{"Status": "unknown status"})
 		}
+		usr.Status = status
 	}
-	for _, message := range messages.messages {
-		if strings.EqualFold(message.Id, usr.Id) {
+	err := messages.store.Update(usr.Id, map[string]interface{}{"Status": usr.Status, "Assignee": usr.Assignee})
+	if err != nil {
+		return nil, fmt.Errorf("error updating message %s", err)
+	}
+	saved := usr
+	messages.messages.Put(&saved)
+	return &usr, nil
+}
 
-			col := messages.db.Collection(messageCollection)
-			_, err := col.UpdateByID(context.TODO(), usr.Id, usr)
-			if err != nil {
-				return nil, fmt.Errorf("error updating message")
-			}
-			return &usr, nil
-		}
+// reply queues an answer to the author of a message and adds it to the
+// thread. Nothing is recorded when the mail cannot be queued.
+func (messages *Messages) reply(message *Message, by, body string) (*Message, error) {
+	if len(strings.TrimSpace(body)) == 0 {
+		return nil, response.Invalid("reply is empty", map[string]string{"Body": "required"})
+	}
+	messages.mutex.Lock()
+	defer messages.mutex.Unlock()
+	// the thread may have grown since the caller looked the message up
+	if message = messages.find(message.Id); message == nil {
+		return nil, response.Errorf(http.StatusNotFound, "message does not exists")
 	}
-	return nil, fmt.Errorf("message account does not exists")
+	data := struct{ Name, Subject, Body, Received, Original string }{message.Name, message.Subject, body, message.Received, message.Description}
+	if err := messages.mailer.Queue(message.Email, "reply", data); err != nil {
+		return nil, err
+	}
+	reply := Reply{Id: uuid.NewString(), By: by, Body: body, Sent: time.Now().Format(time.RFC3339)}
+	replies := append(append([]Reply{}, message.Replies...), reply)
+	err := messages.store.Update(message.Id, map[string]interface{}{"Replies": replies, "Status": Replied})
+	if err != nil {
+		return nil, fmt.Errorf("reply was queued but could not be saved %s", err)
+	}
+	saved := *message
+	saved.Replies, saved.Status = replies, Replied
+	messages.messages.Put(&saved)
+	return &saved, nil
+}
+
+func (messages *Messages) find(id string) *Message {
+	return messages.messages.Get(id)
+}
+
+// visible reports whether the caller may read and answer a message. Admins
+// see the whole inbox; anyone else only what is assigned to them.
+func (messages *Messages) visible(r *http.Request, message *Message) bool {
+	caller, ok := messages.members.Caller(r)
+	if !ok {
+		return false
+	}
+	return messages.members.RoleOf(caller.Email) == roles.Admin || strings.EqualFold(message.Assignee, caller.Id)
 }
 
 func (messages *Messages) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if strings.EqualFold(r.URL.Path, "/message") {
+	if strings.EqualFold(r.URL.Path, "/message/reply") {
+		var reply struct{ MessageId, Body string }
+		if !response.Decode(w, r, &reply) {
+			return
+		}
+		message := messages.find(reply.MessageId)
+		if message == nil || !messages.visible(r, message) {
+			response.Failf(w, http.StatusNotFound, "message does not exists")
+			return
+		}
+		caller, _ := messages.members.Caller(r)
+		u, err := messages.reply(message, caller.Id, reply.Body)
+		if err != nil {
+			response.Fail(w, err)
+			return
+		}
+		response.OK(w, u)
+		return
+	} else if strings.EqualFold(r.URL.Path, "/message") {
 		switch r.Method {
 		case http.MethodPost:
 			{
 				var newmessage Message
-				err := json.NewDecoder(r.Body).Decode(&newmessage)
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
+				if !response.Decode(w, r, &newmessage) {
 					return
 				}
-				newmessage.Id=uuid.NewString()
+				newmessage.Id = uuid.NewString()
+				newmessage.Received = time.Now().Format(time.RFC3339)
+				newmessage.Status = New
+				newmessage.Assignee = ""
+				newmessage.Replies = make([]Reply, 0)
 				u, err := messages.add(&newmessage)
 				if err != nil {
-					json.NewEncoder(w).Encode(fmt.Sprintf("{error: %s}", err.Error()))
+					response.Fail(w, err)
 					return
 				}
-				json.NewEncoder(w).Encode(u)
+				response.OK(w, u)
 				return
 			}
 		case http.MethodGet:
 			{
-				if len(messages.messages) == 0 {
-					json.NewEncoder(w).Encode("{error: no users found}")
+				query, err := store.ParseQuery(r.URL.Query(), "Received", "Name", "Subject", "Status")
+				if err != nil {
+					response.Failf(w, http.StatusBadRequest, "%s", err)
 					return
 				}
-				json.NewEncoder(w).Encode(fmt.Sprintf("{messages: %v}", messages.messages))
+				// newest first unless asked otherwise
+				if len(query.Sort) == 0 {
+					query.Sort, query.Desc = "Received", true
+				}
+				status, assignee := r.URL.Query().Get("status"), r.URL.Query().Get("assignee")
+				result := make([]Message, 0)
+				for _, message := range messages.messages.All() {
+					if !messages.visible(r, message) || (len(status) != 0 && message.Status != status) {
+						continue
+					}
+					if len(assignee) != 0 && !strings.EqualFold(message.Assignee, assignee) {
+						continue
+					}
+					if query.Matches(message.Name, message.Email, message.Subject, message.Description) {
+						result = append(result, *message)
+					}
+				}
+				response.OK(w, store.Paginate(result, query))
 				return
 			}
 		case http.MethodDelete:
 			{
-				var newmessage Message
-				err := json.NewDecoder(r.Body).Decode(&newmessage)
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
-
+				var oldmessage Message
+				if !response.Decode(w, r, &oldmessage) {
+					return
 				}
-				u, err := messages.delete(&newmessage)
+				u, err := messages.delete(&oldmessage)
 				if err != nil {
-					json.NewEncoder(w).Encode(fmt.Sprintf("{error: %s}", err.Error()))
+					response.Fail(w, err)
 					return
 				}
-				json.NewEncoder(w).Encode(u)
+				response.OK(w, u)
 				return
 			}
 		case http.MethodPut:
 			{
 				updatemessage := make(map[string]interface{}, 0)
-				err := json.NewDecoder(r.Body).Decode(&updatemessage)
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
+				if !response.Decode(w, r, &updatemessage) {
+					return
+				}
+				message := messages.find(fmt.Sprint(updatemessage["Id"]))
+				if message == nil || !messages.visible(r, message) {
+					response.Failf(w, http.StatusNotFound, "message does not exists")
+					return
+				}
+				caller, _ := messages.members.Caller(r)
+				if _, ok := updatemessage["Assignee"]; ok && messages.members.RoleOf(caller.Email) != roles.Admin {
+					response.Failf(w, http.StatusForbidden, "only an admin may assign messages")
 					return
 				}
 				u, err := messages.update(updatemessage)
 				if err != nil {
-					json.NewEncoder(w).Encode(fmt.Sprintf("{error: %s}", err.Error()))
+					response.Fail(w, err)
 					return
 				}
-				json.NewEncoder(w).Encode(u)
+				response.OK(w, u)
 				return
-
 			}
-
 		}
 	}
 }
@@ -0,0 +1,45 @@
+package messages
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/members"
+	"example.com/members/memberstest"
+	"example.com/notifications"
+	"example.com/roles"
+	"example.com/store"
+)
+
+func TestReplyIsMailedToTheAuthor(t *testing.T) {
+	m := memberstest.New(t,
+		members.Member{Id: "admin", Email: "admin@example.com", Role: roles.Admin},
+		members.Member{Id: "elder", Email: "elder@example.com", Role: roles.DistrictElder},
+	)
+	sender := &notifications.MemorySender{}
+	messages := NewMessages(store.NewMemory[Message]("Id"), m, notifications.NewNotifications(store.NewMemory[notifications.Mail]("Id"), sender))
+	admin := memberstest.Login(t, m, "admin@example.com")
+	elder := memberstest.Login(t, m, "elder@example.com")
+
+	var message Message
+	if code := memberstest.Request(t, messages, nil, http.MethodPost, "/message", `{"Name":"Chebet","Email":"chebet@example.com","Subject":"Baptism","Description":"When is the next class?"}`, &message); code != http.StatusOK || message.Status != New {
+		t.Fatalf("POST /message = %d %+v", code, message)
+	}
+	if code := memberstest.Request(t, messages, elder, http.MethodPost, "/message/reply", `{"MessageId":"`+message.Id+`","Body":"Next month"}`, nil); code != http.StatusNotFound {
+		t.Fatalf("reply to a message not assigned to the elder = %d; want 404", code)
+	}
+	var replied Message
+	if code := memberstest.Request(t, messages, admin, http.MethodPost, "/message/reply", `{"MessageId":"`+message.Id+`","Body":"The next class starts on the first Sunday."}`, &replied); code != http.StatusOK || replied.Status != Replied || len(replied.Replies) != 1 {
+		t.Fatalf("POST /message/reply = %d %+v", code, replied)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); len(sender.Sent()) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	sent := sender.Sent()
+	if len(sent) != 1 || sent[0].To != "chebet@example.com" || !strings.Contains(sent[0].Body, "first Sunday") {
+		t.Fatalf("mailed %+v; want the reply sent to the author", sent)
+	}
+}
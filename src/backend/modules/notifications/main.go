@@ -0,0 +1,276 @@
+package notifications
+
+import (
+	"embed"
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"example.com/response"
+	"example.com/store"
+	"github.com/google/uuid"
+)
+
+// Outbox states. Mail waits as pending until it is sent or has failed
+// maxAttempts times. A worker marks the mail sending while it hands it over.
+const (
+	Pending = "pending"
+	Sending = "sending"
+	Sent    = "sent"
+	Failed  = "failed"
+)
+
+const (
+	maxAttempts = 5
+	retryAfter  = time.Minute
+	pollEvery   = 30 * time.Second
+	// claimFor is how long a worker holds a mail it is sending. Mail left
+	// sending longer, by a worker that died, is taken up again.
+	claimFor = 5 * time.Minute
+)
+
+// Mail is an outbox entry. It is rendered when queued, so a later template
+// change does not alter mail already waiting.
+type Mail struct {
+	Id          string `bson:"Id"`
+	To          string `bson:"To"`
+	Template    string `bson:"Template"`
+	Subject     string `bson:"Subject"`
+	Body        string `bson:"Body"`
+	Status      string `bson:"Status"`
+	Attempts    int    `bson:"Attempts"`
+	NextAttempt string `bson:"NextAttempt"`
+	LastError   string `bson:"LastError"`
+	Created     string `bson:"Created"`
+	Delivered   string `bson:"Delivered"`
+}
+
+// Envelope is an outbox entry as GET /outbox lists it, without its body.
+type Envelope struct {
+	Id          string
+	To          string
+	Template    string
+	Subject     string
+	Status      string
+	Attempts    int
+	NextAttempt string
+	LastError   string
+	Created     string
+	Delivered   string
+}
+
+func (mail Mail) envelope() Envelope {
+	return Envelope{Id: mail.Id, To: mail.To, Template: mail.Template, Subject: mail.Subject, Status: mail.Status, Attempts: mail.Attempts,
+		NextAttempt: mail.NextAttempt, LastError: mail.LastError, Created: mail.Created, Delivered: mail.Delivered}
+}
+
+// secret names the templates whose mail carries a password reset or
+// verification link. Their body is dropped once the mail is sent or has
+// failed for good, so the outbox never holds a working link longer than
+// delivery takes.
+var secret = map[string]bool{"reset": true, "verify": true, "welcome": true}
+
+//go:embed templates/*.html
+var files embed.FS
+
+type Notifications struct {
+	outbox    store.Store[Mail]
+	sender    Sender
+	templates map[string]*template.Template
+	kick      chan struct{}
+}
+
+// OutboxCollection is where the Mongo store keeps the outbox.
+const OutboxCollection = "outbox"
+
+// outboxListed caps how many mails GET /outbox returns.
+const outboxListed = 200
+
+// NewNotifications parses the mail templates and starts the outbox worker.
+// Every template file defines a "subject" and a "body".
+func NewNotifications(outbox store.Store[Mail], sender Sender) *Notifications {
+	names, err := files.ReadDir("templates")
+	if err != nil {
+		log.Fatal("error loading mail templates " + err.Error())
+	}
+	templates := make(map[string]*template.Template)
+	for _, name := range names {
+		t, err := template.ParseFS(files, "templates/"+name.Name())
+		if err != nil {
+			log.Fatal("error parsing mail template " + err.Error())
+		}
+		templates[strings.TrimSuffix(name.Name(), path.Ext(name.Name()))] = t
+	}
+	// drop links kept by mail delivered before bodies were redacted
+	for name := range secret {
+		for _, status := range []string{Sent, Failed} {
+			if _, err := outbox.UpdateWhere(map[string]interface{}{"Template": name, "Status": status}, map[string]interface{}{"Body": ""}); err != nil {
+				log.Fatal("error redacting outbox " + err.Error())
+			}
+		}
+	}
+	notifications := &Notifications{outbox: outbox, sender: sender, templates: templates, kick: make(chan struct{}, 1)}
+	go notifications.run()
+	return notifications
+}
+
+// Render fills in a template, returning the subject and HTML body.
+func (notifications *Notifications) Render(name string, data interface{}) (string, string, error) {
+	t, ok := notifications.templates[name]
+	if !ok {
+		return "", "", fmt.Errorf("mail template %s does not exists", name)
+	}
+	var subject, body strings.Builder
+	if err := t.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return "", "", fmt.Errorf("error rendering %s subject %s", name, err)
+	}
+	if err := t.ExecuteTemplate(&body, "body", data); err != nil {
+		return "", "", fmt.Errorf("error rendering %s body %s", name, err)
+	}
+	// the subject is a header, not HTML
+	return strings.TrimSpace(html.UnescapeString(subject.String())), body.String(), nil
+}
+
+// Queue renders a template for one recipient and puts it in the outbox.
+func (notifications *Notifications) Queue(to, name string, data interface{}) error {
+	subject, body, err := notifications.Render(name, data)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Format(time.RFC3339)
+	mail := Mail{Id: uuid.NewString(), To: to, Template: name, Subject: subject, Body: body, Status: Pending, NextAttempt: now, Created: now}
+	err = notifications.outbox.Insert(&mail)
+	if err != nil {
+		return fmt.Errorf("error queueing mail")
+	}
+	select {
+	case notifications.kick <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// run delivers due mail whenever something is queued and on a timer, so
+// retries go out even when nothing new arrives.
+func (notifications *Notifications) run() {
+	ticker := time.NewTicker(pollEvery)
+	defer ticker.Stop()
+	for {
+		notifications.Deliver()
+		select {
+		case <-ticker.C:
+		case <-notifications.kick:
+		}
+	}
+}
+
+// Deliver tries every pending mail that is due. A failed attempt is retried
+// with a doubling delay until maxAttempts is reached. Each mail is claimed
+// by moving it to sending only if no one else has since, so workers running
+// side by side, in this process or another replica, never send it twice.
+func (notifications *Notifications) Deliver() {
+	now := time.Now()
+	due := make([]*Mail, 0)
+	for _, status := range []string{Pending, Sending} {
+		mails, err := notifications.outbox.Find(map[string]interface{}{"Status": status})
+		if err != nil {
+			log.Println("outbox:", err)
+			return
+		}
+		for _, mail := range mails {
+			if mail.NextAttempt <= now.Format(time.RFC3339) {
+				due = append(due, mail)
+			}
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].Created < due[j].Created })
+	for _, mail := range due {
+		claim := map[string]interface{}{"Status": Sending, "NextAttempt": now.Add(claimFor).Format(time.RFC3339)}
+		matched, err := notifications.outbox.UpdateWhere(map[string]interface{}{"Id": mail.Id, "Status": mail.Status, "NextAttempt": mail.NextAttempt}, claim)
+		if err != nil {
+			log.Println("outbox:", err)
+			continue
+		}
+		if matched == 0 {
+			continue
+		}
+		set := map[string]interface{}{"Attempts": mail.Attempts + 1}
+		if err := notifications.sender.Send(mail.To, mail.Subject, mail.Body); err != nil {
+			set["LastError"] = err.Error()
+			if mail.Attempts+1 >= maxAttempts {
+				set["Status"] = Failed
+				if secret[mail.Template] {
+					set["Body"] = ""
+				}
+			} else {
+				set["Status"] = Pending
+				set["NextAttempt"] = now.Add(retryAfter << mail.Attempts).Format(time.RFC3339)
+			}
+		} else {
+			set["Status"] = Sent
+			set["Delivered"] = time.Now().Format(time.RFC3339)
+			if secret[mail.Template] {
+				set["Body"] = ""
+			}
+		}
+		if err := notifications.outbox.Update(mail.Id, set); err != nil {
+			log.Println("outbox:", err)
+		}
+	}
+}
+
+func (notifications *Notifications) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.URL.Path, "/outbox") {
+		switch r.Method {
+		case http.MethodGet:
+			{
+				match := map[string]interface{}{}
+				if status := r.URL.Query().Get("status"); len(status) != 0 {
+					match["Status"] = status
+				}
+				mails, err := notifications.outbox.Find(match)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				sort.SliceStable(mails, func(i, j int) bool { return mails[i].Created > mails[j].Created })
+				result := make([]Envelope, 0, min(len(mails), outboxListed))
+				for _, mail := range mails[:min(len(mails), outboxListed)] {
+					result = append(result, mail.envelope())
+				}
+				response.OK(w, result)
+				return
+			}
+		case http.MethodPut:
+			{
+				// put a failed mail back in the queue
+				var retry struct{ Id string }
+				if !response.Decode(w, r, &retry) {
+					return
+				}
+				if found, err := notifications.outbox.Find(map[string]interface{}{"Id": retry.Id}); err == nil && len(found) == 1 && secret[found[0].Template] {
+					response.Failf(w, http.StatusConflict, "the link in this mail is no longer kept, the member can ask for a new one")
+					return
+				}
+				set := map[string]interface{}{"Status": Pending, "Attempts": 0, "NextAttempt": time.Now().Format(time.RFC3339)}
+				matched, err := notifications.outbox.UpdateWhere(map[string]interface{}{"Id": retry.Id, "Status": Failed}, set)
+				if err != nil || matched == 0 {
+					response.Failf(w, http.StatusNotFound, "no failed mail with that id")
+					return
+				}
+				select {
+				case notifications.kick <- struct{}{}:
+				default:
+				}
+				response.OK(w, retry)
+				return
+			}
+		}
+	}
+}
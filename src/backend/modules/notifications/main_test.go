@@ -0,0 +1,122 @@
+package notifications
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/store"
+)
+
+// delivered waits until the outbox worker has finished with every mail.
+func delivered(t *testing.T, outbox store.Store[Mail]) []*Mail {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		pending, _ := outbox.Find(map[string]interface{}{"Status": Pending})
+		sending, _ := outbox.Find(map[string]interface{}{"Status": Sending})
+		if len(pending)+len(sending) == 0 {
+			all, _ := outbox.All()
+			return all
+		}
+	}
+	t.Fatal("mail is still pending")
+	return nil
+}
+
+func TestResetLinksLeaveTheOutbox(t *testing.T) {
+	outbox := store.NewMemory[Mail]("Id")
+	sender := &MemorySender{}
+	notifications := NewNotifications(outbox, sender)
+	data := struct{ Name, Link, Valid string }{"Njeri", "https://example.com/reset?token=secret-token", "1 hour"}
+	if err := notifications.Queue("njeri@example.com", "reset", data); err != nil {
+		t.Fatal(err)
+	}
+	mails := delivered(t, outbox)
+	if sent := sender.Sent(); len(sent) != 1 || !strings.Contains(sent[0].Body, "secret-token") {
+		t.Fatalf("sent %+v; want the reset link mailed once", sent)
+	}
+	if len(mails) != 1 || mails[0].Status != Sent || len(mails[0].Body) != 0 {
+		t.Fatalf("outbox keeps %+v; want the sent mail without its body", mails[0])
+	}
+
+	rec := httptest.NewRecorder()
+	notifications.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/outbox", nil))
+	var listed []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil || len(listed) != 1 {
+		t.Fatalf("GET /outbox = %q %v", rec.Body.String(), err)
+	}
+	if _, ok := listed[0]["Body"]; ok {
+		t.Fatalf("GET /outbox lists bodies: %v", listed[0])
+	}
+}
+
+func TestWorkersSendEachMailOnce(t *testing.T) {
+	outbox := store.NewMemory[Mail]("Id")
+	sender := &MemorySender{}
+	notifications := NewNotifications(outbox, sender)
+	for i := 0; i < 20; i++ {
+		if err := notifications.Queue(fmt.Sprintf("member%d@example.com", i), "welcome", struct{ Name, Login, Link, Valid string }{Name: "Member"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			notifications.Deliver()
+		}()
+	}
+	wg.Wait()
+	delivered(t, outbox)
+	seen := make(map[string]int)
+	for _, mail := range sender.Sent() {
+		seen[mail.To]++
+	}
+	if len(seen) != 20 || len(sender.Sent()) != 20 {
+		t.Fatalf("sent %d mails to %d addresses; want each of 20 once", len(sender.Sent()), len(seen))
+	}
+}
+
+// flaky fails its first send.
+type flaky struct {
+	MemorySender
+	failed bool
+}
+
+func (sender *flaky) Send(to, subject, body string) error {
+	sender.mu.Lock()
+	failed := sender.failed
+	sender.failed = true
+	sender.mu.Unlock()
+	if !failed {
+		return errors.New("relay unavailable")
+	}
+	return sender.MemorySender.Send(to, subject, body)
+}
+
+func TestFailedMailWaitsToRetry(t *testing.T) {
+	outbox := store.NewMemory[Mail]("Id")
+	notifications := NewNotifications(outbox, &flaky{})
+	if err := notifications.Queue("wairimu@example.com", "welcome", struct{ Name, Login, Link, Valid string }{Name: "Wairimu"}); err != nil {
+		t.Fatal(err)
+	}
+	var mails []*Mail
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if mails, _ = outbox.Find(map[string]interface{}{"Attempts": 1}); len(mails) != 0 {
+			break
+		}
+	}
+	if len(mails) != 1 || mails[0].Status != Pending || mails[0].Attempts != 1 || mails[0].LastError != "relay unavailable" {
+		t.Fatalf("outbox holds %+v; want the mail pending a retry", mails)
+	}
+	if mails[0].NextAttempt <= time.Now().Format(time.RFC3339) {
+		t.Fatalf("retry is due at once: %s", mails[0].NextAttempt)
+	}
+}
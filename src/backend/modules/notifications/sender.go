@@ -0,0 +1,108 @@
+package notifications
+
+import (
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Sender hands a rendered mail to whatever delivers it.
+type Sender interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPSender sends mail through an SMTP relay.
+type SMTPSender struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (sender *SMTPSender) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if len(sender.Username) != 0 {
+		host := sender.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", sender.Username, sender.Password, host)
+	}
+	// the envelope wants the bare address, the header keeps the display name
+	envelope := sender.From
+	if address, err := mail.ParseAddress(sender.From); err == nil {
+		envelope = address.Address
+	}
+	err := smtp.SendMail(sender.Addr, auth, envelope, []string{to}, compose(sender.From, to, subject, body))
+	if err != nil {
+		return fmt.Errorf("error sending mail %s", err)
+	}
+	return nil
+}
+
+// FileSender writes each mail as an .eml file instead of sending it, for
+// development machines without a mail relay.
+type FileSender struct {
+	Dir  string
+	From string
+}
+
+func (sender *FileSender) Send(to, subject, body string) error {
+	if err := os.MkdirAll(sender.Dir, 0o755); err != nil {
+		return fmt.Errorf("error creating mail directory %s", err)
+	}
+	name := filepath.Join(sender.Dir, time.Now().Format("20060102T150405")+"-"+uuid.NewString()+".eml")
+	if err := os.WriteFile(name, compose(sender.From, to, subject, body), 0o644); err != nil {
+		return fmt.Errorf("error writing mail %s", err)
+	}
+	return nil
+}
+
+// Delivery is a mail kept by a MemorySender.
+type Delivery struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// MemorySender keeps mail in memory so tests can look at what went out.
+type MemorySender struct {
+	mu   sync.Mutex
+	sent []Delivery
+}
+
+func (sender *MemorySender) Send(to, subject, body string) error {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+	sender.sent = append(sender.sent, Delivery{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// Sent returns a copy of the mail sent so far.
+func (sender *MemorySender) Sent() []Delivery {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+	return append([]Delivery(nil), sender.sent...)
+}
+
+// compose builds an HTML message. Header values are stripped of line breaks
+// so a subject cannot smuggle in extra headers.
+func compose(from, to, subject, body string) []byte {
+	clean := strings.NewReplacer("\r", " ", "\n", " ")
+	var message strings.Builder
+	fmt.Fprintf(&message, "From: %s\r\n", clean.Replace(from))
+	fmt.Fprintf(&message, "To: %s\r\n", clean.Replace(to))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", clean.Replace(subject)))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\nContent-Type: text/html; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(message.String())
+}
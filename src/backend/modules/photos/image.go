@@ -0,0 +1,161 @@
+package photos
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"example.com/response"
+)
+
+const (
+	// maxPhoto is the largest upload accepted, in bytes.
+	maxPhoto = 5 << 20
+	// maxPixels bounds the decoded size, so a small file claiming huge
+	// dimensions cannot exhaust memory.
+	maxPixels = 40_000_000
+	// thumbSize is the longest side of a thumbnail, in pixels.
+	thumbSize = 240
+)
+
+// processed is an upload re-encoded without metadata, with its thumbnail.
+type processed struct {
+	original  []byte
+	thumbnail []byte
+	ext       string
+}
+
+// process checks that data is a JPEG or PNG photo and re-encodes it. The
+// encoders write pixels only, which strips EXIF and any other metadata such
+// as the location a phone photo was taken at.
+func process(data []byte) (*processed, error) {
+	kind := http.DetectContentType(data)
+	if kind != "image/jpeg" && kind != "image/png" {
+		return nil, response.Errorf(http.StatusUnsupportedMediaType, "photos must be JPEG or PNG images")
+	}
+	// a file that looks like an image but does not decode is the uploader's
+	// mistake, not the server's
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, response.Errorf(http.StatusBadRequest, "the photo could not be read: %s", err)
+	}
+	if int64(config.Width)*int64(config.Height) > maxPixels {
+		return nil, response.Errorf(http.StatusBadRequest, "image is too large, at most %d megapixels", maxPixels/1_000_000)
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, response.Errorf(http.StatusBadRequest, "the photo could not be read: %s", err)
+	}
+	img := rgba(decoded)
+	result := &processed{}
+	var original, thumbnail bytes.Buffer
+	if kind == "image/jpeg" {
+		img = orient(img, orientation(data))
+		result.ext = ".jpg"
+		err = jpeg.Encode(&original, img, &jpeg.Options{Quality: 90})
+		if err == nil {
+			err = jpeg.Encode(&thumbnail, shrink(img, thumbSize), &jpeg.Options{Quality: 85})
+		}
+	} else {
+		result.ext = ".png"
+		err = png.Encode(&original, img)
+		if err == nil {
+			err = png.Encode(&thumbnail, shrink(img, thumbSize))
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error encoding image %s", err)
+	}
+	result.original, result.thumbnail = original.Bytes(), thumbnail.Bytes()
+	return result, nil
+}
+
+// rgba copies img into an RGBA image whose pixels can be indexed directly.
+func rgba(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	result := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(result, result.Bounds(), img, bounds.Min, draw.Src)
+	return result
+}
+
+// orient turns img as EXIF orientation o asks, so it displays upright.
+func orient(img *image.RGBA, o int) *image.RGBA {
+	if o <= 1 || o > 8 {
+		return img
+	}
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	result := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			copy(result.Pix[result.PixOffset(dx, dy):][:4], img.Pix[img.PixOffset(x, y):][:4])
+		}
+	}
+	return result
+}
+
+// shrink scales img down so its longest side is at most size, averaging the
+// source pixels each thumbnail pixel covers. Smaller images are returned as
+// they are.
+func shrink(img *image.RGBA, size int) *image.RGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	tw, th := size, h*size/w
+	if h > w {
+		tw, th = w*size/h, size
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+	result := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for ty := 0; ty < th; ty++ {
+		y0, y1 := ty*h/th, (ty+1)*h/th
+		for tx := 0; tx < tw; tx++ {
+			x0, x1 := tx*w/tw, (tx+1)*w/tw
+			var sum [4]int
+			for y := y0; y < y1; y++ {
+				row := img.Pix[img.PixOffset(x0, y):]
+				for x := 0; x < x1-x0; x++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(row[x*4+c])
+					}
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			pixel := result.Pix[result.PixOffset(tx, ty):]
+			for c := 0; c < 4; c++ {
+				pixel[c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,164 @@
+package photos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"testing"
+
+	"example.com/response"
+)
+
+func TestProcessRejectsBrokenImages(t *testing.T) {
+	var whole bytes.Buffer
+	png.Encode(&whole, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+	if _, err := process(whole.Bytes()); err != nil {
+		t.Fatalf("process of a good PNG: %s", err)
+	}
+	broken := map[string][]byte{
+		"garbage after the PNG signature": append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0xff}, 64)...),
+		"a truncated PNG":                 whole.Bytes()[:whole.Len()/2],
+		"a bare JPEG marker":              append([]byte{0xff, 0xd8, 0xff}, bytes.Repeat([]byte{0}, 64)...),
+	}
+	for name, data := range broken {
+		var answer *response.Error
+		if _, err := process(data); !errors.As(err, &answer) || answer.Status != http.StatusBadRequest {
+			t.Errorf("process of %s = %v; want a 400", name, err)
+		}
+	}
+}
+
+// exifJPEG encodes img as a JPEG carrying an EXIF segment with Orientation
+// o, as a phone held upright writes it.
+func exifJPEG(t *testing.T, img image.Image, o uint16, order binary.ByteOrder) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	tiff := make([]byte, 26)
+	if order == binary.BigEndian {
+		copy(tiff, "MM")
+	} else {
+		copy(tiff, "II")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], o)
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+	data := append([]byte{}, encoded.Bytes()[:2]...)
+	data = append(append(data, app1...), segment...)
+	return append(data, encoded.Bytes()[2:]...)
+}
+
+// halves is a w by h image, red on the left half and blue on the right.
+func halves(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+	return img
+}
+
+func TestProcessRotatesAndStripsEXIF(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		data := exifJPEG(t, halves(600, 300), 6, order)
+		if o := orientation(data); o != 6 {
+			t.Fatalf("orientation of the %s fixture = %d; want 6", order, o)
+		}
+		result, err := process(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(result.original, []byte("Exif\x00\x00")) || bytes.Contains(result.thumbnail, []byte("Exif\x00\x00")) {
+			t.Fatal("the re-encoded photo still carries EXIF")
+		}
+		if o := orientation(result.original); o != 1 {
+			t.Fatalf("the re-encoded photo has orientation %d", o)
+		}
+		original, err := jpeg.Decode(bytes.NewReader(result.original))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := original.Bounds().Size(); size != image.Pt(300, 600) {
+			t.Fatalf("a photo turned by orientation 6 is %v; want 300x600", size)
+		}
+		// turned a quarter clockwise, the left half ends up on top
+		if r, _, b, _ := original.At(150, 100).RGBA(); r < b {
+			t.Errorf("the top of the turned photo is not red")
+		}
+		if r, _, b, _ := original.At(150, 500).RGBA(); b < r {
+			t.Errorf("the bottom of the turned photo is not blue")
+		}
+		thumbnail, err := jpeg.Decode(bytes.NewReader(result.thumbnail))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := thumbnail.Bounds().Size(); size != image.Pt(thumbSize/2, thumbSize) {
+			t.Fatalf("thumbnail is %v; want %dx%d", size, thumbSize/2, thumbSize)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// a 3 by 2 image whose top left pixel is marked
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	for _, test := range []struct {
+		o      int
+		size   image.Point
+		marked image.Point
+	}{
+		{1, image.Pt(3, 2), image.Pt(0, 0)},
+		{2, image.Pt(3, 2), image.Pt(2, 0)},
+		{3, image.Pt(3, 2), image.Pt(2, 1)},
+		{4, image.Pt(3, 2), image.Pt(0, 1)},
+		{5, image.Pt(2, 3), image.Pt(0, 0)},
+		{6, image.Pt(2, 3), image.Pt(1, 0)},
+		{7, image.Pt(2, 3), image.Pt(1, 2)},
+		{8, image.Pt(2, 3), image.Pt(0, 2)},
+		{9, image.Pt(3, 2), image.Pt(0, 0)},
+	} {
+		turned := orient(img, test.o)
+		if size := turned.Bounds().Size(); size != test.size {
+			t.Errorf("orient %d gives %v; want %v", test.o, size, test.size)
+			continue
+		}
+		if r, _, _, _ := turned.At(test.marked.X, test.marked.Y).RGBA(); r == 0 {
+			t.Errorf("orient %d did not move the top left pixel to %v", test.o, test.marked)
+		}
+	}
+}
+
+func TestShrink(t *testing.T) {
+	for _, test := range []struct {
+		from, want image.Point
+	}{
+		{image.Pt(1000, 500), image.Pt(thumbSize, thumbSize/2)},
+		{image.Pt(500, 1000), image.Pt(thumbSize/2, thumbSize)},
+		{image.Pt(5000, 10), image.Pt(thumbSize, 1)},
+		{image.Pt(100, 50), image.Pt(100, 50)},
+	} {
+		shrunk := shrink(image.NewRGBA(image.Rectangle{Max: test.from}), thumbSize)
+		if size := shrunk.Bounds().Size(); size != test.want {
+			t.Errorf("shrink %v = %v; want %v", test.from, size, test.want)
+		}
+	}
+}
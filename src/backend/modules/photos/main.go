@@ -0,0 +1,156 @@
+package photos
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"example.com/members"
+	"example.com/response"
+)
+
+// Photo names a stored image. Passport and Thumbnail are the URLs to save
+// on a member, district or group.
+type Photo struct {
+	Id        string
+	Passport  string
+	Thumbnail string
+}
+
+// Photos keeps uploaded passport photos on disk under the sha256 of their
+// re-encoded bytes, with a thumbnail of each in the thumbs directory.
+type Photos struct {
+	dir     string
+	members *members.Members
+}
+
+var validId = regexp.MustCompile(`^[0-9a-f]{64}\.(jpg|png)$`)
+
+func NewPhotos(m *members.Members, dir string) *Photos {
+	if err := os.MkdirAll(filepath.Join(dir, "thumbs"), 0750); err != nil {
+		log.Fatal("error creating photo directory " + err.Error())
+	}
+	return &Photos{dir: dir, members: m}
+}
+
+func (photos *Photos) photo(id string) Photo {
+	link := os.Getenv("Public_URL") + "/photo?id=" + url.QueryEscape(id)
+	return Photo{Id: id, Passport: link, Thumbnail: link + "&size=thumb"}
+}
+
+// write saves data as name unless a file with that content-addressed name
+// is already there, going through a temporary file so a reader never sees
+// half an image.
+func write(name string, data []byte) error {
+	if _, err := os.Stat(name); err == nil {
+		return nil
+	}
+	temp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err = temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err = temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), name)
+}
+
+// add stores an uploaded image and its thumbnail.
+func (photos *Photos) add(data []byte) (Photo, error) {
+	image, err := process(data)
+	if err != nil {
+		return Photo{}, err
+	}
+	sum := sha256.Sum256(image.original)
+	id := hex.EncodeToString(sum[:]) + image.ext
+	if err = write(filepath.Join(photos.dir, id), image.original); err != nil {
+		return Photo{}, fmt.Errorf("error saving photo")
+	}
+	if err = write(filepath.Join(photos.dir, "thumbs", id), image.thumbnail); err != nil {
+		return Photo{}, fmt.Errorf("error saving thumbnail")
+	}
+	return photos.photo(id), nil
+}
+
+func (photos *Photos) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.URL.Path, "/photo") {
+		return
+	}
+	// photos of members are personal data, so unlike /assets/ they are only
+	// shown to people logged in as members
+	if _, ok := photos.members.Caller(r); !ok {
+		response.Failf(w, http.StatusUnauthorized, "log in to see photos")
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		{
+			r.Body = http.MaxBytesReader(w, r.Body, maxPhoto+1<<20)
+			file, _, err := r.FormFile("photo")
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				response.Failf(w, http.StatusRequestEntityTooLarge, "photos must be at most %d MB", maxPhoto>>20)
+				return
+			} else if err != nil {
+				response.Fail(w, response.Invalid("attach the photo as the photo field", map[string]string{"photo": "required"}))
+				return
+			}
+			defer file.Close()
+			data, err := io.ReadAll(io.LimitReader(file, maxPhoto+1))
+			if err != nil {
+				response.Fail(w, err)
+				return
+			}
+			if len(data) > maxPhoto {
+				response.Failf(w, http.StatusRequestEntityTooLarge, "photos must be at most %d MB", maxPhoto>>20)
+				return
+			}
+			photo, err := photos.add(data)
+			if err != nil {
+				response.Fail(w, err)
+				return
+			}
+			response.OK(w, photo)
+		}
+	case http.MethodGet:
+		{
+			id := r.URL.Query().Get("id")
+			if !validId.MatchString(id) {
+				response.Failf(w, http.StatusNotFound, "photo does not exists")
+				return
+			}
+			name := filepath.Join(photos.dir, id)
+			if r.URL.Query().Get("size") == "thumb" {
+				name = filepath.Join(photos.dir, "thumbs", id)
+			}
+			file, err := os.Open(name)
+			if err != nil {
+				response.Failf(w, http.StatusNotFound, "photo does not exists")
+				return
+			}
+			defer file.Close()
+			info, err := file.Stat()
+			if err != nil {
+				response.Fail(w, err)
+				return
+			}
+			// the name is the content hash, so the bytes behind it never change
+			w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+			http.ServeContent(w, r, id, info.ModTime(), file)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package photos
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAddStoresByContent(t *testing.T) {
+	dir := t.TempDir()
+	photos := NewPhotos(nil, dir)
+	var upload bytes.Buffer
+	png.Encode(&upload, halves(400, 300))
+	first, err := photos.add(upload.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := photos.add(upload.Bytes())
+	if err != nil || second.Id != first.Id {
+		t.Fatalf("the same upload was stored as %s and %s", first.Id, second.Id)
+	}
+	if !validId.MatchString(first.Id) {
+		t.Fatalf("photo id %q is not a content address", first.Id)
+	}
+	saved, err := os.ReadFile(filepath.Join(dir, first.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(saved)
+	if hex.EncodeToString(sum[:])+".png" != first.Id {
+		t.Fatalf("photo %s is not named after its sha256", first.Id)
+	}
+	thumb, err := os.ReadFile(filepath.Join(dir, "thumbs", first.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config, err := png.DecodeConfig(bytes.NewReader(thumb)); err != nil || config.Width != thumbSize {
+		t.Fatalf("thumbnail is %dx%d %v", config.Width, config.Height, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".upload-") {
+			t.Fatalf("a temporary file was left behind: %s", entry.Name())
+		}
+	}
+}
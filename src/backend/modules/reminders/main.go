@@ -0,0 +1,195 @@
+package reminders
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"example.com/members"
+	"example.com/scheduler"
+)
+
+// Mailer queues a templated mail, see notifications.Notifications.
+type Mailer interface {
+	Queue(to, template string, data interface{}) error
+}
+
+// Leaders maps district Ids to the member Ids leading them.
+type Leaders interface {
+	Leaders() map[string][]string
+}
+
+// Register gives the date of each member's record of a kind, see
+// sacraments.Sacraments.
+type Register interface {
+	Dates(kind string) map[string]string
+}
+
+// Birthday is one line of an elder's weekly digest.
+type Birthday struct {
+	Name string
+	Day  string
+	Age  int
+	date time.Time
+}
+
+type Reminders struct {
+	members   *members.Members
+	districts Leaders
+	register  Register
+	mailer    Mailer
+}
+
+const dateLayout = "2006-01-02"
+
+func NewReminders(m *members.Members, districts Leaders, register Register, mailer Mailer) *Reminders {
+	return &Reminders{members: m, districts: districts, register: register, mailer: mailer}
+}
+
+// Jobs returns the reminder jobs: birthday greetings and baptism
+// anniversaries every day, and a birthday digest for district elders every
+// Monday covering the coming week.
+func (reminders *Reminders) Jobs() []scheduler.Job {
+	return []scheduler.Job{
+		{Name: "birthday", Period: scheduler.Daily, Run: reminders.birthdays},
+		{Name: "baptism", Period: scheduler.Daily, Run: reminders.baptisms},
+		{Name: "birthday-digest", Period: scheduler.Weekly(time.Monday), Run: reminders.digest},
+	}
+}
+
+// anniversary returns the date the anniversary of date falls on in year.
+// Anniversaries of 29 February are kept on 28 February in other years.
+func anniversary(date time.Time, year int) time.Time {
+	day := time.Date(year, date.Month(), date.Day(), 0, 0, 0, 0, time.Local)
+	if day.Month() != date.Month() {
+		day = time.Date(year, date.Month(), 28, 0, 0, 0, 0, time.Local)
+	}
+	return day
+}
+
+// falls reports whether the anniversary of date is on day and returns how
+// many years it marks.
+func falls(date string, day time.Time) (int, bool) {
+	parsed, err := time.ParseInLocation(dateLayout, date, time.Local)
+	if err != nil || parsed.Year() >= day.Year() {
+		return 0, false
+	}
+	if !anniversary(parsed, day.Year()).Equal(day) {
+		return 0, false
+	}
+	return day.Year() - parsed.Year(), true
+}
+
+func midnight(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// reachable lists the active members with an email address who have not
+// deactivated their account.
+func (reminders *Reminders) reachable() []members.Member {
+	result := make([]members.Member, 0)
+	for _, member := range reminders.members.List() {
+		if member.Active && len(member.Deactivated) == 0 && len(member.Email) != 0 {
+			result = append(result, member)
+		}
+	}
+	return result
+}
+
+func (reminders *Reminders) birthdays(now time.Time, once scheduler.Once) error {
+	today := midnight(now)
+	var failed error
+	for _, member := range reminders.reachable() {
+		if _, ok := falls(member.DateofBirth, today); !ok {
+			continue
+		}
+		member := member
+		err := once(member.Id, func() error {
+			return reminders.mailer.Queue(member.Email, "birthday", struct{ Name string }{Name: member.Name})
+		})
+		if err != nil {
+			failed = fmt.Errorf("error greeting %s %s", member.Id, err)
+		}
+	}
+	return failed
+}
+
+// baptisms greets members on the anniversary of their baptism, taking the
+// date from the baptism register and falling back to the member record.
+func (reminders *Reminders) baptisms(now time.Time, once scheduler.Once) error {
+	today := midnight(now)
+	register := reminders.register.Dates("baptism")
+	var failed error
+	for _, member := range reminders.reachable() {
+		date, ok := register[member.Id]
+		if !ok {
+			date = member.DateofBaptism
+		}
+		years, ok := falls(date, today)
+		if !ok {
+			continue
+		}
+		member := member
+		data := struct {
+			Name  string
+			Years int
+			Date  string
+		}{Name: member.Name, Years: years, Date: date}
+		err := once(member.Id, func() error { return reminders.mailer.Queue(member.Email, "baptism", data) })
+		if err != nil {
+			failed = fmt.Errorf("error greeting %s %s", member.Id, err)
+		}
+	}
+	return failed
+}
+
+// upcoming lists the birthdays of members of district in the seven days
+// from day, soonest first.
+func (reminders *Reminders) upcoming(district string, day time.Time) []Birthday {
+	result := make([]Birthday, 0)
+	for _, member := range reminders.members.List() {
+		if !member.Active || member.District != district {
+			continue
+		}
+		born, err := time.ParseInLocation(dateLayout, member.DateofBirth, time.Local)
+		if err != nil {
+			continue
+		}
+		for i := 0; i < 7; i++ {
+			date := day.AddDate(0, 0, i)
+			if date.Year() > born.Year() && anniversary(born, date.Year()).Equal(date) {
+				result = append(result, Birthday{Name: member.Name, Day: date.Format("Mon 2 Jan"), Age: date.Year() - born.Year(), date: date})
+				break
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].date.Before(result[j].date) })
+	return result
+}
+
+// digest mails each district elder the birthdays in their district for
+// the coming week.
+func (reminders *Reminders) digest(now time.Time, once scheduler.Once) error {
+	today := midnight(now)
+	var failed error
+	for district, leaders := range reminders.districts.Leaders() {
+		birthdays := reminders.upcoming(district, today)
+		for _, id := range leaders {
+			leader, ok := reminders.members.Get(id)
+			if !ok || len(leader.Email) == 0 {
+				continue
+			}
+			data := struct {
+				Name      string
+				From      string
+				To        string
+				Birthdays []Birthday
+			}{Name: leader.Name, From: today.Format("Mon 2 Jan"), To: today.AddDate(0, 0, 6).Format("Mon 2 Jan"), Birthdays: birthdays}
+			err := once(district+"/"+id, func() error { return reminders.mailer.Queue(leader.Email, "birthdays", data) })
+			if err != nil {
+				failed = fmt.Errorf("error sending digest to %s %s", id, err)
+			}
+		}
+	}
+	return failed
+}
@@ -0,0 +1,92 @@
+package reminders
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/members"
+	"example.com/members/memberstest"
+	"example.com/roles"
+	"example.com/scheduler"
+	"example.com/store"
+)
+
+func date(t *testing.T, value string) time.Time {
+	t.Helper()
+	day, err := time.ParseInLocation(dateLayout, value, time.Local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return day
+}
+
+func TestAnniversary(t *testing.T) {
+	leap := date(t, "2000-02-29")
+	for _, test := range []struct {
+		year int
+		want string
+	}{
+		{2023, "2023-02-28"},
+		{2024, "2024-02-29"},
+		{2100, "2100-02-28"},
+	} {
+		if got := anniversary(leap, test.year).Format(dateLayout); got != test.want {
+			t.Errorf("anniversary of 2000-02-29 in %d = %s; want %s", test.year, got, test.want)
+		}
+	}
+	if got := anniversary(date(t, "1990-03-01"), 2023).Format(dateLayout); got != "2023-03-01" {
+		t.Errorf("anniversary of 1990-03-01 in 2023 = %s", got)
+	}
+
+	if years, ok := falls("2000-02-29", date(t, "2023-02-28")); !ok || years != 23 {
+		t.Errorf("falls on 2023-02-28 = %d %v; want 23 years", years, ok)
+	}
+	for _, day := range []string{"2023-03-01", "2024-02-28", "2000-02-29"} {
+		if _, ok := falls("2000-02-29", date(t, day)); ok {
+			t.Errorf("a 29 February birthday falls on %s", day)
+		}
+	}
+}
+
+type mailbox struct {
+	mu   sync.Mutex
+	sent map[string]int
+}
+
+func (box *mailbox) Queue(to, template string, data interface{}) error {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	box.sent[template+" "+to]++
+	return nil
+}
+
+type noLeaders struct{}
+
+func (noLeaders) Leaders() map[string][]string { return nil }
+
+type noRegister struct{}
+
+func (noRegister) Dates(kind string) map[string]string { return nil }
+
+func TestBirthdayGreetedOnce(t *testing.T) {
+	m := memberstest.New(t,
+		members.Member{Id: "akinyi", Email: "akinyi@example.com", Role: roles.Member, DateofBirth: "1992-02-29"},
+		members.Member{Id: "otieno", Email: "otieno@example.com", Role: roles.Member, DateofBirth: "1992-03-01"},
+	)
+	box := &mailbox{sent: make(map[string]int)}
+	runs, claims := store.NewMemory[scheduler.JobRun]("_id"), store.NewMemory[scheduler.Claim]("_id")
+	start := func() *scheduler.Scheduler {
+		jobs := scheduler.NewScheduler(runs, claims)
+		for _, job := range NewReminders(m, noLeaders{}, noRegister{}, box).Jobs() {
+			jobs.Add(job)
+		}
+		return jobs
+	}
+	morning := date(t, "2025-02-28").Add(6 * time.Hour)
+	start().Tick(morning)
+	start().Tick(morning.Add(time.Hour))
+	if len(box.sent) != 1 || box.sent["birthday akinyi@example.com"] != 1 {
+		t.Fatalf("sent %v; want one greeting for the 29 February birthday", box.sent)
+	}
+}
@@ -0,0 +1,5 @@
+module example.com/response
+
+go 1.21.3
+
+require github.com/google/uuid v1.6.0
@@ -0,0 +1,154 @@
+// Package response writes the API's JSON answers. Every error is sent with
+// the status it stands for and one body:
+//
+//	{"Error":"member does not exists","Code":"not_found","Fields":{...},"RequestId":"..."}
+//
+// Error keeps the name the frontend has always read the message from.
+// Fields, present on validation errors, maps a request field to what is
+// wrong with it. RequestId is also sent as the X-Request-Id header. Server
+// errors are logged with it and answered with a generic message, so what
+// went wrong inside stays in the log and a report can be matched to it.
+package response
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+// Codes name the kind of error, one per status the API answers with.
+const (
+	BadRequest   = "bad_request"
+	Unauthorized = "unauthorized"
+	Forbidden    = "forbidden"
+	NotFound     = "not_found"
+	Conflict     = "conflict"
+	Internal     = "internal"
+	// Codes for the rarer statuses some endpoints answer with.
+	MethodNotAllowed = "method_not_allowed"
+	TooLarge         = "too_large"
+	Unsupported      = "unsupported_media_type"
+	TooMany          = "too_many_requests"
+)
+
+var codes = map[int]string{
+	http.StatusBadRequest:            BadRequest,
+	http.StatusUnauthorized:          Unauthorized,
+	http.StatusForbidden:             Forbidden,
+	http.StatusNotFound:              NotFound,
+	http.StatusConflict:              Conflict,
+	http.StatusMethodNotAllowed:      MethodNotAllowed,
+	http.StatusRequestEntityTooLarge: TooLarge,
+	http.StatusUnsupportedMediaType:  Unsupported,
+	http.StatusTooManyRequests:       TooMany,
+	http.StatusInternalServerError:   Internal,
+}
+
+// internal is the message server errors are answered with.
+const internal = "something went wrong on the server, quote the request id when reporting it"
+
+// Header carries the request id on requests and answers.
+const Header = "X-Request-Id"
+
+// ids from a proxy are logged, so only plain ones are kept
+var validId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Body is what every error answer carries.
+type Body struct {
+	Error     string
+	Code      string
+	Fields    map[string]string `json:",omitempty"`
+	RequestId string
+}
+
+// Error is an error that knows the status to answer with.
+type Error struct {
+	Status  int
+	Message string
+	Fields  map[string]string
+}
+
+func (err *Error) Error() string {
+	return err.Message
+}
+
+// Errorf makes an error answered with status.
+func Errorf(status int, format string, args ...interface{}) error {
+	return &Error{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// Invalid makes a 400 error naming what is wrong with each field.
+func Invalid(message string, fields map[string]string) error {
+	return &Error{Status: http.StatusBadRequest, Message: message, Fields: fields}
+}
+
+// RequestId gives every request an id, taken from the X-Request-Id header
+// when a proxy in front already set a plain one.
+func RequestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !validId.MatchString(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// JSON answers with v and status.
+func JSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// OK answers with v.
+func OK(w http.ResponseWriter, v interface{}) {
+	JSON(w, http.StatusOK, v)
+}
+
+// Fail answers with err. Errors made by Errorf or Invalid carry their
+// status; any other error is a server error. Server errors are logged and
+// their text is not sent.
+func Fail(w http.ResponseWriter, err error) {
+	status, body := Describe(w, err)
+	JSON(w, status, body)
+}
+
+// Describe gives the status and body Fail would answer err with, for
+// handlers that add to the body.
+func Describe(w http.ResponseWriter, err error) (int, Body) {
+	var answer *Error
+	if !errors.As(err, &answer) {
+		answer = &Error{Status: http.StatusInternalServerError, Message: err.Error()}
+	}
+	code, ok := codes[answer.Status]
+	if !ok {
+		code = Internal
+	}
+	id := w.Header().Get(Header)
+	if answer.Status >= http.StatusInternalServerError {
+		log.Printf("request %s: %s", id, answer.Message)
+		return answer.Status, Body{Error: internal, Code: code, RequestId: id}
+	}
+	return answer.Status, Body{Error: answer.Message, Code: code, Fields: answer.Fields, RequestId: id}
+}
+
+// Failf answers with a new error, as Fail(w, Errorf(status, ...)).
+func Failf(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	Fail(w, Errorf(status, format, args...))
+}
+
+// Decode reads the JSON request body into v, answering 400 when it cannot.
+func Decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		Failf(w, http.StatusBadRequest, "request body is not valid JSON: %s", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+package response
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fail answers a request through RequestId with err and decodes the body.
+func fail(t *testing.T, err error) (int, Body) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	RequestId(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { Fail(w, err) })).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	var body Body
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding %q: %s", rec.Body.String(), err)
+	}
+	if body.RequestId != rec.Header().Get(Header) || len(body.RequestId) == 0 {
+		t.Fatalf("body carries request id %q, header %q", body.RequestId, rec.Header().Get(Header))
+	}
+	return rec.Code, body
+}
+
+func TestFailHidesServerErrorDetail(t *testing.T) {
+	code, body := fail(t, errors.New("error updating member: connection refused to mongo:27017"))
+	if code != http.StatusInternalServerError || body.Code != Internal || strings.Contains(body.Error, "mongo") {
+		t.Fatalf("server error answered %d %+v; want a generic 500", code, body)
+	}
+	code, body = fail(t, Invalid("a valid email is required", map[string]string{"Email": "not a valid address"}))
+	if code != http.StatusBadRequest || body.Error != "a valid email is required" || body.Fields["Email"] == "" {
+		t.Fatalf("validation error answered %d %+v", code, body)
+	}
+	if code, body = fail(t, Errorf(http.StatusTooManyRequests, "too many requests")); code != http.StatusTooManyRequests || body.Code != TooMany {
+		t.Fatalf("rate limit answered %d %+v", code, body)
+	}
+}
@@ -0,0 +1,190 @@
+package roles
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Role numbers are stored on members.Member.Role. Admin and Member keep the
+// values already used by the default account and the signup form.
+const (
+	Guest         = 0
+	Admin         = 1
+	Member        = 2
+	DistrictElder = 3
+	GroupLeader   = 4
+	Usher         = 5
+	Treasurer     = 6
+)
+
+type Role struct {
+	Id          int
+	Name        string
+	Permissions map[string][]string
+}
+
+type Roles struct {
+	roles map[int]*Role
+}
+
+var all = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}
+
+func NewRoles() *Roles {
+	roles := map[int]*Role{
+		Guest: {Id: Guest, Name: "guest", Permissions: map[string][]string{
+			"/message":               {http.MethodPost},
+			"/user":                  {http.MethodPost},
+			"/user/login":            {http.MethodPost},
+			"/user/logout":           {http.MethodGet},
+			"/user/me":               {http.MethodGet},
+			"/password/forgot":       {http.MethodPost},
+			"/password/reset":        {http.MethodPost},
+			"/verify":                {http.MethodPost},
+			"/verify/resend":         {http.MethodPost},
+			"/me":                    {http.MethodGet, http.MethodPut},
+			"/me/password":           {http.MethodPut},
+			"/me/deactivate":         {http.MethodPost},
+			"/photo":                 {http.MethodGet, http.MethodPost},
+			"/event":                 {http.MethodGet},
+			"/event/upcoming":        {http.MethodGet},
+			"/event/calendar.ics":    {http.MethodGet},
+			"/event/rsvp":            {http.MethodPost, http.MethodDelete},
+			"/sermon":                {http.MethodGet},
+			"/sermon/media":          {http.MethodGet},
+			"/sermon/feed.rss":       {http.MethodGet},
+			"/announcement/live":     {http.MethodGet},
+			"/announcement/bulletin": {http.MethodGet},
+			"/sms/report":            {http.MethodPost},
+		}},
+		Admin: {Id: Admin, Name: "admin", Permissions: map[string][]string{
+			"/member":                all,
+			"/group":                 all,
+			"/district":              all,
+			"/message":               all,
+			"/message/reply":         {http.MethodPost},
+			"/membership":            all,
+			"/integrity":             {http.MethodGet},
+			"/household":             all,
+			"/sacrament":             {http.MethodGet, http.MethodPost, http.MethodPut},
+			"/sacrament/certificate": {http.MethodGet},
+			"/attendance":            all,
+			"/attendance/checkin":    all,
+			"/attendance/history":    {http.MethodGet},
+			"/attendance/headcount":  {http.MethodGet},
+			"/attendance/absent":     {http.MethodGet},
+			"/user":                  all,
+			"/user/promote":          {http.MethodPost},
+			"/giving":                {http.MethodGet},
+			"/giving/statement":      {http.MethodGet},
+			"/giving/totals":         {http.MethodGet},
+			"/giving/log":            {http.MethodGet},
+			"/event":                 all,
+			"/event/rsvp":            {http.MethodGet},
+			"/event/attendees.csv":   {http.MethodGet},
+			"/sermon":                all,
+			"/sermon/media":          {http.MethodPost},
+			"/announcement":          all,
+			"/outbox":                {http.MethodGet, http.MethodPut},
+			"/job":                   {http.MethodGet},
+			"/sms":                   all,
+		}},
+		DistrictElder: {Id: DistrictElder, Name: "district elder", Permissions: map[string][]string{
+			"/member":                {http.MethodGet, http.MethodPost, http.MethodPut},
+			"/group":                 {http.MethodGet},
+			"/district":              {http.MethodGet},
+			"/message":               {http.MethodGet, http.MethodPost, http.MethodPut},
+			"/message/reply":         {http.MethodPost},
+			"/membership":            {http.MethodGet},
+			"/household":             {http.MethodGet, http.MethodPost, http.MethodPut},
+			"/sacrament":             {http.MethodGet},
+			"/sacrament/certificate": {http.MethodGet},
+			"/attendance":            {http.MethodGet},
+			"/attendance/history":    {http.MethodGet},
+			"/attendance/headcount":  {http.MethodGet},
+			"/attendance/absent":     {http.MethodGet},
+			"/event":                 all,
+			"/event/rsvp":            {http.MethodGet},
+			"/event/attendees.csv":   {http.MethodGet},
+			"/announcement":          all,
+			"/sms":                   {http.MethodGet, http.MethodPost},
+		}},
+		GroupLeader: {Id: GroupLeader, Name: "group leader", Permissions: map[string][]string{
+			"/member":               {http.MethodGet, http.MethodPost, http.MethodPut},
+			"/group":                {http.MethodGet},
+			"/district":             {http.MethodGet},
+			"/message":              {http.MethodGet, http.MethodPost, http.MethodPut},
+			"/message/reply":        {http.MethodPost},
+			"/membership":           {http.MethodGet},
+			"/attendance":           {http.MethodGet, http.MethodPost},
+			"/attendance/checkin":   all,
+			"/attendance/history":   {http.MethodGet},
+			"/attendance/headcount": {http.MethodGet},
+			"/attendance/absent":    {http.MethodGet},
+			"/event":                all,
+			"/event/rsvp":           {http.MethodGet},
+			"/event/attendees.csv":  {http.MethodGet},
+			"/announcement":         all,
+			"/sms":                  {http.MethodGet, http.MethodPost},
+		}},
+		Usher: {Id: Usher, Name: "usher", Permissions: map[string][]string{
+			"/member":               {http.MethodGet},
+			"/group":                {http.MethodGet},
+			"/district":             {http.MethodGet},
+			"/message":              {http.MethodPost},
+			"/attendance":           {http.MethodGet, http.MethodPost},
+			"/attendance/checkin":   all,
+			"/attendance/headcount": {http.MethodGet},
+		}},
+		Treasurer: {Id: Treasurer, Name: "treasurer", Permissions: map[string][]string{
+			"/member":           {http.MethodGet},
+			"/group":            {http.MethodGet},
+			"/district":         {http.MethodGet},
+			"/message":          {http.MethodPost},
+			"/giving":           all,
+			"/giving/statement": {http.MethodGet},
+			"/giving/totals":    {http.MethodGet},
+			"/giving/log":       {http.MethodGet},
+		}},
+		Member: {Id: Member, Name: "member", Permissions: map[string][]string{
+			"/member":                {http.MethodGet},
+			"/group":                 {http.MethodGet},
+			"/district":              {http.MethodGet},
+			"/message":               {http.MethodPost},
+			"/membership":            {http.MethodGet},
+			"/sacrament":             {http.MethodGet},
+			"/sacrament/certificate": {http.MethodGet},
+			"/attendance/history":    {http.MethodGet},
+			"/giving/statement":      {http.MethodGet},
+		}},
+	}
+	return &Roles{roles: roles}
+}
+
+func (roles *Roles) Get(id int) (*Role, bool) {
+	role, ok := roles.roles[id]
+	return role, ok
+}
+
+// Allowed reports whether the role may call method on the resource path.
+// Whatever a guest may do every role may do; unknown roles and resources are
+// denied.
+func (roles *Roles) Allowed(id int, resource, method string) bool {
+	role, ok := roles.roles[id]
+	if !ok {
+		return false
+	}
+	if id != Guest && roles.Allowed(Guest, resource, method) {
+		return true
+	}
+	for path, methods := range role.Permissions {
+		if !strings.EqualFold(path, resource) {
+			continue
+		}
+		for _, m := range methods {
+			if strings.EqualFold(m, method) {
+				return true
+			}
+		}
+	}
+	return false
+}
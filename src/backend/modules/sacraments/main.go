@@ -0,0 +1,317 @@
+package sacraments
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"example.com/members"
+	"example.com/response"
+	"example.com/roles"
+	"example.com/store"
+	"github.com/google/uuid"
+)
+
+// Kinds of sacramental records, each with its own register.
+const (
+	Baptism   = "baptism"
+	Catechism = "catechism"
+	Marriage  = "marriage"
+	Burial    = "burial"
+)
+
+var kinds = []string{Baptism, Catechism, Marriage, Burial}
+
+// Sacrament is one entry in a register. Member and Partner hold member Ids;
+// Partner is only used for marriages. Witnesses is semicolon separated.
+// District is the member's district when the entry was made, keeping the
+// record in its elders' reach after the member record is gone.
+type Sacrament struct {
+	Id             string `bson:"Id"`
+	Kind           string `bson:"Kind"`
+	RegisterNumber int    `bson:"RegisterNumber"`
+	Member         string `bson:"Member"`
+	Partner        string `bson:"Partner"`
+	Date           string `bson:"Date"`
+	Minister       string `bson:"Minister"`
+	Location       string `bson:"Location"`
+	Witnesses      string `bson:"Witnesses"`
+	Notes          string `bson:"Notes"`
+	District       string `bson:"District"`
+}
+
+// Certificate carries what the frontend needs to print a record.
+type Certificate struct {
+	Sacrament Sacrament
+	Member    string
+	Partner   string
+	Witnesses []string
+}
+
+type Sacraments struct {
+	sacraments *store.Cache[Sacrament]
+	store      store.Store[Sacrament]
+	counter    store.Counter
+	members    *members.Members
+	// mutex serializes add and update; reads use cache snapshots
+	mutex sync.Mutex
+}
+
+// Collections the Mongo stores keep sacraments and register numbers in.
+const (
+	SacramentCollection = "sacrament"
+	CounterCollection   = "counter"
+)
+
+// NewSacraments loads the registers from records. Counter numbers the
+// entries of each register.
+func NewSacraments(records store.Store[Sacrament], counter store.Counter, m *members.Members) *Sacraments {
+	sacraments, err := records.All()
+	if err != nil {
+		log.Fatal("error loading sacraments data " + err.Error())
+	}
+	// records made before District was kept take it from their member
+	for _, sacrament := range sacraments {
+		if member, ok := m.Get(sacrament.Member); ok && len(sacrament.District) == 0 && len(member.District) != 0 {
+			if err := records.Update(sacrament.Id, map[string]interface{}{"District": member.District}); err != nil {
+				log.Fatal("error migrating sacraments data " + err.Error())
+			}
+			sacrament.District = member.District
+		}
+	}
+	cache := store.NewCache(sacraments, func(sacrament *Sacrament) string { return sacrament.Id })
+	cache.Follow("sacraments", records)
+	return &Sacraments{sacraments: cache, store: records, counter: counter, members: m}
+}
+
+// Dates returns the date of every record of kind keyed by member Id.
+func (sacraments *Sacraments) Dates(kind string) map[string]string {
+	dates := make(map[string]string)
+	for _, sacrament := range sacraments.sacraments.All() {
+		if sacrament.Kind == kind && len(sacrament.Member) != 0 {
+			dates[sacrament.Member] = sacrament.Date
+		}
+	}
+	return dates
+}
+
+// next hands out the following register number for kind. The counter is
+// kept with the records so numbers stay sequential across restarts and
+// replicas.
+func (sacraments *Sacraments) next(kind string) (int, error) {
+	number, err := sacraments.counter.Next(SacramentCollection + "." + kind)
+	if err != nil {
+		return 0, fmt.Errorf("error allocating register number")
+	}
+	return number, nil
+}
+
+func validKind(kind string) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// people checks that Member, and for a marriage Partner, name registered
+// members, and fills in the member's District.
+func (sacraments *Sacraments) people(sacrament *Sacrament) error {
+	member, ok := sacraments.members.Get(sacrament.Member)
+	if !ok {
+		return response.Invalid("member does not exists", map[string]string{"Member": "no such member"})
+	}
+	sacrament.District = member.District
+	if sacrament.Kind != Marriage {
+		if len(sacrament.Partner) != 0 {
+			return response.Invalid("only a marriage has a partner", map[string]string{"Partner": "not used for a " + sacrament.Kind})
+		}
+		return nil
+	}
+	if _, ok := sacraments.members.Get(sacrament.Partner); !ok {
+		return response.Invalid("a marriage needs a registered partner", map[string]string{"Partner": "no such member"})
+	}
+	if strings.EqualFold(sacrament.Partner, sacrament.Member) {
+		return response.Invalid("a member cannot marry themselves", map[string]string{"Partner": "same as Member"})
+	}
+	return nil
+}
+
+func (sacraments *Sacraments) add(newsacrament *Sacrament) (*Sacrament, error) {
+	newsacrament.Kind = strings.ToLower(newsacrament.Kind)
+	if !validKind(newsacrament.Kind) {
+		return nil, response.Errorf(http.StatusBadRequest, "unknown sacrament %s", newsacrament.Kind)
+	}
+	if err := sacraments.people(newsacrament); err != nil {
+		return nil, err
+	}
+	sacraments.mutex.Lock()
+	defer sacraments.mutex.Unlock()
+	number, err := sacraments.next(newsacrament.Kind)
+	if err != nil {
+		return nil, err
+	}
+	newsacrament.RegisterNumber = number
+	err = sacraments.store.Insert(newsacrament)
+	if err != nil {
+		return nil, fmt.Errorf("error registering sacrament")
+	}
+	saved := *newsacrament
+	sacraments.sacraments.Put(&saved)
+	return newsacrament, nil
+}
+
+// update corrects a record. Kind and RegisterNumber identify the entry in
+// its register and cannot be changed. District follows the member.
+func (sacraments *Sacraments) update(update map[string]interface{}) (*Sacrament, error) {
+	sacraments.mutex.Lock()
+	defer sacraments.mutex.Unlock()
+	id, _ := update["Id"].(string)
+	delete(update, "Kind")
+	delete(update, "RegisterNumber")
+	delete(update, "District")
+	for _, sacrament := range sacraments.sacraments.All() {
+		if strings.EqualFold(sacrament.Id, id) {
+			usr := *sacrament
+			set, err := store.Apply(&usr, update)
+			if err != nil {
+				return nil, err
+			}
+			_, member := set["Member"]
+			_, partner := set["Partner"]
+			if member || partner {
+				if err := sacraments.people(&usr); err != nil {
+					return nil, err
+				}
+				set["District"] = usr.District
+			}
+			err = sacraments.store.Update(usr.Id, set)
+			if err != nil {
+				return nil, fmt.Errorf("error updating sacrament %s", err)
+			}
+			saved := usr
+			sacraments.sacraments.Put(&saved)
+			return &usr, nil
+		}
+	}
+	return nil, response.Errorf(http.StatusNotFound, "sacrament does not exists")
+}
+
+func (sacraments *Sacraments) find(id string) *Sacrament {
+	return sacraments.sacraments.Get(id)
+}
+
+// visible returns the check for which records the caller may read. Admins
+// see every register and members only their own records. Elders and leaders
+// follow the member scope over either spouse, or over the district kept on
+// the record once the member is gone.
+func (sacraments *Sacraments) visible(r *http.Request) func(*Sacrament) bool {
+	caller, ok := sacraments.members.Caller(r)
+	if !ok {
+		return func(*Sacrament) bool { return false }
+	}
+	switch sacraments.members.RoleOf(caller.Email) {
+	case roles.Admin:
+		return func(*Sacrament) bool { return true }
+	case roles.Member:
+		return func(sacrament *Sacrament) bool {
+			return strings.EqualFold(sacrament.Member, caller.Id) || strings.EqualFold(sacrament.Partner, caller.Id)
+		}
+	}
+	allowed := sacraments.members.Scope(r)
+	return func(sacrament *Sacrament) bool {
+		found := false
+		for _, id := range []string{sacrament.Member, sacrament.Partner} {
+			if member, ok := sacraments.members.Get(id); ok {
+				found = true
+				if allowed(&member) {
+					return true
+				}
+			}
+		}
+		return !found && allowed(&members.Member{District: sacrament.District})
+	}
+}
+
+func (sacraments *Sacraments) certificate(sacrament *Sacrament) Certificate {
+	certificate := Certificate{Sacrament: *sacrament, Witnesses: make([]string, 0)}
+	if member, ok := sacraments.members.Get(sacrament.Member); ok {
+		certificate.Member = member.Name
+	}
+	if partner, ok := sacraments.members.Get(sacrament.Partner); ok {
+		certificate.Partner = partner.Name
+	}
+	for _, witness := range strings.Split(sacrament.Witnesses, ";") {
+		if witness = strings.TrimSpace(witness); len(witness) != 0 {
+			certificate.Witnesses = append(certificate.Witnesses, witness)
+		}
+	}
+	return certificate
+}
+
+func (sacraments *Sacraments) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.URL.Path, "/sacrament/certificate") {
+		sacrament := sacraments.find(r.URL.Query().Get("id"))
+		if sacrament == nil || !sacraments.visible(r)(sacrament) {
+			response.Failf(w, http.StatusNotFound, "sacrament does not exists")
+			return
+		}
+		response.OK(w, sacraments.certificate(sacrament))
+		return
+	} else if strings.EqualFold(r.URL.Path, "/sacrament") {
+		switch r.Method {
+		case http.MethodPost:
+			{
+				var newsacrament Sacrament
+				if !response.Decode(w, r, &newsacrament) {
+					return
+				}
+				newsacrament.Id = uuid.NewString()
+				u, err := sacraments.add(&newsacrament)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		case http.MethodGet:
+			{
+				allowed := sacraments.visible(r)
+				member := r.URL.Query().Get("member")
+				kind := r.URL.Query().Get("kind")
+				result := make([]Sacrament, 0)
+				for _, s := range sacraments.sacraments.All() {
+					if len(member) != 0 && !strings.EqualFold(s.Member, member) && !strings.EqualFold(s.Partner, member) {
+						continue
+					}
+					if len(kind) != 0 && !strings.EqualFold(s.Kind, kind) {
+						continue
+					}
+					if allowed(s) {
+						result = append(result, *s)
+					}
+				}
+				response.OK(w, result)
+				return
+			}
+		case http.MethodPut:
+			{
+				updatesacrament := make(map[string]interface{}, 0)
+				if !response.Decode(w, r, &updatesacrament) {
+					return
+				}
+				u, err := sacraments.update(updatesacrament)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		}
+	}
+}
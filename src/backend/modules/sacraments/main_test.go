@@ -0,0 +1,51 @@
+package sacraments
+
+import (
+	"net/http"
+	"testing"
+
+	"example.com/members"
+	"example.com/members/memberstest"
+	"example.com/roles"
+	"example.com/store"
+)
+
+func TestSacramentsOutliveTheirMember(t *testing.T) {
+	m := memberstest.New(t,
+		members.Member{Id: "admin", Email: "admin@example.com", Role: roles.Admin},
+		members.Member{Id: "elder", Email: "elder@example.com", Role: roles.DistrictElder, District: "d1"},
+		members.Member{Id: "elder2", Email: "elder2@example.com", Role: roles.DistrictElder, District: "d2"},
+		members.Member{Id: "mutua", Email: "mutua@example.com", Role: roles.Member, District: "d1"},
+		members.Member{Id: "nduta", Email: "nduta@example.com", Role: roles.Member, District: "d1"},
+	)
+	m.Lead(memberstest.Leads{"elder": {"d1"}, "elder2": {"d2"}}, memberstest.Leads{})
+	sacraments := NewSacraments(store.NewMemory[Sacrament]("Id"), store.NewMemoryCounter(), m)
+	admin := memberstest.Login(t, m, "admin@example.com")
+
+	refused := []string{
+		`{"Kind":"marriage","Member":"mutua"}`,
+		`{"Kind":"marriage","Member":"mutua","Partner":"ghost"}`,
+		`{"Kind":"marriage","Member":"mutua","Partner":"mutua"}`,
+		`{"Kind":"baptism","Member":"mutua","Partner":"nduta"}`,
+	}
+	for _, body := range refused {
+		if code := memberstest.Request(t, sacraments, admin, http.MethodPost, "/sacrament", body, nil); code != http.StatusBadRequest {
+			t.Errorf("POST /sacrament %s = %d; want 400", body, code)
+		}
+	}
+	var burial Sacrament
+	if code := memberstest.Request(t, sacraments, admin, http.MethodPost, "/sacrament", `{"Kind":"burial","Member":"mutua","Date":"2024-05-01"}`, &burial); code != http.StatusOK || burial.District != "d1" {
+		t.Fatalf("POST /sacrament = %d %+v", code, burial)
+	}
+	if code := memberstest.Request(t, m, admin, http.MethodDelete, "/member", `{"Id":"mutua","Email":"mutua@example.com"}`, nil); code != http.StatusOK {
+		t.Fatalf("DELETE /member = %d", code)
+	}
+
+	for email, want := range map[string]int{"admin@example.com": 1, "elder@example.com": 1, "elder2@example.com": 0} {
+		var listed []Sacrament
+		memberstest.Request(t, sacraments, memberstest.Login(t, m, email), http.MethodGet, "/sacrament", "", &listed)
+		if len(listed) != want {
+			t.Errorf("%s sees %d records of a removed member; want %d", email, len(listed), want)
+		}
+	}
+}
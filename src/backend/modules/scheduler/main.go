@@ -0,0 +1,179 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"example.com/response"
+	"example.com/store"
+)
+
+// Run states of a job for one period.
+const (
+	Running = "running"
+	Done    = "done"
+	Failed  = "failed"
+)
+
+// checkEvery is how often the scheduler looks for due jobs. Jobs run once
+// per period, so this only bounds how late after midnight they start.
+const checkEvery = 15 * time.Minute
+
+// Job is work done once per period. Period names the period now falls in,
+// for example the date for a daily job. Run gets once, which calls do for a
+// key only if it has not succeeded for that key in this period before, so a
+// job rerun after a failure or restart skips what it already did.
+type Job struct {
+	Name   string
+	Period func(now time.Time) string
+	Run    func(now time.Time, once Once) error
+}
+
+// Once runs do unless key was already done in the current period.
+type Once func(key string, do func() error) error
+
+// JobRun is the persisted record of a job for one period.
+type JobRun struct {
+	Id       string `bson:"_id"`
+	Name     string `bson:"Name"`
+	Period   string `bson:"Period"`
+	Status   string `bson:"Status"`
+	Started  string `bson:"Started"`
+	Finished string `bson:"Finished"`
+	Error    string `bson:"Error"`
+	Attempts int    `bson:"Attempts"`
+}
+
+// Claim marks a key of a job as done for its period.
+type Claim struct {
+	Key string `bson:"_id"`
+	At  string `bson:"At"`
+}
+
+type Scheduler struct {
+	jobs   []Job
+	runs   store.Store[JobRun]
+	claims store.Store[Claim]
+}
+
+// Collections the Mongo stores keep job runs and claimed keys in.
+const (
+	RunCollection  = "jobrun"
+	OnceCollection = "jobonce"
+)
+
+// runsListed caps how many runs GET /job returns.
+const runsListed = 100
+
+// NewScheduler keeps job runs in runs and claimed keys in claims. Claims
+// must be keyed on _id, so a second claim of a key fails as a duplicate.
+func NewScheduler(runs store.Store[JobRun], claims store.Store[Claim]) *Scheduler {
+	return &Scheduler{jobs: make([]Job, 0), runs: runs, claims: claims}
+}
+
+// Daily is the period of a job run every day.
+func Daily(now time.Time) string {
+	return now.Format("2006-01-02")
+}
+
+// Weekly is the period of a job run once a week, starting on day. The
+// period is named after that day's date, so a job missed on the day itself
+// still runs later in the week.
+func Weekly(day time.Weekday) func(time.Time) string {
+	return func(now time.Time) string {
+		back := (int(now.Weekday()) - int(day) + 7) % 7
+		return now.AddDate(0, 0, -back).Format("2006-01-02")
+	}
+}
+
+// Add registers a job. Jobs are added before Start.
+func (scheduler *Scheduler) Add(job Job) {
+	scheduler.jobs = append(scheduler.jobs, job)
+}
+
+// Start runs due jobs now and then every checkEvery, in the background.
+func (scheduler *Scheduler) Start() {
+	go func() {
+		for {
+			scheduler.Tick(time.Now())
+			time.Sleep(checkEvery)
+		}
+	}()
+}
+
+// Tick runs every job whose current period is not done yet.
+func (scheduler *Scheduler) Tick(now time.Time) {
+	for _, job := range scheduler.jobs {
+		if err := scheduler.run(job, now); err != nil {
+			log.Printf("job %s: %s", job.Name, err)
+		}
+	}
+}
+
+func (scheduler *Scheduler) run(job Job, now time.Time) error {
+	period := job.Period(now)
+	id := job.Name + "/" + period
+	previous, err := scheduler.runs.Find(map[string]interface{}{"_id": id})
+	if err != nil {
+		return err
+	}
+	if len(previous) != 0 && previous[0].Status == Done {
+		return nil
+	}
+	started := JobRun{Id: id, Name: job.Name, Period: period, Status: Running, Started: now.Format(time.RFC3339), Attempts: 1}
+	if len(previous) == 0 {
+		err = scheduler.runs.Insert(&started)
+	} else {
+		err = scheduler.runs.Update(id, map[string]interface{}{"Status": Running, "Started": started.Started, "Error": "", "Attempts": previous[0].Attempts + 1})
+	}
+	if err != nil {
+		return fmt.Errorf("error recording run %s", err)
+	}
+	set := map[string]interface{}{"Status": Done, "Finished": time.Now().Format(time.RFC3339)}
+	runErr := job.Run(now, func(key string, do func() error) error { return scheduler.once(id+"/"+key, do) })
+	if runErr != nil {
+		set["Status"], set["Error"] = Failed, runErr.Error()
+	}
+	if err := scheduler.runs.Update(id, set); err != nil {
+		return fmt.Errorf("error recording run %s", err)
+	}
+	return runErr
+}
+
+// once claims key before calling do and gives the claim back if do fails.
+// The key is the claim's _id, so the store keeps it unique across restarts
+// and a second claim fails as a duplicate.
+func (scheduler *Scheduler) once(key string, do func() error) error {
+	err := scheduler.claims.Insert(&Claim{Key: key, At: time.Now().Format(time.RFC3339)})
+	if errors.Is(err, store.ErrDuplicate) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err = do(); err != nil {
+		scheduler.claims.Delete(key)
+	}
+	return err
+}
+
+func (scheduler *Scheduler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.URL.Path, "/job") && r.Method == http.MethodGet {
+		match := map[string]interface{}{}
+		if name := r.URL.Query().Get("name"); len(name) != 0 {
+			match["Name"] = name
+		}
+		runs, err := scheduler.runs.Find(match)
+		if err != nil {
+			response.Fail(w, err)
+			return
+		}
+		sort.SliceStable(runs, func(i, j int) bool { return runs[i].Started > runs[j].Started })
+		response.OK(w, runs[:min(len(runs), runsListed)])
+	}
+}
@@ -0,0 +1,92 @@
+package scheduler
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"example.com/store"
+)
+
+func TestTickDoesEachKeyOnce(t *testing.T) {
+	runs := store.NewMemory[JobRun]("_id")
+	claims := store.NewMemory[Claim]("_id")
+	sent := make(map[string]int)
+	fail := map[string]bool{"b": true}
+	job := Job{Name: "greet", Period: Daily, Run: func(now time.Time, once Once) error {
+		var failed error
+		for _, key := range []string{"a", "b", "c"} {
+			key := key
+			if err := once(key, func() error {
+				if fail[key] {
+					return errors.New("mail relay is down")
+				}
+				sent[key]++
+				return nil
+			}); err != nil {
+				failed = err
+			}
+		}
+		return failed
+	}}
+	scheduler := NewScheduler(runs, claims)
+	scheduler.Add(job)
+	now := time.Date(2026, time.October, 18, 6, 0, 0, 0, time.Local)
+
+	scheduler.Tick(now)
+	if sent["a"] != 1 || sent["b"] != 0 || sent["c"] != 1 {
+		t.Fatalf("first tick sent %v", sent)
+	}
+	if run, _ := runs.Find(map[string]interface{}{"_id": "greet/2026-10-18"}); len(run) != 1 || run[0].Status != Failed || run[0].Attempts != 1 {
+		t.Fatalf("run after a failed do = %+v", run)
+	}
+
+	// the failed key is given back and tried again, the others are not
+	fail["b"] = false
+	scheduler.Tick(now.Add(checkEvery))
+	if sent["a"] != 1 || sent["b"] != 1 || sent["c"] != 1 {
+		t.Fatalf("second tick sent %v; want each key once", sent)
+	}
+	if run, _ := runs.Find(map[string]interface{}{"_id": "greet/2026-10-18"}); run[0].Status != Done || run[0].Attempts != 2 {
+		t.Fatalf("run after the retry = %+v", run[0])
+	}
+	scheduler.Tick(now.Add(2 * checkEvery))
+
+	// a restart part way through a run finds the keys already claimed
+	if err := runs.Update("greet/2026-10-18", map[string]interface{}{"Status": Running}); err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewScheduler(runs, claims)
+	restarted.Add(job)
+	restarted.Tick(now.Add(3 * checkEvery))
+	if sent["a"] != 1 || sent["b"] != 1 || sent["c"] != 1 {
+		t.Fatalf("ticks after a restart sent %v; want each key once", sent)
+	}
+
+	// the next day is a new period
+	scheduler.Tick(now.AddDate(0, 0, 1))
+	if sent["a"] != 2 || sent["b"] != 2 || sent["c"] != 2 {
+		t.Fatalf("the next day sent %v; want each key again", sent)
+	}
+}
+
+func TestWeekly(t *testing.T) {
+	monday := Weekly(time.Monday)
+	for _, test := range []struct {
+		day, want string
+	}{
+		{"2026-10-12", "2026-10-12"},
+		{"2026-10-13", "2026-10-12"},
+		{"2026-10-18", "2026-10-12"},
+		{"2026-10-19", "2026-10-19"},
+		{"2026-01-01", "2025-12-29"},
+	} {
+		day, _ := time.ParseInLocation("2006-01-02", test.day, time.Local)
+		if got := monday(day.Add(20 * time.Hour)); got != test.want {
+			t.Errorf("Weekly(Monday) on %s = %s; want %s", test.day, got, test.want)
+		}
+	}
+	if got := Weekly(time.Sunday)(time.Date(2026, time.October, 17, 9, 0, 0, 0, time.Local)); got != "2026-10-11" {
+		t.Errorf("Weekly(Sunday) on a Saturday = %s; want 2026-10-11", got)
+	}
+}
@@ -0,0 +1,87 @@
+package sermons
+
+import (
+	"encoding/xml"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Author      string        `xml:"itunes:author,omitempty"`
+	Description string        `xml:"description"`
+	PubDate     string        `xml:"pubDate"`
+	GUID        rssGUID       `xml:"guid"`
+	Enclosure   *rssEnclosure `xml:"enclosure,omitempty"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Language    string    `xml:"language"`
+	Author      string    `xml:"itunes:author"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Itunes  string     `xml:"xmlns:itunes,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+// writeFeed writes the archive as an RSS 2.0 podcast feed. Only sermons
+// with a recording become items, since podcast apps need an enclosure.
+func (sermons *Sermons) writeFeed(w io.Writer, base string) error {
+	channel := rssChannel{
+		Title:       "PCEA Elijah Wathika Memorial Church Sermons",
+		Link:        base + "/sermon",
+		Description: "Sermons preached at PCEA Elijah Wathika Memorial Church",
+		Language:    "en",
+		Author:      "PCEA Elijah Wathika Memorial Church",
+		Items:       make([]rssItem, 0),
+	}
+	for _, listing := range sermons.List("") {
+		sermon := listing.Sermon
+		if len(sermon.Media) == 0 {
+			continue
+		}
+		description := sermon.Notes
+		if len(sermon.Scripture) != 0 {
+			description = strings.ReplaceAll(sermon.Scripture, ";", "; ") + "\n\n" + description
+		}
+		item := rssItem{
+			Title:       sermon.Title,
+			Author:      listing.Preacher,
+			Description: description,
+			GUID:        rssGUID{Value: sermon.Id},
+			Enclosure: &rssEnclosure{
+				URL:    base + "/sermon/media?id=" + url.QueryEscape(sermon.Id),
+				Length: sermon.MediaSize,
+				Type:   sermon.MediaType,
+			},
+		}
+		if date, err := time.Parse(dateLayout, sermon.Date); err == nil {
+			item.PubDate = date.Format(time.RFC1123Z)
+		}
+		channel.Items = append(channel.Items, item)
+	}
+	io.WriteString(w, xml.Header)
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(rss{Version: "2.0", Itunes: "http://www.itunes.com/dtds/podcast-1.0.dtd", Channel: channel})
+}
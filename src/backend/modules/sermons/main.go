@@ -0,0 +1,226 @@
+package sermons
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/members"
+	"example.com/response"
+	"example.com/store"
+	"github.com/google/uuid"
+)
+
+const dateLayout = "2006-01-02"
+
+// Sermon is a preached message. Preacher holds a member Id and Scripture
+// semicolon separated references. Media names the uploaded recording inside
+// the media directory.
+type Sermon struct {
+	Id        string `bson:"Id"`
+	Title     string `bson:"Title"`
+	Preacher  string `bson:"Preacher"`
+	Date      string `bson:"Date"`
+	Scripture string `bson:"Scripture"`
+	Series    string `bson:"Series"`
+	Notes     string `bson:"Notes"`
+	Media     string `bson:"Media"`
+	MediaType string `bson:"MediaType"`
+	MediaSize int64  `bson:"MediaSize"`
+}
+
+// Listing is the public view of a sermon with the preacher's name.
+type Listing struct {
+	Sermon   Sermon
+	Preacher string
+}
+
+type Sermons struct {
+	sermons *store.Cache[Sermon]
+	store   store.Store[Sermon]
+	members *members.Members
+	dir     string
+	// mutex serializes add, delete and update; reads use cache snapshots
+	mutex sync.Mutex
+}
+
+// SermonCollection is where the Mongo store keeps sermons.
+const SermonCollection = "sermon"
+
+// NewSermons loads the archive and makes sure the directory media is stored
+// in exists.
+func NewSermons(records store.Store[Sermon], m *members.Members, dir string) *Sermons {
+	sermons, err := records.All()
+	if err != nil {
+		log.Fatal("error loading sermons data " + err.Error())
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Fatal("error creating media directory " + err.Error())
+	}
+	cache := store.NewCache(sermons, func(sermon *Sermon) string { return sermon.Id })
+	cache.Follow("sermons", records)
+	return &Sermons{sermons: cache, store: records, members: m, dir: dir}
+}
+
+func (sermons *Sermons) validate(sermon *Sermon) error {
+	if len(strings.TrimSpace(sermon.Title)) == 0 {
+		return response.Errorf(http.StatusBadRequest, "sermon title is required")
+	}
+	if _, ok := sermons.members.Get(sermon.Preacher); !ok {
+		return response.Errorf(http.StatusBadRequest, "preacher does not exists")
+	}
+	if _, err := time.Parse(dateLayout, sermon.Date); err != nil {
+		return response.Errorf(http.StatusBadRequest, "date must look like %s", dateLayout)
+	}
+	return nil
+}
+
+func (sermons *Sermons) add(newsermon *Sermon) (*Sermon, error) {
+	if err := sermons.validate(newsermon); err != nil {
+		return nil, err
+	}
+	newsermon.Media, newsermon.MediaType, newsermon.MediaSize = "", "", 0
+	sermons.mutex.Lock()
+	defer sermons.mutex.Unlock()
+	err := sermons.store.Insert(newsermon)
+	if err != nil {
+		return nil, fmt.Errorf("error registering sermon")
+	}
+	saved := *newsermon
+	sermons.sermons.Put(&saved)
+	return newsermon, nil
+}
+
+func (sermons *Sermons) delete(oldsermon *Sermon) (*Sermon, error) {
+	sermons.mutex.Lock()
+	defer sermons.mutex.Unlock()
+	if sermon := sermons.find(oldsermon.Id); sermon != nil {
+		err := sermons.store.Delete(sermon.Id)
+		if err != nil {
+			return nil, fmt.Errorf("error deleting sermon")
+		}
+		sermons.sermons.Remove(sermon.Id)
+		if len(sermon.Media) != 0 {
+			os.Remove(filepath.Join(sermons.dir, sermon.Media))
+		}
+		return sermon, nil
+	}
+	return nil, response.Errorf(http.StatusNotFound, "sermon does not exists")
+}
+
+// update merges the given fields into a sermon. The media fields only
+// change through an upload.
+func (sermons *Sermons) update(update map[string]interface{}) (*Sermon, error) {
+	sermons.mutex.Lock()
+	defer sermons.mutex.Unlock()
+	sermon := sermons.find(fmt.Sprint(update["Id"]))
+	if sermon == nil {
+		return nil, response.Errorf(http.StatusNotFound, "sermon does not exists")
+	}
+	usr := *sermon
+	set, err := store.Apply(&usr, update, "Id", "Media", "MediaType", "MediaSize")
+	if err != nil {
+		return nil, err
+	}
+	if err := sermons.validate(&usr); err != nil {
+		return nil, err
+	}
+	err = sermons.store.Update(usr.Id, set)
+	if err != nil {
+		return nil, fmt.Errorf("error updating sermon %s", err)
+	}
+	saved := usr
+	sermons.sermons.Put(&saved)
+	return &usr, nil
+}
+
+func (sermons *Sermons) find(id string) *Sermon {
+	return sermons.sermons.Get(id)
+}
+
+// List returns the archive newest first, optionally narrowed to a series.
+func (sermons *Sermons) List(series string) []Listing {
+	result := make([]Listing, 0)
+	for _, sermon := range sermons.sermons.All() {
+		if len(series) != 0 && !strings.EqualFold(sermon.Series, series) {
+			continue
+		}
+		listing := Listing{Sermon: *sermon}
+		if preacher, ok := sermons.members.Get(sermon.Preacher); ok {
+			listing.Preacher = preacher.Name
+		}
+		result = append(result, listing)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Sermon.Date > result[j].Sermon.Date })
+	return result
+}
+
+func (sermons *Sermons) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.URL.Path, "/sermon/media") {
+		sermons.serveMedia(w, r)
+		return
+	} else if strings.EqualFold(r.URL.Path, "/sermon/feed.rss") {
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		if err := sermons.writeFeed(w, os.Getenv("Public_URL")); err != nil {
+			log.Println("sermon feed:", err)
+		}
+		return
+	} else if strings.EqualFold(r.URL.Path, "/sermon") {
+		switch r.Method {
+		case http.MethodPost:
+			{
+				var newsermon Sermon
+				if !response.Decode(w, r, &newsermon) {
+					return
+				}
+				newsermon.Id = uuid.NewString()
+				u, err := sermons.add(&newsermon)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		case http.MethodGet:
+			{
+				response.OK(w, sermons.List(r.URL.Query().Get("series")))
+				return
+			}
+		case http.MethodDelete:
+			{
+				var oldsermon Sermon
+				if !response.Decode(w, r, &oldsermon) {
+					return
+				}
+				u, err := sermons.delete(&oldsermon)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		case http.MethodPut:
+			{
+				updatesermon := make(map[string]interface{}, 0)
+				if !response.Decode(w, r, &updatesermon) {
+					return
+				}
+				u, err := sermons.update(updatesermon)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		}
+	}
+}
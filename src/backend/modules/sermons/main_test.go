@@ -0,0 +1,62 @@
+package sermons
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"example.com/members/memberstest"
+	"example.com/response"
+	"example.com/store"
+)
+
+// post uploads content as the file part of a multipart request.
+func post(t *testing.T, sermons *Sermons, id, filename, content string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", filename)
+	io.WriteString(part, content)
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, "/sermon/media?id="+id, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	sermons.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestUploadReplacesTheCachedSermon(t *testing.T) {
+	records := store.NewMemory[Sermon]("Id")
+	records.Insert(&Sermon{Id: "s1", Title: "The Sower", Date: "2024-06-02"})
+	sermons := NewSermons(records, memberstest.New(t), t.TempDir())
+	before := sermons.find("s1")
+
+	rec := post(t, sermons, "s1", "sower.mp3", "ID3 recording")
+	var updated Sermon
+	if err := json.Unmarshal(rec.Body.Bytes(), &updated); rec.Code != http.StatusOK || err != nil || updated.Media != "s1.mp3" {
+		t.Fatalf("POST /sermon/media = %d %s", rec.Code, rec.Body.String())
+	}
+	if len(before.Media) != 0 {
+		t.Fatalf("the upload changed a sermon already handed out: %+v", before)
+	}
+	if after := sermons.find("s1"); after.MediaSize != int64(len("ID3 recording")) || after.MediaType != "audio/mpeg" {
+		t.Fatalf("the cache holds %+v after the upload", after)
+	}
+	if rec := post(t, sermons, "s1", "sower.exe", "MZ"); rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("uploading an .exe = %d; want 415", rec.Code)
+	}
+}
+
+func TestOversizedUploadIs413(t *testing.T) {
+	_, err := io.ReadAll(http.MaxBytesReader(httptest.NewRecorder(), io.NopCloser(strings.NewReader("too long")), 3))
+	var answer *response.Error
+	if !errors.As(tooLarge(err), &answer) || answer.Status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("tooLarge(%v) = %v; want a 413", err, tooLarge(err))
+	}
+}
@@ -0,0 +1,143 @@
+package sermons
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"example.com/response"
+)
+
+// maxMedia caps a single upload; an hour of video fits comfortably.
+const maxMedia = 1 << 30
+
+// mediaTypes are the recordings accepted, by file extension.
+var mediaTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".ogg":  "audio/ogg",
+	".wav":  "audio/wav",
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+}
+
+// tooLarge turns a read cut short by http.MaxBytesReader into a 413 and
+// wraps any other read error.
+func tooLarge(err error) error {
+	var limit *http.MaxBytesError
+	if errors.As(err, &limit) {
+		return response.Errorf(http.StatusRequestEntityTooLarge, "recordings must be at most %d MB", maxMedia>>20)
+	}
+	return fmt.Errorf("error reading upload %s", err)
+}
+
+// upload streams the "file" part of a multipart request into the media
+// directory, replacing any earlier recording of the sermon, and returns the
+// updated sermon. The file is written before the mutex is taken so a slow
+// upload does not hold up other edits.
+func (sermons *Sermons) upload(id string, r *http.Request) (*Sermon, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, response.Errorf(http.StatusBadRequest, "expected a multipart upload")
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, response.Errorf(http.StatusBadRequest, "no file was uploaded")
+		}
+		if err != nil {
+			return nil, tooLarge(err)
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(part.FileName()))
+		mediaType, ok := mediaTypes[ext]
+		if !ok {
+			return nil, response.Errorf(http.StatusUnsupportedMediaType, "unsupported media type %s", ext)
+		}
+		tmp, err := os.CreateTemp(sermons.dir, "upload-*")
+		if err != nil {
+			return nil, fmt.Errorf("error storing media %s", err)
+		}
+		size, err := io.Copy(tmp, part)
+		tmp.Close()
+		if err != nil {
+			os.Remove(tmp.Name())
+			return nil, tooLarge(err)
+		}
+		sermons.mutex.Lock()
+		defer sermons.mutex.Unlock()
+		sermon := sermons.find(id)
+		if sermon == nil {
+			os.Remove(tmp.Name())
+			return nil, response.Errorf(http.StatusNotFound, "sermon does not exists")
+		}
+		name := sermon.Id + ext
+		if err := os.Rename(tmp.Name(), filepath.Join(sermons.dir, name)); err != nil {
+			os.Remove(tmp.Name())
+			return nil, fmt.Errorf("error storing media %s", err)
+		}
+		set := map[string]interface{}{"Media": name, "MediaType": mediaType, "MediaSize": size}
+		err = sermons.store.Update(sermon.Id, set)
+		if err != nil {
+			return nil, fmt.Errorf("error updating sermon %s", err)
+		}
+		if len(sermon.Media) != 0 && sermon.Media != name {
+			os.Remove(filepath.Join(sermons.dir, sermon.Media))
+		}
+		saved := *sermon
+		saved.Media, saved.MediaType, saved.MediaSize = name, mediaType, size
+		sermons.sermons.Put(&saved)
+		updated := saved
+		return &updated, nil
+	}
+}
+
+// serveMedia uploads a recording on POST and plays it back on GET. Playback
+// goes through http.ServeContent so players can seek with range requests.
+func (sermons *Sermons) serveMedia(w http.ResponseWriter, r *http.Request) {
+	sermon := sermons.find(r.URL.Query().Get("id"))
+	if sermon == nil {
+		response.Failf(w, http.StatusNotFound, "sermon does not exists")
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		{
+			r.Body = http.MaxBytesReader(w, r.Body, maxMedia)
+			updated, err := sermons.upload(sermon.Id, r)
+			if err != nil {
+				response.Fail(w, err)
+				return
+			}
+			response.OK(w, updated)
+			return
+		}
+	case http.MethodGet:
+		{
+			if len(sermon.Media) == 0 {
+				response.Failf(w, http.StatusNotFound, "sermon has no recording")
+				return
+			}
+			file, err := os.Open(filepath.Join(sermons.dir, sermon.Media))
+			if err != nil {
+				response.Failf(w, http.StatusNotFound, "recording is missing")
+				return
+			}
+			defer file.Close()
+			modified := time.Time{}
+			if info, err := file.Stat(); err == nil {
+				modified = info.ModTime()
+			}
+			w.Header().Set("Content-Type", sermon.MediaType)
+			http.ServeContent(w, r, sermon.Media, modified, file)
+			return
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package sms
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"example.com/members"
+	"example.com/response"
+	"example.com/roles"
+	"example.com/store"
+	"github.com/google/uuid"
+)
+
+// Audiences a broadcast can go to.
+const (
+	District = "district"
+	Group    = "group"
+	List     = "list"
+)
+
+// Delivery states. Queued texts wait for the sender, which marks a text
+// sending while it hands it over; Sent means the gateway took it and
+// Delivered that the handset confirmed it. Invalid recipients had no usable
+// number and are never tried.
+const (
+	Queued    = "queued"
+	Sending   = "sending"
+	Sent      = "sent"
+	Delivered = "delivered"
+	Failed    = "failed"
+	Invalid   = "invalid"
+)
+
+// maxLength keeps a broadcast within a handful of SMS parts.
+const maxLength = 480
+
+// claimFor is how long a sender holds a text it is sending. Texts left
+// sending longer, by a sender that died, are taken up again.
+const claimFor = 5 * time.Minute
+
+// Broadcast is one text sent to every member of an audience. Target holds
+// the district or group Id, or the semicolon separated member Ids of a list.
+type Broadcast struct {
+	Id       string `bson:"Id"`
+	Text     string `bson:"Text"`
+	Audience string `bson:"Audience"`
+	Target   string `bson:"Target"`
+	By       string `bson:"By"`
+	Created  string `bson:"Created"`
+}
+
+// Delivery is the state of a broadcast for one member.
+type Delivery struct {
+	Id          string `bson:"Id"`
+	BroadcastId string `bson:"BroadcastId"`
+	MemberId    string `bson:"MemberId"`
+	Phone       string `bson:"Phone"`
+	Status      string `bson:"Status"`
+	Reference   string `bson:"Reference"`
+	Error       string `bson:"Error"`
+	Updated     string `bson:"Updated"`
+}
+
+// Summary counts the deliveries of a broadcast by status.
+type Summary struct {
+	Broadcast Broadcast
+	Counts    map[string]int
+}
+
+// Leadership resolves the districts or groups led by a member.
+type Leadership interface {
+	Led(memberId string) []string
+}
+
+type SMS struct {
+	broadcasts *store.Cache[Broadcast]
+	store      store.Store[Broadcast]
+	deliveries store.Store[Delivery]
+	members    *members.Members
+	districts  Leadership
+	groups     Leadership
+	provider   Provider
+	country    string
+	every      time.Duration
+	kick       chan struct{}
+}
+
+// Collections the Mongo stores keep broadcasts and deliveries in.
+const (
+	BroadcastCollection = "smsbroadcast"
+	DeliveryCollection  = "smsdelivery"
+)
+
+// NewSMS loads past broadcasts and starts the sender, which sends at most
+// perSecond texts a second to stay inside the gateway's limits.
+func NewSMS(records store.Store[Broadcast], deliveries store.Store[Delivery], m *members.Members, districts, groups Leadership, provider Provider, perSecond int) *SMS {
+	broadcasts, err := records.All()
+	if err != nil {
+		log.Fatal("error loading sms data " + err.Error())
+	}
+	if perSecond <= 0 {
+		perSecond = 1
+	}
+	cache := store.NewCache(broadcasts, func(broadcast *Broadcast) string { return broadcast.Id })
+	cache.Follow("sms", records)
+	sms := &SMS{broadcasts: cache, store: records, deliveries: deliveries, members: m, districts: districts, groups: groups, provider: provider,
+		country: DefaultCountry, every: time.Second / time.Duration(perSecond), kick: make(chan struct{}, 1)}
+	go sms.run()
+	return sms
+}
+
+// recipients resolves the members a broadcast goes to, each once. Inactive
+// and deactivated members are left out.
+func (sms *SMS) recipients(broadcast *Broadcast) ([]members.Member, error) {
+	found := make([]members.Member, 0)
+	switch broadcast.Audience {
+	case District:
+		for _, member := range sms.members.List() {
+			if strings.EqualFold(member.District, broadcast.Target) {
+				found = append(found, member)
+			}
+		}
+	case Group:
+		for _, member := range sms.members.List() {
+			if contains(strings.Split(member.Groups, ";"), broadcast.Target) {
+				found = append(found, member)
+			}
+		}
+	case List:
+		for _, id := range strings.Split(broadcast.Target, ";") {
+			member, ok := sms.members.Get(id)
+			if !ok {
+				return nil, response.Errorf(http.StatusBadRequest, "member %s does not exists", id)
+			}
+			found = append(found, member)
+		}
+	default:
+		return nil, response.Errorf(http.StatusBadRequest, "audience must be district, group or list")
+	}
+	result := make([]members.Member, 0, len(found))
+	seen := make(map[string]bool)
+	for _, member := range found {
+		if !member.Active || len(member.Deactivated) != 0 || seen[strings.ToLower(member.Id)] {
+			continue
+		}
+		seen[strings.ToLower(member.Id)] = true
+		result = append(result, member)
+	}
+	return result, nil
+}
+
+func contains(list []string, value string) bool {
+	for _, x := range list {
+		if strings.EqualFold(x, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed reports whether the caller may broadcast to the audience. Admins
+// reach anyone; district elders and group leaders their own district or
+// group, or a list of members within it.
+func (sms *SMS) allowed(r *http.Request, broadcast *Broadcast, recipients []members.Member) bool {
+	caller, ok := sms.members.Caller(r)
+	if !ok {
+		return false
+	}
+	role := sms.members.RoleOf(caller.Email)
+	if role == roles.Admin {
+		return true
+	}
+	var led []string
+	switch role {
+	case roles.DistrictElder:
+		led = sms.districts.Led(caller.Id)
+	case roles.GroupLeader:
+		led = sms.groups.Led(caller.Id)
+	default:
+		return false
+	}
+	if broadcast.Audience == District || broadcast.Audience == Group {
+		return (broadcast.Audience == District) == (role == roles.DistrictElder) && contains(led, broadcast.Target)
+	}
+	allowed := sms.members.Scope(r)
+	for _, member := range recipients {
+		member := member
+		if !allowed(&member) {
+			return false
+		}
+	}
+	return true
+}
+
+// add saves a broadcast and queues a delivery for every recipient.
+func (sms *SMS) add(newbroadcast *Broadcast, recipients []members.Member) (*Broadcast, error) {
+	if len(strings.TrimSpace(newbroadcast.Text)) == 0 {
+		return nil, response.Errorf(http.StatusBadRequest, "text is empty")
+	}
+	if len(newbroadcast.Text) > maxLength {
+		return nil, response.Errorf(http.StatusBadRequest, "text is longer than %d characters", maxLength)
+	}
+	if len(recipients) == 0 {
+		return nil, response.Errorf(http.StatusBadRequest, "the audience has no members")
+	}
+	err := sms.store.Insert(newbroadcast)
+	if err != nil {
+		return nil, fmt.Errorf("error registering broadcast")
+	}
+	saved := *newbroadcast
+	sms.broadcasts.Put(&saved)
+	for _, member := range recipients {
+		delivery := Delivery{Id: uuid.NewString(), BroadcastId: newbroadcast.Id, MemberId: member.Id, Status: Queued, Updated: newbroadcast.Created}
+		phone, err := FirstNumber(member.Contacts, sms.country)
+		if err != nil {
+			delivery.Status, delivery.Error = Invalid, err.Error()
+		}
+		delivery.Phone = phone
+		if err := sms.deliveries.Insert(&delivery); err != nil {
+			return nil, fmt.Errorf("broadcast saved but its deliveries were not queued %s", err)
+		}
+	}
+	select {
+	case sms.kick <- struct{}{}:
+	default:
+	}
+	return newbroadcast, nil
+}
+
+// run sends queued texts oldest first, one every sms.every.
+func (sms *SMS) run() {
+	limit := time.NewTicker(sms.every)
+	defer limit.Stop()
+	for {
+		delivery, err := sms.next()
+		if err != nil || delivery == nil {
+			if err != nil {
+				log.Println("sms:", err)
+			}
+			select {
+			case <-sms.kick:
+			case <-time.After(time.Minute):
+			}
+			continue
+		}
+		<-limit.C
+		sms.send(delivery)
+	}
+}
+
+// next claims the oldest queued text, or one left sending past claimFor.
+// A text is claimed by moving it to sending only if no one else has since,
+// so senders running side by side, in this process or another replica,
+// never text anyone twice. It returns nil when nothing is waiting.
+func (sms *SMS) next() (*Delivery, error) {
+	now := time.Now()
+	due := make([]*Delivery, 0)
+	for _, status := range []string{Queued, Sending} {
+		deliveries, err := sms.deliveries.Find(map[string]interface{}{"Status": status})
+		if err != nil {
+			return nil, err
+		}
+		for _, delivery := range deliveries {
+			if claimed, err := time.Parse(time.RFC3339Nano, delivery.Updated); status == Queued || err != nil || now.Sub(claimed) > claimFor {
+				due = append(due, delivery)
+			}
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].Updated < due[j].Updated })
+	for _, delivery := range due {
+		claim := map[string]interface{}{"Status": Sending, "Updated": now.Format(time.RFC3339Nano)}
+		matched, err := sms.deliveries.UpdateWhere(map[string]interface{}{"Id": delivery.Id, "Status": delivery.Status, "Updated": delivery.Updated}, claim)
+		if err != nil {
+			return nil, err
+		}
+		if matched != 0 {
+			return delivery, nil
+		}
+	}
+	return nil, nil
+}
+
+// send hands a claimed text to the provider and records what came of it.
+func (sms *SMS) send(delivery *Delivery) {
+	set := map[string]interface{}{"Updated": time.Now().Format(time.RFC3339Nano)}
+	if reference, err := sms.provider.Send(delivery.Phone, sms.text(delivery.BroadcastId)); err != nil {
+		set["Status"], set["Error"] = Failed, err.Error()
+	} else {
+		set["Status"], set["Reference"] = Sent, reference
+	}
+	if err := sms.deliveries.Update(delivery.Id, set); err != nil {
+		log.Println("sms:", err)
+	}
+}
+
+func (sms *SMS) text(broadcastId string) string {
+	if broadcast := sms.find(broadcastId); broadcast != nil {
+		return broadcast.Text
+	}
+	return ""
+}
+
+func (sms *SMS) find(id string) *Broadcast {
+	return sms.broadcasts.Get(id)
+}
+
+// Deliveries lists the per recipient state of a broadcast.
+func (sms *SMS) Deliveries(broadcastId string) ([]Delivery, error) {
+	found, err := sms.deliveries.Find(map[string]interface{}{"BroadcastId": broadcastId})
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make([]Delivery, 0, len(found))
+	for _, delivery := range found {
+		deliveries = append(deliveries, *delivery)
+	}
+	return deliveries, nil
+}
+
+// report records a delivery report from the gateway against the text it
+// refers to.
+func (sms *SMS) report(reference, status string) error {
+	switch strings.ToLower(status) {
+	case "success", "delivered":
+		status = Delivered
+	default:
+		status = Failed
+	}
+	if len(reference) == 0 {
+		return response.Errorf(http.StatusBadRequest, "the report names no text")
+	}
+	set := map[string]interface{}{"Status": status, "Updated": time.Now().Format(time.RFC3339Nano)}
+	matched, err := sms.deliveries.UpdateWhere(map[string]interface{}{"Reference": reference}, set)
+	if err != nil {
+		return fmt.Errorf("error recording delivery report %s", err)
+	}
+	if matched == 0 {
+		return response.Errorf(http.StatusNotFound, "no text with reference %s", reference)
+	}
+	return nil
+}
+
+func (sms *SMS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.URL.Path, "/sms/report") {
+		// gateways call back without a session, so they prove themselves
+		// with the shared token configured for the callback URL
+		token := os.Getenv("SMS_Report_Token")
+		if len(token) == 0 || r.URL.Query().Get("token") != token {
+			response.Failf(w, http.StatusForbidden, "the report token is wrong")
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			response.Failf(w, http.StatusBadRequest, "%s", err)
+			return
+		}
+		if err := sms.report(r.FormValue("id"), r.FormValue("status")); err != nil {
+			response.Fail(w, err)
+			return
+		}
+		return
+	} else if strings.EqualFold(r.URL.Path, "/sms") {
+		switch r.Method {
+		case http.MethodPost:
+			{
+				var newbroadcast Broadcast
+				if !response.Decode(w, r, &newbroadcast) {
+					return
+				}
+				recipients, err := sms.recipients(&newbroadcast)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				if !sms.allowed(r, &newbroadcast, recipients) {
+					response.Failf(w, http.StatusForbidden, "you may only text the districts, groups and members you lead")
+					return
+				}
+				caller, _ := sms.members.Caller(r)
+				newbroadcast.Id = uuid.NewString()
+				newbroadcast.By = caller.Id
+				newbroadcast.Created = time.Now().Format(time.RFC3339Nano)
+				u, err := sms.add(&newbroadcast, recipients)
+				if err != nil {
+					response.Fail(w, err)
+					return
+				}
+				response.OK(w, u)
+				return
+			}
+		case http.MethodGet:
+			{
+				caller, _ := sms.members.Caller(r)
+				admin := sms.members.RoleOf(caller.Email) == roles.Admin
+				if id := r.URL.Query().Get("id"); len(id) != 0 {
+					broadcast := sms.find(id)
+					if broadcast == nil || (!admin && broadcast.By != caller.Id) {
+						response.Failf(w, http.StatusNotFound, "broadcast does not exists")
+						return
+					}
+					deliveries, err := sms.Deliveries(broadcast.Id)
+					if err != nil {
+						response.Fail(w, err)
+						return
+					}
+					response.OK(w, deliveries)
+					return
+				}
+				result := make([]Summary, 0)
+				for _, broadcast := range sms.broadcasts.All() {
+					if !admin && broadcast.By != caller.Id {
+						continue
+					}
+					summary := Summary{Broadcast: *broadcast, Counts: make(map[string]int)}
+					deliveries, _ := sms.Deliveries(broadcast.Id)
+					for _, delivery := range deliveries {
+						summary.Counts[delivery.Status]++
+					}
+					result = append(result, summary)
+				}
+				sort.SliceStable(result, func(i, j int) bool { return result[i].Broadcast.Created > result[j].Broadcast.Created })
+				response.OK(w, result)
+				return
+			}
+		}
+	}
+}
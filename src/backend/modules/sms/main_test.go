@@ -0,0 +1,224 @@
+package sms
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/members"
+	"example.com/members/memberstest"
+	"example.com/roles"
+	"example.com/store"
+)
+
+// sent waits until the senders have finished with every delivery.
+func sent(t *testing.T, deliveries store.Store[Delivery]) []*Delivery {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		queued, _ := deliveries.Find(map[string]interface{}{"Status": Queued})
+		sending, _ := deliveries.Find(map[string]interface{}{"Status": Sending})
+		if len(queued)+len(sending) == 0 {
+			all, _ := deliveries.All()
+			return all
+		}
+	}
+	t.Fatal("texts are still queued")
+	return nil
+}
+
+func TestSendersTextEachRecipientOnce(t *testing.T) {
+	records := store.NewMemory[Broadcast]("Id")
+	deliveries := store.NewMemory[Delivery]("Id")
+	created := time.Now().Format(time.RFC3339Nano)
+	if err := records.Insert(&Broadcast{Id: "b1", Text: "Choir practice moved to 5pm", Audience: List, Created: created}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 30; i++ {
+		delivery := Delivery{Id: fmt.Sprint("d", i), BroadcastId: "b1", Phone: fmt.Sprintf("+2547000000%02d", i), Status: Queued, Updated: created}
+		if err := deliveries.Insert(&delivery); err != nil {
+			t.Fatal(err)
+		}
+	}
+	provider := &FakeProvider{}
+	m := memberstest.New(t)
+	// two replicas sending from the same deliveries
+	NewSMS(records, deliveries, m, memberstest.Leads{}, memberstest.Leads{}, provider, 1000)
+	NewSMS(records, deliveries, m, memberstest.Leads{}, memberstest.Leads{}, provider, 1000)
+
+	for _, delivery := range sent(t, deliveries) {
+		if delivery.Status != Sent || len(delivery.Reference) == 0 {
+			t.Errorf("delivery %+v was not sent", delivery)
+		}
+	}
+	texted := make(map[string]int)
+	for _, text := range provider.Sent() {
+		texted[text.To]++
+		if text.Body != "Choir practice moved to 5pm" {
+			t.Errorf("texted %q", text.Body)
+		}
+	}
+	for phone, times := range texted {
+		if times != 1 {
+			t.Errorf("%s was texted %d times", phone, times)
+		}
+	}
+	if len(texted) != 30 {
+		t.Fatalf("texted %d numbers; want 30", len(texted))
+	}
+}
+
+func TestNextTakesUpAbandonedTexts(t *testing.T) {
+	deliveries := store.NewMemory[Delivery]("Id")
+	now := time.Now()
+	for _, delivery := range []Delivery{
+		{Id: "abandoned", Status: Sending, Updated: now.Add(-2 * claimFor).Format(time.RFC3339Nano)},
+		{Id: "held", Status: Sending, Updated: now.Format(time.RFC3339Nano)},
+	} {
+		delivery := delivery
+		if err := deliveries.Insert(&delivery); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sms := &SMS{deliveries: deliveries}
+	if delivery, err := sms.next(); err != nil || delivery == nil || delivery.Id != "abandoned" {
+		t.Fatalf("next = %+v %v; want the text left sending past claimFor", delivery, err)
+	}
+	if delivery, err := sms.next(); err != nil || delivery != nil {
+		t.Fatalf("next = %+v %v; want nothing while another sender holds its claim", delivery, err)
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	m := memberstest.New(t,
+		members.Member{Id: "admin", Email: "admin@example.com", Role: roles.Admin},
+		members.Member{Id: "elder", Email: "elder@example.com", Role: roles.DistrictElder, District: "d1"},
+		members.Member{Id: "leader", Email: "leader@example.com", Role: roles.GroupLeader, Groups: "g1"},
+		members.Member{Id: "wanjiru", Email: "wanjiru@example.com", Role: roles.Member, District: "d1", Groups: "g1"},
+		members.Member{Id: "kamau", Email: "kamau@example.com", Role: roles.Member, District: "d2", Groups: "g2"},
+	)
+	districts, groups := memberstest.Leads{"elder": {"d1"}}, memberstest.Leads{"leader": {"g1"}}
+	m.Lead(districts, groups)
+	sms := &SMS{members: m, districts: districts, groups: groups}
+	wanjiru, _ := m.Get("wanjiru")
+	kamau, _ := m.Get("kamau")
+	sessions := make(map[string]*http.Cookie)
+	for _, caller := range []string{"admin", "elder", "leader", "wanjiru"} {
+		sessions[caller] = memberstest.Login(t, m, caller+"@example.com")
+	}
+
+	for _, test := range []struct {
+		caller     string
+		broadcast  Broadcast
+		recipients []members.Member
+		want       bool
+	}{
+		{"admin", Broadcast{Audience: District, Target: "d2"}, nil, true},
+		{"admin", Broadcast{Audience: List}, []members.Member{wanjiru, kamau}, true},
+		{"elder", Broadcast{Audience: District, Target: "d1"}, nil, true},
+		{"elder", Broadcast{Audience: District, Target: "d2"}, nil, false},
+		{"elder", Broadcast{Audience: Group, Target: "d1"}, nil, false},
+		{"elder", Broadcast{Audience: List}, []members.Member{wanjiru}, true},
+		{"elder", Broadcast{Audience: List}, []members.Member{wanjiru, kamau}, false},
+		{"leader", Broadcast{Audience: Group, Target: "g1"}, nil, true},
+		{"leader", Broadcast{Audience: Group, Target: "g2"}, nil, false},
+		{"leader", Broadcast{Audience: District, Target: "g1"}, nil, false},
+		{"leader", Broadcast{Audience: List}, []members.Member{wanjiru}, true},
+		{"leader", Broadcast{Audience: List}, []members.Member{kamau}, false},
+		{"wanjiru", Broadcast{Audience: District, Target: "d1"}, nil, false},
+		{"wanjiru", Broadcast{Audience: List}, []members.Member{wanjiru}, false},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/sms", nil)
+		r.AddCookie(sessions[test.caller])
+		if got := sms.allowed(r, &test.broadcast, test.recipients); got != test.want {
+			t.Errorf("%s sending to %s %s %d members = %v; want %v", test.caller, test.broadcast.Audience, test.broadcast.Target, len(test.recipients), got, test.want)
+		}
+	}
+	if sms.allowed(httptest.NewRequest(http.MethodPost, "/sms", nil), &Broadcast{Audience: District, Target: "d1"}, nil) {
+		t.Error("a guest may broadcast")
+	}
+}
+
+func TestRecipientsAreActiveAndTextedOnce(t *testing.T) {
+	m := memberstest.New(t,
+		members.Member{Id: "admin", Email: "admin@example.com", Role: roles.Admin},
+		members.Member{Id: "wanjiru", Email: "wanjiru@example.com", Role: roles.Member, District: "d1", Contacts: "0712345678"},
+		members.Member{Id: "kamau", Email: "kamau@example.com", Role: roles.Member, District: "d1", Contacts: "0733000111"},
+		members.Member{Id: "njeri", Email: "njeri@example.com", Role: roles.Member, District: "d1", Contacts: "0722000222"},
+	)
+	admin := memberstest.Login(t, m, "admin@example.com")
+	memberstest.Request(t, m, admin, http.MethodPut, "/member", `{"Id":"kamau","Email":"kamau@example.com","Deactivated":"2030-01-01T00:00:00Z"}`, nil)
+	memberstest.Request(t, m, admin, http.MethodPut, "/member", `{"Id":"njeri","Email":"njeri@example.com","Active":false}`, nil)
+	deliveries := store.NewMemory[Delivery]("Id")
+	sms := NewSMS(store.NewMemory[Broadcast]("Id"), deliveries, m, memberstest.Leads{}, memberstest.Leads{}, &FakeProvider{}, 1000)
+
+	for _, body := range []string{
+		`{"Text":"Harambee on Sunday","Audience":"district","Target":"d1"}`,
+		`{"Text":"Harambee on Sunday","Audience":"list","Target":"wanjiru;WANJIRU;kamau;njeri"}`,
+	} {
+		var broadcast Broadcast
+		if code := memberstest.Request(t, sms, admin, http.MethodPost, "/sms", body, &broadcast); code != http.StatusOK {
+			t.Fatalf("POST /sms %s = %d", body, code)
+		}
+		queued, _ := sms.Deliveries(broadcast.Id)
+		if len(queued) != 1 || queued[0].MemberId != "wanjiru" {
+			t.Errorf("POST /sms %s queued %+v; want one delivery to wanjiru", body, queued)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	t.Setenv("SMS_Report_Token", "gateway-secret")
+	m := memberstest.New(t,
+		members.Member{Id: "wanjiru", Email: "wanjiru@example.com", Role: roles.Member, Contacts: "0712345678"},
+		members.Member{Id: "kamau", Email: "kamau@example.com", Role: roles.Member, Contacts: "0733000111"},
+	)
+	deliveries := store.NewMemory[Delivery]("Id")
+	sms := NewSMS(store.NewMemory[Broadcast]("Id"), deliveries, m, memberstest.Leads{}, memberstest.Leads{}, &FakeProvider{}, 1000)
+	recipients := m.List()
+	if _, err := sms.add(&Broadcast{Id: "b1", Text: "Harambee on Sunday", Audience: District, Created: time.Now().Format(time.RFC3339Nano)}, recipients); err != nil {
+		t.Fatal(err)
+	}
+	reference := make(map[string]string)
+	for _, delivery := range sent(t, deliveries) {
+		reference[delivery.MemberId] = delivery.Reference
+	}
+
+	report := func(token, id, status string) int {
+		form := url.Values{"id": {id}, "status": {status}}
+		r := httptest.NewRequest(http.MethodPost, "/sms/report?token="+token, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		sms.ServeHTTP(rec, r)
+		return rec.Code
+	}
+	if code := report("wrong", reference["wanjiru"], "Success"); code != http.StatusForbidden {
+		t.Fatalf("report with a wrong token = %d; want 403", code)
+	}
+	if code := report("", reference["wanjiru"], "Success"); code != http.StatusForbidden {
+		t.Fatalf("report without a token = %d; want 403", code)
+	}
+	if code := report("gateway-secret", reference["wanjiru"], "Success"); code != http.StatusOK {
+		t.Fatalf("report = %d", code)
+	}
+	if code := report("gateway-secret", reference["kamau"], "Rejected"); code != http.StatusOK {
+		t.Fatalf("report = %d", code)
+	}
+	if code := report("gateway-secret", "unknown", "Success"); code != http.StatusNotFound {
+		t.Fatalf("report for an unknown text = %d; want 404", code)
+	}
+	if code := report("gateway-secret", "", "Success"); code != http.StatusBadRequest {
+		t.Fatalf("report naming no text = %d; want 400", code)
+	}
+
+	want := map[string]string{"wanjiru": Delivered, "kamau": Failed}
+	all, _ := sms.Deliveries("b1")
+	for _, delivery := range all {
+		if delivery.Status != want[delivery.MemberId] {
+			t.Errorf("%s delivery is %s; want %s", delivery.MemberId, delivery.Status, want[delivery.MemberId])
+		}
+	}
+}
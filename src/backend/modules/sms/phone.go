@@ -0,0 +1,58 @@
+package sms
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultCountry is the calling code assumed for numbers written without
+// one.
+const DefaultCountry = "254"
+
+// Normalize turns a phone number as people write it into E.164, reading
+// local numbers as belonging to country. "0712 345 678", "712345678",
+// "254712345678" and "+254-712-345678" all become "+254712345678". Numbers
+// of other countries need a leading + or 00.
+func Normalize(number, country string) (string, error) {
+	digits := strings.Map(func(r rune) rune {
+		switch {
+		case r >= '0' && r <= '9':
+			return r
+		case r == '+':
+			return r
+		}
+		return -1
+	}, number)
+	switch {
+	case strings.HasPrefix(digits, "+"):
+		digits = digits[1:]
+	case strings.HasPrefix(digits, "00"):
+		digits = digits[2:]
+	case strings.HasPrefix(digits, "0"):
+		digits = country + digits[1:]
+	case strings.HasPrefix(digits, country):
+	case len(digits) == 9:
+		digits = country + digits
+	default:
+		// neither local nor marked international
+		return "", fmt.Errorf("%q is not a phone number", number)
+	}
+	if strings.Contains(digits, "+") || len(digits) < 8 || len(digits) > 15 {
+		return "", fmt.Errorf("%q is not a phone number", number)
+	}
+	if strings.HasPrefix(digits, country) && country == DefaultCountry && len(digits) != 12 {
+		return "", fmt.Errorf("%q is not a Kenyan phone number", number)
+	}
+	return "+" + digits, nil
+}
+
+// FirstNumber picks the first usable number out of a member's Contacts,
+// which may list several separated by semicolons, commas or slashes.
+func FirstNumber(contacts, country string) (string, error) {
+	for _, part := range strings.FieldsFunc(contacts, func(r rune) bool { return r == ';' || r == ',' || r == '/' }) {
+		if number, err := Normalize(part, country); err == nil {
+			return number, nil
+		}
+	}
+	return "", fmt.Errorf("no usable phone number in %q", contacts)
+}
@@ -0,0 +1,48 @@
+package sms
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	for _, test := range []struct {
+		number, want string
+	}{
+		{"0712 345 678", "+254712345678"},
+		{"712345678", "+254712345678"},
+		{"254712345678", "+254712345678"},
+		{"+254-712-345678", "+254712345678"},
+		{"0110 123 456", "+254110123456"},
+		{"+44 20 7946 0958", "+442079460958"},
+		{"0044 20 7946 0958", "+442079460958"},
+		{"12345678", ""},
+		{"0712 345", ""},
+		{"0712 345 6789", ""},
+		{"+254 712 345 67", ""},
+		{"+1 234", ""},
+		{"+1234567890123456", ""},
+		{"0712+345678", ""},
+		{"", ""},
+	} {
+		got, err := Normalize(test.number, DefaultCountry)
+		if got != test.want || (err == nil) != (len(test.want) != 0) {
+			t.Errorf("Normalize(%q) = %q, %v; want %q", test.number, got, err, test.want)
+		}
+	}
+}
+
+func TestFirstNumber(t *testing.T) {
+	for _, test := range []struct {
+		contacts, want string
+	}{
+		{"0712345678", "+254712345678"},
+		{"office; 0712345678", "+254712345678"},
+		{"0712 345 678, 0733 000 111", "+254712345678"},
+		{"12345 / 0733 000 111", "+254733000111"},
+		{"none", ""},
+		{"", ""},
+	} {
+		got, err := FirstNumber(test.contacts, DefaultCountry)
+		if got != test.want || (err == nil) != (len(test.want) != 0) {
+			t.Errorf("FirstNumber(%q) = %q, %v; want %q", test.contacts, got, err, test.want)
+		}
+	}
+}
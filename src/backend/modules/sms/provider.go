@@ -0,0 +1,95 @@
+package sms
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Provider hands one text to an SMS network and returns the provider's
+// reference for it, used to match later delivery reports.
+type Provider interface {
+	Send(to, text string) (string, error)
+}
+
+// HTTPGateway posts each text as a form to an SMS gateway, in the style of
+// the common Kenyan bulk SMS APIs.
+type HTTPGateway struct {
+	URL      string
+	Username string
+	APIKey   string
+	From     string
+	Client   *http.Client
+}
+
+func (gateway *HTTPGateway) Send(to, text string) (string, error) {
+	form := url.Values{"username": {gateway.Username}, "to": {to}, "message": {text}}
+	if len(gateway.From) != 0 {
+		form.Set("from", gateway.From)
+	}
+	req, err := http.NewRequest(http.MethodPost, gateway.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("apiKey", gateway.APIKey)
+	client := gateway.Client
+	if client == nil {
+		client = &http.Client{Timeout: 15 * time.Second}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error reaching sms gateway %s", err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode/100 != 2 {
+		return "", fmt.Errorf("sms gateway responded %s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+	var reply struct {
+		Id        string `json:"id"`
+		MessageId string `json:"messageId"`
+	}
+	json.Unmarshal(body, &reply)
+	if len(reply.MessageId) != 0 {
+		return reply.MessageId, nil
+	}
+	return reply.Id, nil
+}
+
+// FakeProvider pretends to send, logging each text and keeping it in
+// memory. It stands in for a gateway during development and tests.
+type FakeProvider struct {
+	mu   sync.Mutex
+	sent []Text
+}
+
+// Text is an SMS kept by a FakeProvider.
+type Text struct {
+	To   string
+	Body string
+}
+
+func (fake *FakeProvider) Send(to, text string) (string, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.sent = append(fake.sent, Text{To: to, Body: text})
+	log.Printf("sms to %s: %s", to, text)
+	return uuid.NewString(), nil
+}
+
+// Sent returns a copy of the texts sent so far.
+func (fake *FakeProvider) Sent() []Text {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	return append([]Text(nil), fake.sent...)
+}
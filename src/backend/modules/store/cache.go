@@ -0,0 +1,114 @@
+package store
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Cache is an in-process copy of a store's records. Reads take the current
+// snapshot without locking; writes build a new slice and swap it in, so a
+// snapshot being ranged over is never changed underneath its reader.
+// Records in a snapshot are shared and must not be modified: to change one,
+// copy it and Put the copy.
+type Cache[T any] struct {
+	mutex   sync.Mutex
+	records atomic.Pointer[[]*T]
+	key     func(*T) string
+}
+
+// NewCache holds records, identified by key. Keys compare ignoring case,
+// as the modules compare Ids.
+func NewCache[T any](records []*T, key func(*T) string) *Cache[T] {
+	cache := &Cache[T]{key: key}
+	cache.records.Store(&records)
+	return cache
+}
+
+// All returns the current snapshot.
+func (cache *Cache[T]) All() []*T {
+	return *cache.records.Load()
+}
+
+// Get returns the record with key or nil.
+func (cache *Cache[T]) Get(key string) *T {
+	for _, record := range cache.All() {
+		if strings.EqualFold(cache.key(record), key) {
+			return record
+		}
+	}
+	return nil
+}
+
+// Put adds record, replacing the one with the same key if there is one.
+func (cache *Cache[T]) Put(record *T) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	current := cache.All()
+	records := make([]*T, 0, len(current)+1)
+	replaced := false
+	for _, existing := range current {
+		if strings.EqualFold(cache.key(existing), cache.key(record)) {
+			existing, replaced = record, true
+		}
+		records = append(records, existing)
+	}
+	if !replaced {
+		records = append(records, record)
+	}
+	cache.records.Store(&records)
+}
+
+// Remove drops the record with key.
+func (cache *Cache[T]) Remove(key string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	current := cache.All()
+	records := make([]*T, 0, len(current))
+	for _, existing := range current {
+		if !strings.EqualFold(cache.key(existing), key) {
+			records = append(records, existing)
+		}
+	}
+	cache.records.Store(&records)
+}
+
+// Reload replaces every record, as read back from the store.
+func (cache *Cache[T]) Reload(records []*T) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.records.Store(&records)
+}
+
+// Follow reloads the cache from store whenever the store reports a change
+// made elsewhere, such as an edit in the Mongo shell or by another replica
+// of the backend. Stores that cannot watch are left as they are. Bursts of
+// changes are coalesced into one reload.
+func (cache *Cache[T]) Follow(name string, store Store[T]) {
+	watcher, ok := store.(Watcher)
+	if !ok {
+		return
+	}
+	kick := make(chan struct{}, 1)
+	err := watcher.Watch(func() {
+		select {
+		case kick <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		log.Printf("%s: not following changes made elsewhere: %s", name, err)
+		return
+	}
+	go func() {
+		for range kick {
+			records, err := store.All()
+			if err != nil {
+				log.Printf("%s: error reloading %s", name, err)
+				continue
+			}
+			cache.Reload(records)
+		}
+	}()
+}
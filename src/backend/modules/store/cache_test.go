@@ -0,0 +1,38 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestCacheConcurrentWrites(t *testing.T) {
+	cache := NewCache([]*record{}, func(r *record) string { return r.Id })
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				id := fmt.Sprintf("%d-%d", i, n)
+				cache.Put(&record{Id: id, Count: n})
+				if n%2 == 1 {
+					cache.Remove(id)
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				for _, r := range cache.All() {
+					_ = r.Count
+				}
+				cache.Get("0-0")
+			}
+		}()
+	}
+	wg.Wait()
+	if all := cache.All(); len(all) != 8*50 {
+		t.Fatalf("cache holds %d records; want %d", len(all), 8*50)
+	}
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Counter hands out numbers in sequence per name, such as the entries of a
+// register.
+type Counter interface {
+	Next(name string) (int, error)
+}
+
+// MongoCounter keeps its sequences in a Mongo collection, so numbers stay
+// in order across restarts and replicas.
+type MongoCounter struct {
+	col *mongo.Collection
+}
+
+func NewMongoCounter(db *mongo.Database, collection string) *MongoCounter {
+	return &MongoCounter{col: db.Collection(collection)}
+}
+
+func (counter *MongoCounter) Next(name string) (int, error) {
+	var sequence struct {
+		Seq int `bson:"Seq"`
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := counter.col.FindOneAndUpdate(context.TODO(), bson.M{"Name": name}, bson.M{"$inc": bson.M{"Seq": 1}}, opts).Decode(&sequence)
+	return sequence.Seq, err
+}
+
+// MemoryCounter is a Counter kept in process.
+type MemoryCounter struct {
+	mutex     sync.Mutex
+	sequences map[string]int
+}
+
+func NewMemoryCounter() *MemoryCounter {
+	return &MemoryCounter{sequences: make(map[string]int)}
+}
+
+func (counter *MemoryCounter) Next(name string) (int, error) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	counter.sequences[name]++
+	return counter.sequences[name], nil
+}
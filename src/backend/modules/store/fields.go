@@ -0,0 +1,96 @@
+package store
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+
+	"example.com/response"
+)
+
+// Fields reads the fields parameter of a request, a comma separated list
+// such as ?fields=Id,Name. No list means every field.
+func Fields(values url.Values) []string {
+	fields := make([]string, 0)
+	for _, field := range strings.Split(values.Get("fields"), ",") {
+		if field = strings.TrimSpace(field); len(field) != 0 {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// Select narrows a view to the named fields. Only fields of the view can be
+// asked for, so a selection never reaches past what the caller may see.
+func Select[T any](view T, fields []string) (interface{}, error) {
+	if len(fields) == 0 {
+		return view, nil
+	}
+	value := reflect.ValueOf(view)
+	result := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		found, ok := value.Type().FieldByNameFunc(func(name string) bool { return strings.EqualFold(name, field) })
+		if !ok || !found.IsExported() {
+			return nil, fmt.Errorf("unknown field %s", field)
+		}
+		result[found.Name] = value.FieldByIndex(found.Index).Interface()
+	}
+	return result, nil
+}
+
+// SelectPage narrows every record on a page to the named fields.
+func SelectPage[T any](page Page[T], fields []string) (interface{}, error) {
+	if len(fields) == 0 {
+		return page, nil
+	}
+	result := Page[interface{}]{Items: make([]interface{}, 0, len(page.Items)), Total: page.Total, Page: page.Page, Limit: page.Limit}
+	for _, item := range page.Items {
+		selected, err := Select(item, fields)
+		if err != nil {
+			return nil, err
+		}
+		result.Items = append(result.Items, selected)
+	}
+	return result, nil
+}
+
+// Convert turns every record on a page into another type, such as the
+// view of it a caller may see.
+func Convert[T, V any](page Page[T], convert func(T) V) Page[V] {
+	result := Page[V]{Items: make([]V, 0, len(page.Items)), Total: page.Total, Page: page.Page, Limit: page.Limit}
+	for _, item := range page.Items {
+		result.Items = append(result.Items, convert(item))
+	}
+	return result
+}
+
+// Apply sets the fields of record named in update, a PUT body decoded into
+// a map, and returns what it set for Update. Keys that are not settable
+// fields of record, or are in skip, are left alone. JSON numbers set int
+// fields; a value of any other type, null included, is a 400 naming the
+// field.
+func Apply[T any](record *T, update map[string]interface{}, skip ...string) (map[string]interface{}, error) {
+	value := reflect.ValueOf(record).Elem()
+	set := map[string]interface{}{}
+	for key, given := range update {
+		field := value.FieldByName(key)
+		if !field.IsValid() || !field.CanSet() || slices.Contains(skip, key) {
+			continue
+		}
+		val := reflect.ValueOf(given)
+		switch {
+		case !val.IsValid():
+			return nil, response.Invalid(fmt.Sprintf("field %s cannot be null", key), map[string]string{key: "must not be null"})
+		case field.Type() == val.Type():
+			field.Set(val)
+		case field.Kind() == reflect.Int && val.Kind() == reflect.Float64:
+			field.SetInt(int64(given.(float64)))
+		default:
+			return nil, response.Invalid(fmt.Sprintf("type mismatch for field %s", key), map[string]string{key: "wrong type"})
+		}
+		set[key] = field.Interface()
+	}
+	return set, nil
+}
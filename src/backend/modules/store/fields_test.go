@@ -0,0 +1,36 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"example.com/response"
+)
+
+func TestApply(t *testing.T) {
+	var update map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"Id":"x","Name":"Amos","Count":3,"Unknown":1}`), &update); err != nil {
+		t.Fatal(err)
+	}
+	saved := record{Id: "a", Name: "Wanjiru"}
+	set, err := Apply(&saved, update, "Id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Id != "a" || saved.Name != "Amos" || saved.Count != 3 || len(set) != 2 || set["Count"] != 3 {
+		t.Fatalf("Apply set %+v, record %+v", set, saved)
+	}
+
+	for _, body := range []string{`{"Name":null}`, `{"Name":7}`, `{"Active":"yes"}`} {
+		update = nil
+		if err := json.Unmarshal([]byte(body), &update); err != nil {
+			t.Fatal(err)
+		}
+		var answer *response.Error
+		if _, err := Apply(&saved, update); !errors.As(err, &answer) || answer.Status != http.StatusBadRequest || len(answer.Fields) != 1 {
+			t.Errorf("Apply %s = %v; want a 400 naming the field", body, err)
+		}
+	}
+}
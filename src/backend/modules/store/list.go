@@ -0,0 +1,124 @@
+package store
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Paging limits for list endpoints. A list asked for by page without a
+// limit gets DefaultLimit records; no page holds more than MaxLimit.
+const (
+	DefaultLimit = 25
+	MaxLimit     = 500
+)
+
+// Page is what list endpoints answer with: the records on the page asked
+// for and how many matched the filters in all.
+type Page[T any] struct {
+	Items []T
+	Total int
+	Page  int
+	Limit int
+}
+
+// Query is the part of a list request shared by every list endpoint:
+//
+//	?q=text&sort=Name&page=2&limit=25
+//
+// A sort field prefixed with - sorts descending. Without page or limit the
+// whole list comes back on one page, as pickers need it. Any other
+// parameters are filters for the module to apply.
+type Query struct {
+	Search string
+	Sort   string
+	Desc   bool
+	Page   int
+	Limit  int
+}
+
+// ParseQuery reads a Query from values, accepting only the sortable fields.
+func ParseQuery(values url.Values, sortable ...string) (Query, error) {
+	query := Query{Search: strings.TrimSpace(values.Get("q")), Page: 1}
+	if field := values.Get("sort"); len(field) != 0 {
+		query.Desc = strings.HasPrefix(field, "-")
+		field = strings.TrimPrefix(field, "-")
+		for _, s := range sortable {
+			if strings.EqualFold(s, field) {
+				query.Sort = s
+			}
+		}
+		if len(query.Sort) == 0 {
+			return query, fmt.Errorf("sort must be one of %s", strings.Join(sortable, ", "))
+		}
+	}
+	if page := values.Get("page"); len(page) != 0 {
+		n, err := strconv.Atoi(page)
+		if err != nil || n < 1 {
+			return query, fmt.Errorf("page must be a number from 1")
+		}
+		query.Page, query.Limit = n, DefaultLimit
+	}
+	if limit := values.Get("limit"); len(limit) != 0 {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return query, fmt.Errorf("limit must be a number from 1")
+		}
+		query.Limit = min(n, MaxLimit)
+	}
+	return query, nil
+}
+
+// Matches reports whether the search text is found in any of fields,
+// ignoring case. An empty search matches everything.
+func (query Query) Matches(fields ...string) bool {
+	if len(query.Search) == 0 {
+		return true
+	}
+	search := strings.ToLower(query.Search)
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), search) {
+			return true
+		}
+	}
+	return false
+}
+
+// Paginate sorts records as asked and cuts out the page. Records keep their
+// order when no sort was asked for.
+func Paginate[T any](records []T, query Query) Page[T] {
+	if len(query.Sort) != 0 {
+		sort.SliceStable(records, func(i, j int) bool {
+			a := reflect.ValueOf(records[i]).FieldByName(query.Sort)
+			b := reflect.ValueOf(records[j]).FieldByName(query.Sort)
+			if query.Desc {
+				return less(b, a)
+			}
+			return less(a, b)
+		})
+	}
+	page := Page[T]{Items: records, Total: len(records), Page: query.Page, Limit: query.Limit}
+	if query.Limit != 0 {
+		// compare before multiplying, a huge page number would overflow
+		start := len(records)
+		if query.Page-1 < len(records)/query.Limit+1 {
+			start = min((query.Page-1)*query.Limit, len(records))
+		}
+		page.Items = records[start:min(start+query.Limit, len(records))]
+	}
+	return page
+}
+
+func less(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Bool:
+		return !a.Bool() && b.Bool()
+	default:
+		return strings.ToLower(a.String()) < strings.ToLower(b.String())
+	}
+}
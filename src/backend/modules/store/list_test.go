@@ -0,0 +1,35 @@
+package store
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestPaginate(t *testing.T) {
+	records := []record{{Id: "c", Count: 3}, {Id: "a", Count: 1}, {Id: "b", Count: 2}}
+	for _, test := range []struct {
+		page, limit string
+		want        []string
+	}{
+		{"1", "2", []string{"a", "b"}},
+		{"2", "2", []string{"c"}},
+		{"3", "2", nil},
+		{strconv.Itoa(int(^uint(0) >> 1)), "500", nil},
+		{strconv.Itoa(int(^uint(0)>>1) / 2), "3", nil},
+	} {
+		query, err := ParseQuery(url.Values{"page": {test.page}, "limit": {test.limit}, "sort": {"Count"}}, "Count")
+		if err != nil {
+			t.Fatal(err)
+		}
+		page := Paginate(append([]record(nil), records...), query)
+		if page.Total != 3 || len(page.Items) != len(test.want) {
+			t.Fatalf("page %s of %s = %+v; want %v", test.page, test.limit, page, test.want)
+		}
+		for i, id := range test.want {
+			if page.Items[i].Id != id {
+				t.Fatalf("page %s of %s = %+v; want %v", test.page, test.limit, page.Items, test.want)
+			}
+		}
+	}
+}
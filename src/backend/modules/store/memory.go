@@ -0,0 +1,169 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Memory is a Store kept in process, for running modules without a
+// database. Records go through bson on the way in and out, so they are
+// copied and compared the way Mongo would see them.
+type Memory[T any] struct {
+	mutex   sync.Mutex
+	key     string
+	records []bson.M
+}
+
+func NewMemory[T any](key string) *Memory[T] {
+	return &Memory[T]{key: key, records: make([]bson.M, 0)}
+}
+
+// document converts v to the bson.M Mongo would store for it.
+func document(v interface{}) (bson.M, error) {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.M{}
+	err = bson.Unmarshal(data, &doc)
+	return doc, err
+}
+
+func decode[T any](doc bson.M) (*T, error) {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	record := new(T)
+	err = bson.Unmarshal(data, record)
+	return record, err
+}
+
+// matches reports whether doc has every value in match.
+func matches(doc, match bson.M) bool {
+	for field, value := range match {
+		if !reflect.DeepEqual(doc[field], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// where returns the indexes of the records matching match.
+func (store *Memory[T]) where(match map[string]interface{}) ([]int, error) {
+	wanted := bson.M{}
+	if match != nil {
+		var err error
+		if wanted, err = document(match); err != nil {
+			return nil, err
+		}
+	}
+	found := make([]int, 0)
+	for i, doc := range store.records {
+		if matches(doc, wanted) {
+			found = append(found, i)
+		}
+	}
+	return found, nil
+}
+
+func (store *Memory[T]) All() ([]*T, error) {
+	return store.Find(nil)
+}
+
+func (store *Memory[T]) Find(match map[string]interface{}) ([]*T, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	found, err := store.where(match)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*T, 0, len(found))
+	for _, i := range found {
+		record, err := decode[T](store.records[i])
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (store *Memory[T]) Insert(record *T) error {
+	doc, err := document(record)
+	if err != nil {
+		return err
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.key == "_id" {
+		if found, _ := store.where(map[string]interface{}{"_id": doc["_id"]}); len(found) != 0 {
+			return fmt.Errorf("%w %v", ErrDuplicate, doc["_id"])
+		}
+	}
+	store.records = append(store.records, doc)
+	return nil
+}
+
+func (store *Memory[T]) Update(key string, set map[string]interface{}) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	found, err := store.where(map[string]interface{}{store.key: key})
+	if err != nil || len(found) == 0 {
+		return err
+	}
+	return store.set(found[:1], set)
+}
+
+func (store *Memory[T]) UpdateWhere(match, set map[string]interface{}) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	found, err := store.where(match)
+	if err != nil {
+		return 0, err
+	}
+	return len(found), store.set(found, set)
+}
+
+// set writes the fields in set to the records at the given indexes.
+func (store *Memory[T]) set(indexes []int, set map[string]interface{}) error {
+	values, err := document(set)
+	if err != nil {
+		return err
+	}
+	for _, i := range indexes {
+		for field, value := range values {
+			store.records[i][field] = value
+		}
+	}
+	return nil
+}
+
+func (store *Memory[T]) Delete(key string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	found, err := store.where(map[string]interface{}{store.key: key})
+	if err != nil || len(found) == 0 {
+		return err
+	}
+	store.records = append(store.records[:found[0]], store.records[found[0]+1:]...)
+	return nil
+}
+
+func (store *Memory[T]) Default(field string, value interface{}) error {
+	values, err := document(map[string]interface{}{field: value})
+	if err != nil {
+		return err
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for _, doc := range store.records {
+		if _, ok := doc[field]; !ok {
+			doc[field] = values[field]
+		}
+	}
+	return nil
+}
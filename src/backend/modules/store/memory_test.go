@@ -0,0 +1,95 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+type record struct {
+	Id     string `bson:"Id"`
+	Name   string `bson:"Name"`
+	Count  int    `bson:"Count"`
+	Active bool   `bson:"Active"`
+}
+
+func TestMemoryFindUpdateDelete(t *testing.T) {
+	memory := NewMemory[record]("Id")
+	for _, r := range []record{{Id: "a", Name: "Amos", Count: 1}, {Id: "b", Name: "Beth", Count: 2}, {Id: "c", Name: "Amos", Count: 3}} {
+		r := r
+		if err := memory.Insert(&r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	found, err := memory.Find(map[string]interface{}{"Name": "Amos"})
+	if err != nil || len(found) != 2 {
+		t.Fatalf("Find by name = %d records, %v; want 2", len(found), err)
+	}
+	found[0].Name = "changed"
+	if again, _ := memory.Find(map[string]interface{}{"Id": "a"}); again[0].Name != "Amos" {
+		t.Fatalf("records handed out are copies, got %q back", again[0].Name)
+	}
+	if err := memory.Update("b", map[string]interface{}{"Count": 5}); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := memory.Find(map[string]interface{}{"Count": 5}); len(found) != 1 || found[0].Id != "b" {
+		t.Fatalf("Update did not set Count on b: %+v", found)
+	}
+	matched, err := memory.UpdateWhere(map[string]interface{}{"Name": "Amos", "Count": 3}, map[string]interface{}{"Active": true})
+	if err != nil || matched != 1 {
+		t.Fatalf("UpdateWhere matched %d, %v; want 1", matched, err)
+	}
+	if matched, _ := memory.UpdateWhere(map[string]interface{}{"Name": "Amos", "Count": 3, "Active": false}, map[string]interface{}{"Active": true}); matched != 0 {
+		t.Fatalf("UpdateWhere on a changed record matched %d; want 0", matched)
+	}
+	if err := memory.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if all, _ := memory.All(); len(all) != 2 {
+		t.Fatalf("All after Delete = %d records; want 2", len(all))
+	}
+}
+
+func TestMemoryDuplicateId(t *testing.T) {
+	type claim struct {
+		Key string `bson:"_id"`
+	}
+	memory := NewMemory[claim]("_id")
+	if err := memory.Insert(&claim{Key: "job/2024-01-01"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := memory.Insert(&claim{Key: "job/2024-01-01"}); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("second Insert = %v; want ErrDuplicate", err)
+	}
+}
+
+func TestMemoryDefault(t *testing.T) {
+	type old struct {
+		Id string `bson:"Id"`
+	}
+	memory := NewMemory[record]("Id")
+	// a record saved before Active existed
+	doc, _ := document(old{Id: "a"})
+	memory.records = append(memory.records, doc)
+	if err := memory.Insert(&record{Id: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := memory.Default("Active", true); err != nil {
+		t.Fatal(err)
+	}
+	active, _ := memory.Find(map[string]interface{}{"Active": true})
+	if len(active) != 1 || active[0].Id != "a" {
+		t.Fatalf("Default set Active on %+v; want only a", active)
+	}
+}
+
+func TestMemoryCounter(t *testing.T) {
+	counter := NewMemoryCounter()
+	for want := 1; want <= 3; want++ {
+		if got, _ := counter.Next("sacrament.baptism"); got != want {
+			t.Fatalf("Next = %d; want %d", got, want)
+		}
+	}
+	if got, _ := counter.Next("sacrament.burial"); got != 1 {
+		t.Fatalf("Next on another name = %d; want 1", got)
+	}
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// watchRetry is how long to wait before reopening a broken change stream.
+const watchRetry = 5 * time.Second
+
+// Mongo is a Store backed by a Mongo collection.
+type Mongo[T any] struct {
+	col *mongo.Collection
+	key string
+}
+
+func NewMongo[T any](db *mongo.Database, collection, key string) *Mongo[T] {
+	return &Mongo[T]{col: db.Collection(collection), key: key}
+}
+
+func (store *Mongo[T]) All() ([]*T, error) {
+	return store.Find(nil)
+}
+
+func (store *Mongo[T]) Find(match map[string]interface{}) ([]*T, error) {
+	records := make([]*T, 0)
+	result, err := store.col.Find(context.TODO(), filter(match))
+	if err != nil {
+		return nil, err
+	}
+	if err = result.All(context.TODO(), &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (store *Mongo[T]) Insert(record *T) error {
+	bsonData, err := bson.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = store.col.InsertOne(context.TODO(), bsonData)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (store *Mongo[T]) Update(key string, set map[string]interface{}) error {
+	_, err := store.col.UpdateOne(context.TODO(), bson.M{store.key: key}, bson.M{"$set": set})
+	return err
+}
+
+func (store *Mongo[T]) UpdateWhere(match, set map[string]interface{}) (int, error) {
+	result, err := store.col.UpdateMany(context.TODO(), filter(match), bson.M{"$set": set})
+	if err != nil {
+		return 0, err
+	}
+	return int(result.MatchedCount), nil
+}
+
+func (store *Mongo[T]) Delete(key string) error {
+	_, err := store.col.DeleteOne(context.TODO(), bson.M{store.key: key})
+	return err
+}
+
+func (store *Mongo[T]) Default(field string, value interface{}) error {
+	_, err := store.col.UpdateMany(context.TODO(), bson.M{field: bson.M{"$exists": false}}, bson.M{"$set": bson.M{field: value}})
+	return err
+}
+
+// Watch follows the collection's change stream. Change streams need a
+// replica set, so on a standalone server this fails and nothing is watched.
+// A stream that breaks later, for example when the primary steps down, is
+// reopened after the last event seen.
+func (store *Mongo[T]) Watch(changed func()) error {
+	stream, err := store.col.Watch(context.TODO(), mongo.Pipeline{})
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			for stream.Next(context.TODO()) {
+				changed()
+			}
+			resume := stream.ResumeToken()
+			log.Printf("change stream on %s ended %v", store.col.Name(), stream.Err())
+			stream.Close(context.TODO())
+			for {
+				time.Sleep(watchRetry)
+				opts := options.ChangeStream()
+				if resume != nil {
+					opts.SetResumeAfter(resume)
+				}
+				if stream, err = store.col.Watch(context.TODO(), mongo.Pipeline{}, opts); err == nil {
+					break
+				}
+				// the resume point may have left the oplog; the reload below
+				// catches up without it
+				resume = nil
+			}
+			// events may have been missed while the stream was down
+			changed()
+		}
+	}()
+	return nil
+}
+
+func filter(match map[string]interface{}) bson.M {
+	if match == nil {
+		return bson.M{}
+	}
+	return bson.M(match)
+}
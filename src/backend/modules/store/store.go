@@ -0,0 +1,33 @@
+package store
+
+import "errors"
+
+// ErrDuplicate is returned by Insert when a record with the same key is
+// already stored. Stores keyed on _id enforce it, as Mongo does.
+var ErrDuplicate = errors.New("duplicate key")
+
+// Store persists records of one kind, addressed by a key field. Field names
+// in matches and updates are the bson names, as stored in Mongo.
+type Store[T any] interface {
+	// All returns every record.
+	All() ([]*T, error)
+	// Find returns the records whose fields equal every value in match.
+	Find(match map[string]interface{}) ([]*T, error)
+	Insert(record *T) error
+	// Update sets fields on the record with the given key.
+	Update(key string, set map[string]interface{}) error
+	// UpdateWhere sets fields on every record matching match and reports
+	// how many matched. A single record is matched and updated atomically,
+	// so it can claim a record by matching on its current state.
+	UpdateWhere(match, set map[string]interface{}) (int, error)
+	Delete(key string) error
+	// Default sets field on records saved before the field existed.
+	Default(field string, value interface{}) error
+}
+
+// Watcher is a Store that can report changes, including those made by
+// other processes. Watch calls changed after each one until the process
+// ends.
+type Watcher interface {
+	Watch(changed func()) error
+}
@@ -3,8 +3,10 @@ module example.com/users
 go 1.21.3
 
 require (
+	example.com/response v0.0.0-00010101000000-000000000000
+	example.com/store v0.0.0-00010101000000-000000000000
 	github.com/astaxie/beego v1.12.3
-	go.mongodb.org/mongo-driver v1.17.2
+	github.com/google/uuid v1.6.0
 	golang.org/x/crypto v0.32.0
 )
 
@@ -16,6 +18,12 @@ require (
 	github.com/xdg-go/scram v1.1.2 // indirect
 	github.com/xdg-go/stringprep v1.0.4 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20240726163527-a2c0da244d78 // indirect
+	go.mongodb.org/mongo-driver v1.17.3 // indirect
 	golang.org/x/sync v0.10.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
 )
+
+replace (
+	example.com/response => ../response
+	example.com/store => ../store
+)
@@ -1,23 +1,23 @@
 package users
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"net/mail"
 	"os"
-	"reflect"
 	"strings"
+	"sync"
 
+	"example.com/response"
+	"example.com/store"
 	"github.com/astaxie/beego/session"
 	"github.com/google/uuid"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
-const userCollection = "user"
+// UserCollection is where the Mongo store keeps visitor accounts.
+const UserCollection = "user"
 
 type User struct {
 	Id       string `bson:"Id"`
@@ -30,43 +30,86 @@ type User struct {
 	Role     int    `bson:"Role"`
 }
 
+// Users holds the public visitor accounts. A visitor session only says who
+// the visitor is: modules that serve visitors, such as event RSVPs, ask
+// Caller for it. It grants no permissions, so everything a visitor may do
+// besides that is open to guests anyway.
 type Users struct {
-	users          []*User
+	users          *store.Cache[User]
 	globalSessions *session.Manager
-	db             *mongo.Database
+	store          store.Store[User]
+	// mutex serializes writes, so two sign ups with one email cannot both
+	// pass the duplicate check
+	mutex sync.Mutex
 }
 
-func NewUsers(db *mongo.Database, globalSessions *session.Manager) *Users {
-	users := make([]*User, 0)
-	col := db.Collection(userCollection)
-	result, err := col.Find(context.TODO(), bson.M{})
+func NewUsers(records store.Store[User], globalSessions *session.Manager) *Users {
+	users, err := records.All()
 	if err != nil {
-		log.Fatal(err.Error())
-	} else {
-		if err = result.All(context.TODO(), &users); err != nil {
-			log.Fatal("error loading users data " + err.Error())
-		}
+		log.Fatal("error loading users data " + err.Error())
 	}
-	return &Users{users: users, globalSessions: globalSessions, db: db}
+	cache := store.NewCache(users, func(user *User) string { return user.Id })
+	cache.Follow("users", records)
+	return &Users{users: cache, globalSessions: globalSessions, store: records}
 }
 
 func (users *Users) login(username, userpassword string) (*User, error) {
-	for _, user := range users.users {
+	for _, user := range users.users.All() {
 		if strings.EqualFold(username, user.Name) || strings.EqualFold(username, user.Email) {
 			err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(userpassword))
 			if err != nil {
-				return nil, fmt.Errorf("wrong password provided")
+				return nil, response.Errorf(http.StatusUnauthorized, "wrong password provided")
 			}
 			return user, nil
 		}
 	}
-	return nil, fmt.Errorf("account does not exist")
+	return nil, response.Errorf(http.StatusUnauthorized, "account does not exist")
+}
+
+// Find returns the visitor account with the given Id or nil.
+func (users *Users) Find(id string) *User {
+	return users.users.Get(id)
 }
 
+// Caller returns the active visitor logged in on r, for modules that
+// recognise visitors as well as members.
+func (users *Users) Caller(r *http.Request) (UserView, bool) {
+	c, err := r.Cookie(os.Getenv("User_Session_Cookie"))
+	if err != nil {
+		return UserView{}, false
+	}
+	sess, err := users.globalSessions.GetProvider().SessionRead(c.Value)
+	if err != nil {
+		return UserView{}, false
+	}
+	useremail, _ := sess.Get("useremail").(string)
+	for _, user := range users.users.All() {
+		if len(useremail) != 0 && strings.EqualFold(user.Email, useremail) && user.Active {
+			return user.View(), true
+		}
+	}
+	return UserView{}, false
+}
+
+// Register signs up a visitor. An email and a password are required, since
+// an account without them could never be logged in to.
 func (users *Users) Register(usr *User) (*User, error) {
-	for _, user := range users.users {
+	usr.Email = strings.TrimSpace(usr.Email)
+	invalid := map[string]string{}
+	if _, err := mail.ParseAddress(usr.Email); err != nil {
+		invalid["Email"] = "not a valid address"
+	}
+	if len(usr.Password) == 0 {
+		invalid["Password"] = "required"
+	}
+	if len(invalid) != 0 {
+		return nil, response.Invalid("an email and a password are required", invalid)
+	}
+	users.mutex.Lock()
+	defer users.mutex.Unlock()
+	for _, user := range users.users.All() {
 		if strings.EqualFold(user.Email, usr.Email) {
-			return nil, fmt.Errorf("user account already exists")
+			return nil, response.Errorf(http.StatusConflict, "user account already exists")
 		}
 	}
 	hash, err := bcrypt.GenerateFromPassword([]byte(usr.Password), bcrypt.DefaultCost)
@@ -74,174 +117,162 @@ func (users *Users) Register(usr *User) (*User, error) {
 		return nil, fmt.Errorf("error processing user password")
 	}
 	usr.Password = string(hash)
-	bsonData, err := bson.Marshal(usr)
-	if err != nil {
-		return nil, fmt.Errorf("error processing user details")
-	}
-	col := users.db.Collection(userCollection)
-	_, err = col.InsertOne(context.TODO(), bsonData)
+	err = users.store.Insert(usr)
 	if err != nil {
 		return nil, fmt.Errorf("error registering user")
 	}
-	go func() {
-		users.users = append(users.users, usr)
-	}()
+	saved := *usr
+	users.users.Put(&saved)
 	return usr, nil
 }
 
 func (users *Users) delete(usr *User) (*User, error) {
-	for x, user := range users.users {
+	users.mutex.Lock()
+	defer users.mutex.Unlock()
+	for _, user := range users.users.All() {
 		if strings.EqualFold(user.Email, usr.Email) && strings.EqualFold(usr.Id, user.Id) {
-			bsonData, err := bson.Marshal(usr)
-			if err != nil {
-				return nil, fmt.Errorf("error processing user details")
-			}
-			col := users.db.Collection(userCollection)
-			_, err = col.DeleteOne(context.TODO(), bsonData)
+			err := users.store.Delete(user.Id)
 			if err != nil {
 				return nil, fmt.Errorf("error deleting user")
 			}
-			go func() {
-				users.users = append(users.users[:x], users.users[x+1:]...)
-			}()
+			users.users.Remove(user.Id)
 			return user, nil
 		}
 	}
-	return nil, fmt.Errorf("user account does not exists")
+	return nil, response.Errorf(http.StatusNotFound, "user account does not exists")
 }
 
 func (users *Users) update(update map[string]interface{}) (*User, error) {
-	var usr User
-	var fields []string
-	rv := reflect.ValueOf(usr)
-	for key, value := range update {
-		field := rv.FieldByName(key)
-		if field.IsValid() && field.CanSet() {
-			val := reflect.ValueOf(value)
-			if field.Type() == val.Type() {
-				field.Set(val)
-			} else {
-				return nil, fmt.Errorf("update field with type mismatch %v ", key)
+	users.mutex.Lock()
+	defer users.mutex.Unlock()
+	id, _ := update["Id"].(string)
+	email, _ := update["Email"].(string)
+	for _, user := range users.users.All() {
+		if strings.EqualFold(user.Email, email) && strings.EqualFold(user.Id, id) {
+			usr := *user
+			set, err := store.Apply(&usr, update)
+			if err != nil {
+				return nil, err
 			}
-			fields = append(fields, key)
-		}
-	}
-	req := 0
-	for i, v := range fields {
-		if strings.EqualFold(v, "Id") || strings.EqualFold(v, "Email") {
-			req += 1
-		}
-		if i == len(fields)-1 && req != 2 {
-			return nil, fmt.Errorf("update missing important fields")
-		}
-	}
-	for _, user := range users.users {
-		if strings.EqualFold(user.Email, usr.Email) && strings.EqualFold(user.Id, usr.Id) {
-			if usr.Password != "" {
+			if _, ok := set["Password"]; ok {
 				hash, err := bcrypt.GenerateFromPassword([]byte(usr.Password), bcrypt.DefaultCost)
 				if err != nil {
 					return nil, fmt.Errorf("error processing user password")
 				}
 				usr.Password = string(hash)
+				set["Password"] = usr.Password
 			}
-			col := users.db.Collection(userCollection)
-			_, err := col.UpdateByID(context.TODO(), usr.Id, usr)
+			err = users.store.Update(usr.Id, set)
 			if err != nil {
 				return nil, fmt.Errorf("error updating user")
 			}
+			saved := usr
+			users.users.Put(&saved)
 			return &usr, nil
 		}
 	}
-	return nil, fmt.Errorf("user account does not exists")
+	return nil, response.Errorf(http.StatusNotFound, "user account does not exists")
 }
 
 func (users *Users) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if strings.EqualFold(r.URL.Path, "/login") {
+	if strings.EqualFold(r.URL.Path, "/user/login") {
 		var credentials struct {
 			NameEmail string
 			Password  string
 		}
-		err := json.NewDecoder(r.Body).Decode(&credentials)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+		if !response.Decode(w, r, &credentials) {
 			return
 		}
 		user, err := users.login(credentials.NameEmail, credentials.Password)
 		if err != nil {
-			res := struct{ Error string }{Error: err.Error()}
-			json.NewEncoder(w).Encode(res)
+			response.Fail(w, err)
+			return
+		}
+		if !user.Active {
+			response.Failf(w, http.StatusForbidden, "visitor account has been deactivated")
 			return
 		}
 		sess, err := users.globalSessions.SessionStart(w, r)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			response.Fail(w, err)
 			return
 		}
 		defer sess.SessionRelease(w)
 		sess.Set("useremail", user.Email)
-		http.SetCookie(w, &http.Cookie{Name: os.Getenv("Session_Cookie"), Value: sess.SessionID(), Path: "/", HttpOnly: false, Secure: true})
-		json.NewEncoder(w).Encode(user)
+		http.SetCookie(w, &http.Cookie{Name: os.Getenv("User_Session_Cookie"), Value: sess.SessionID(), Path: "/", HttpOnly: false, Secure: true})
+		response.OK(w, user.View())
 		return
-	} else if strings.EqualFold(r.URL.Path, "/logout") {
+	} else if strings.EqualFold(r.URL.Path, "/user/logout") {
 		users.globalSessions.SessionDestroy(w, r)
+	} else if strings.EqualFold(r.URL.Path, "/user/me") {
+		user, ok := users.Caller(r)
+		if !ok {
+			response.Failf(w, http.StatusUnauthorized, "not logged in as a visitor")
+			return
+		}
+		response.OK(w, user)
+		return
 	} else if strings.EqualFold(r.URL.Path, "/user") {
 		switch r.Method {
 		case http.MethodPost:
 			{
 				var newUser User
-				err := json.NewDecoder(r.Body).Decode(&newUser)
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
+				if !response.Decode(w, r, &newUser) {
 					return
 				}
-				newUser.Id=uuid.NewString()
+				newUser.Id = uuid.NewString()
+				newUser.Active = true
+				newUser.Premium = false
+				newUser.Role = 0
 				u, err := users.Register(&newUser)
 				if err != nil {
-					json.NewEncoder(w).Encode(fmt.Sprintf("{error: %s}", err.Error()))
+					response.Fail(w, err)
 					return
 				}
-				json.NewEncoder(w).Encode(u)
+				response.OK(w, u.View())
 				return
 			}
 		case http.MethodGet:
 			{
-				if len(users.users) == 0 {
-					json.NewEncoder(w).Encode("{error: no users found}")
-					return
+				fields := store.Fields(r.URL.Query())
+				result := make([]interface{}, 0)
+				for _, u := range users.users.All() {
+					view, err := store.Select(u.View(), fields)
+					if err != nil {
+						response.Failf(w, http.StatusBadRequest, "%s", err)
+						return
+					}
+					result = append(result, view)
 				}
-				json.NewEncoder(w).Encode(fmt.Sprintf("{users: %v}", users.users))
+				response.OK(w, result)
 				return
 			}
 		case http.MethodDelete:
 			{
 				var newUser User
-				err := json.NewDecoder(r.Body).Decode(&newUser)
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
+				if !response.Decode(w, r, &newUser) {
 					return
 				}
 				u, err := users.delete(&newUser)
 				if err != nil {
-					json.NewEncoder(w).Encode(fmt.Sprintf("{error: %s}", err.Error()))
+					response.Fail(w, err)
 					return
 				}
-				json.NewEncoder(w).Encode(u)
+				response.OK(w, u.View())
 				return
 			}
 		case http.MethodPut:
 			{
 				updateUser := make(map[string]interface{}, 0)
-				err := json.NewDecoder(r.Body).Decode(&updateUser)
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
+				if !response.Decode(w, r, &updateUser) {
 					return
 				}
 				u, err := users.update(updateUser)
 				if err != nil {
-					json.NewEncoder(w).Encode(fmt.Sprintf("{error: %s}", err.Error()))
+					response.Fail(w, err)
 					return
 				}
-				json.NewEncoder(w).Encode(u)
+				response.OK(w, u.View())
 				return
 
 			}
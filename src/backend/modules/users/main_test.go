@@ -0,0 +1,70 @@
+package users
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"example.com/store"
+	"github.com/astaxie/beego/session"
+)
+
+// serve sends a JSON request with cookie to users and decodes the reply
+// into v.
+func serve(t *testing.T, users *Users, cookie *http.Cookie, method, target, body string, v interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	users.ServeHTTP(rec, req)
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: decoding %q: %s", method, target, rec.Body.String(), err)
+		}
+	}
+	return rec
+}
+
+func TestVisitorSignUpAndSession(t *testing.T) {
+	os.Setenv("User_Session_Cookie", "visitor")
+	sessions, err := session.NewManager("memory", &session.ManagerConfig{CookieName: "visitor", Gclifetime: 3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := NewUsers(store.NewMemory[User]("Id"), sessions)
+
+	for _, body := range []string{`{"Name":"Atieno","Password":"password"}`, `{"Name":"Atieno","Email":"atieno@example.com"}`, `{"Email":"not an address","Password":"password"}`} {
+		if rec := serve(t, users, nil, http.MethodPost, "/user", body, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("POST /user %s = %d; want 400", body, rec.Code)
+		}
+	}
+	if rec := serve(t, users, nil, http.MethodPost, "/user", `{"Name":"Atieno","Email":"atieno@example.com","Password":"password"}`, nil); rec.Code != http.StatusOK {
+		t.Fatalf("POST /user = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := serve(t, users, nil, http.MethodGet, "/user/me", "", nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("GET /user/me without a session = %d; want 401", rec.Code)
+	}
+
+	rec := serve(t, users, nil, http.MethodPost, "/user/login", `{"NameEmail":"atieno@example.com","Password":"password"}`, nil)
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "visitor" {
+			cookie = c
+		}
+	}
+	if rec.Code != http.StatusOK || cookie == nil {
+		t.Fatalf("POST /user/login = %d %s", rec.Code, rec.Body.String())
+	}
+	var me map[string]interface{}
+	if rec := serve(t, users, cookie, http.MethodGet, "/user/me", "", &me); rec.Code != http.StatusOK || me["Email"] != "atieno@example.com" {
+		t.Fatalf("GET /user/me = %d %v", rec.Code, me)
+	}
+	if _, ok := me["Password"]; ok {
+		t.Fatalf("a password hash left the server: %v", me)
+	}
+}
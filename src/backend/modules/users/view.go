@@ -0,0 +1,18 @@
+package users
+
+// UserView is a visitor account less the password hash. Only admins list
+// accounts, and visitors only ever see their own.
+type UserView struct {
+	Id       string
+	Name     string
+	Email    string
+	Active   bool
+	Premium  bool
+	Passport string
+	Role     int
+}
+
+// View is the account without secrets.
+func (user User) View() UserView {
+	return UserView{Id: user.Id, Name: user.Name, Email: user.Email, Active: user.Active, Premium: user.Premium, Passport: user.Passport, Role: user.Role}
+}
@@ -2,10 +2,16 @@ package main
 
 import (
 	"crypto/tls"
+	"encoding/json"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -13,6 +19,7 @@ import (
 var (
 	tpl       *template.Template
 	tlsConfig *tls.Config
+	backend   *http.Client
 )
 
 func init() {
@@ -29,6 +36,27 @@ func init() {
 		Certificates:       []tls.Certificate{certificate},
 		InsecureSkipVerify: true,
 	}
+	backend = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+}
+
+// fetch loads a JSON document from the backend on behalf of the browser,
+// forwarding its cookies so the backend sees the same session.
+func fetch(r *http.Request, path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, os.Getenv("Backend_URL")+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Cookie", r.Header.Get("Cookie"))
+	res, err := backend.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("backend responded %s", res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
 }
 
 type Page struct {
@@ -43,8 +71,121 @@ func RenderTemplate(w http.ResponseWriter, file string, page *Page) {
 	}
 }
 
+type Occurrence struct {
+	Event struct {
+		Id          string
+		Title       string
+		Description string
+		Location    string
+	}
+	Start string
+	End   string
+}
+
+type Announcement struct {
+	Id       string
+	Title    string
+	Body     string
+	District string
+	Group    string
+	Publish  string
+	Expire   string
+}
+
+type Home struct {
+	Events        []Occurrence
+	Announcements []Announcement
+}
+
+// IndexHandler renders the home page with the running announcements and the
+// events of the coming weeks. The page still renders when the backend cannot
+// be reached.
 func IndexHandler(w http.ResponseWriter, r *http.Request) {
-	RenderTemplate(w, "index.html", &Page{Title: "Home", Data: nil})
+	var home Home
+	if err := fetch(r, "/event/upcoming?days=30", &home.Events); err != nil {
+		log.Println("upcoming events:", err)
+	}
+	if err := fetch(r, "/announcement/live", &home.Announcements); err != nil {
+		log.Println("announcements:", err)
+	}
+	RenderTemplate(w, "index.html", &Page{Title: "Home", Data: home})
+}
+
+func AnnouncementsHandler(w http.ResponseWriter, r *http.Request) {
+	RenderTemplate(w, "announcements.html", &Page{Title: "Announcements", Data: nil})
+}
+
+type Bulletin struct {
+	Date          string
+	Announcements []Announcement
+}
+
+// BulletinHandler prints the announcements running on a Sunday as HTML, or
+// as PDF when format=pdf is requested.
+func BulletinHandler(w http.ResponseWriter, r *http.Request) {
+	var bulletin Bulletin
+	err := fetch(r, "/announcement/bulletin?date="+url.QueryEscape(r.URL.Query().Get("date")), &bulletin)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	title := "Bulletin for Sunday " + bulletin.Date
+	if r.URL.Query().Get("format") == "pdf" {
+		lines := []string{"PCEA Elijah Wathika Memorial Church", ""}
+		for _, announcement := range bulletin.Announcements {
+			lines = append(lines, strings.ToUpper(announcement.Title))
+			lines = append(lines, strings.Split(announcement.Body, "\n")...)
+			lines = append(lines, "")
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", "bulletin-"+bulletin.Date+".pdf"))
+		if err := WritePDF(w, title, lines); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	RenderTemplate(w, "bulletin.html", &Page{Title: title, Data: bulletin})
+}
+
+type Listing struct {
+	Sermon struct {
+		Id        string
+		Title     string
+		Date      string
+		Scripture string
+		Series    string
+		Notes     string
+		Media     string
+		MediaType string
+	}
+	Preacher string
+}
+
+// Video tells the template to use a video player rather than an audio one.
+func (listing Listing) Video() bool {
+	return strings.HasPrefix(listing.Sermon.MediaType, "video/")
+}
+
+// SermonsHandler renders the public sermon archive, optionally narrowed to
+// one series.
+func SermonsHandler(w http.ResponseWriter, r *http.Request) {
+	var listings []Listing
+	if err := fetch(r, "/sermon?series="+url.QueryEscape(r.URL.Query().Get("series")), &listings); err != nil {
+		log.Println("sermons:", err)
+	}
+	RenderTemplate(w, "sermons.html", &Page{Title: "Sermons", Data: listings})
+}
+
+func InboxHandler(w http.ResponseWriter, r *http.Request) {
+	RenderTemplate(w, "inbox.html", &Page{Title: "Inbox", Data: nil})
+}
+
+func SMSHandler(w http.ResponseWriter, r *http.Request) {
+	RenderTemplate(w, "sms.html", &Page{Title: "SMS", Data: nil})
+}
+
+func EventsHandler(w http.ResponseWriter, r *http.Request) {
+	RenderTemplate(w, "events.html", &Page{Title: "Events", Data: nil})
 }
 
 func ServicesHandler(w http.ResponseWriter, r *http.Request) {
@@ -63,6 +204,23 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	RenderTemplate(w, "login.html", &Page{Title: "Login", Data: nil})
 }
 
+// ForgotHandler asks for the address to mail a password reset link to.
+func ForgotHandler(w http.ResponseWriter, r *http.Request) {
+	RenderTemplate(w, "forgot.html", &Page{Title: "Forgot password", Data: nil})
+}
+
+// ResetHandler is where reset links land; the token from the link is
+// posted back with the new password.
+func ResetHandler(w http.ResponseWriter, r *http.Request) {
+	RenderTemplate(w, "reset.html", &Page{Title: "Reset password", Data: r.URL.Query().Get("token")})
+}
+
+// VerifyHandler is where verification links land; the page confirms the
+// token as soon as it loads.
+func VerifyHandler(w http.ResponseWriter, r *http.Request) {
+	RenderTemplate(w, "verify.html", &Page{Title: "Confirm email", Data: r.URL.Query().Get("token")})
+}
+
 func SignupHandler(w http.ResponseWriter, r *http.Request) {
 	RenderTemplate(w, "register.html", &Page{Title: "Login", Data: nil})
 }
@@ -79,6 +237,163 @@ func DistrictsHandler(w http.ResponseWriter, r *http.Request) {
 	RenderTemplate(w, "districts.html", &Page{Title: "Districts", Data: nil})
 }
 
+func HouseholdsHandler(w http.ResponseWriter, r *http.Request) {
+	RenderTemplate(w, "households.html", &Page{Title: "Households", Data: nil})
+}
+
+func SacramentsHandler(w http.ResponseWriter, r *http.Request) {
+	RenderTemplate(w, "sacraments.html", &Page{Title: "Sacraments", Data: nil})
+}
+
+func AttendanceHandler(w http.ResponseWriter, r *http.Request) {
+	RenderTemplate(w, "attendance.html", &Page{Title: "Attendance", Data: nil})
+}
+
+type Certificate struct {
+	Sacrament struct {
+		Id             string
+		Kind           string
+		RegisterNumber int
+		Date           string
+		Minister       string
+		Location       string
+		Notes          string
+	}
+	Member    string
+	Partner   string
+	Witnesses []string
+}
+
+// CertificateHandler prints a sacramental record as HTML, or as PDF when
+// format=pdf is requested.
+func CertificateHandler(w http.ResponseWriter, r *http.Request) {
+	var certificate Certificate
+	err := fetch(r, "/sacrament/certificate?id="+url.QueryEscape(r.URL.Query().Get("id")), &certificate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	title := "Certificate of " + certificate.Sacrament.Kind
+	if r.URL.Query().Get("format") == "pdf" {
+		lines := []string{
+			"PCEA Elijah Wathika Memorial Church",
+			fmt.Sprintf("Register No. %d", certificate.Sacrament.RegisterNumber),
+			"",
+			"This is to certify that " + certificate.Member,
+		}
+		if len(certificate.Partner) != 0 {
+			lines = append(lines, "and "+certificate.Partner)
+		}
+		lines = append(lines,
+			"received the sacrament of "+certificate.Sacrament.Kind,
+			"on "+certificate.Sacrament.Date+" at "+certificate.Sacrament.Location,
+			"officiated by "+certificate.Sacrament.Minister,
+		)
+		if len(certificate.Witnesses) != 0 {
+			lines = append(lines, "in the presence of "+strings.Join(certificate.Witnesses, ", "))
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", certificate.Sacrament.Kind+".pdf"))
+		if err := WritePDF(w, title, lines); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	RenderTemplate(w, "certificate.html", &Page{Title: title, Data: certificate})
+}
+
+func GivingHandler(w http.ResponseWriter, r *http.Request) {
+	RenderTemplate(w, "giving.html", &Page{Title: "Giving", Data: nil})
+}
+
+// Cents is a shilling amount read exactly from the decimal the backend sends,
+// so statement totals never show floating point drift.
+type Cents int64
+
+func (cents *Cents) UnmarshalJSON(data []byte) error {
+	amount := string(data)
+	negative := strings.HasPrefix(amount, "-")
+	whole, fraction, _ := strings.Cut(strings.TrimPrefix(amount, "-"), ".")
+	if len(fraction) > 2 {
+		return fmt.Errorf("amount %s has more than two decimals", amount)
+	}
+	value, err := strconv.ParseInt(whole+(fraction + "00")[:2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("amount %s is not a number", amount)
+	}
+	if negative {
+		value = -value
+	}
+	*cents = Cents(value)
+	return nil
+}
+
+func (cents Cents) String() string {
+	sign, value := "", int64(cents)
+	if value < 0 {
+		sign, value = "-", -value
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, value/100, value%100)
+}
+
+type Statement struct {
+	Member struct {
+		Id    string
+		Name  string
+		Email string
+	}
+	Year    int
+	Entries []struct {
+		Date      string
+		Amount    Cents
+		Category  string
+		Method    string
+		Reference string
+	}
+	Totals map[string]Cents
+	Total  Cents
+}
+
+// StatementHandler prints a member's annual giving statement as HTML, or as
+// PDF when format=pdf is requested.
+func StatementHandler(w http.ResponseWriter, r *http.Request) {
+	var statement Statement
+	query := url.Values{"member": {r.URL.Query().Get("member")}, "year": {r.URL.Query().Get("year")}}
+	err := fetch(r, "/giving/statement?"+query.Encode(), &statement)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	title := fmt.Sprintf("Giving statement %d", statement.Year)
+	if r.URL.Query().Get("format") == "pdf" {
+		lines := []string{
+			"PCEA Elijah Wathika Memorial Church",
+			statement.Member.Name + " " + statement.Member.Email,
+			"",
+		}
+		for _, entry := range statement.Entries {
+			lines = append(lines, strings.Join([]string{entry.Date, entry.Category, entry.Method, entry.Reference, entry.Amount.String()}, "  "))
+		}
+		lines = append(lines, "")
+		categories := make([]string, 0, len(statement.Totals))
+		for category := range statement.Totals {
+			categories = append(categories, category)
+		}
+		sort.Strings(categories)
+		for _, category := range categories {
+			lines = append(lines, fmt.Sprintf("%s  %s", category, statement.Totals[category]))
+		}
+		lines = append(lines, fmt.Sprintf("Total  %s", statement.Total))
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", fmt.Sprintf("giving-%d.pdf", statement.Year)))
+		if err := WritePDF(w, title, lines); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	RenderTemplate(w, "statement.html", &Page{Title: title, Data: statement})
+}
+
 func middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -100,9 +415,24 @@ func main() {
 	router.HandleFunc("/contacts", ContactsHandler)
 	router.HandleFunc("/login", LoginHandler)
 	router.HandleFunc("/signup", SignupHandler)
+	router.HandleFunc("/forgot", ForgotHandler)
+	router.HandleFunc("/reset", ResetHandler)
+	router.HandleFunc("/verify", VerifyHandler)
 	router.HandleFunc("/members", MembersHandler)
 	router.HandleFunc("/groups", GroupsHandler)
 	router.HandleFunc("/districts", DistrictsHandler)
+	router.HandleFunc("/households", HouseholdsHandler)
+	router.HandleFunc("/sacraments", SacramentsHandler)
+	router.HandleFunc("/certificate", CertificateHandler)
+	router.HandleFunc("/attendance", AttendanceHandler)
+	router.HandleFunc("/inbox", InboxHandler)
+	router.HandleFunc("/sms", SMSHandler)
+	router.HandleFunc("/events", EventsHandler)
+	router.HandleFunc("/sermons", SermonsHandler)
+	router.HandleFunc("/announcements", AnnouncementsHandler)
+	router.HandleFunc("/bulletin", BulletinHandler)
+	router.HandleFunc("/giving", GivingHandler)
+	router.HandleFunc("/statement", StatementHandler)
 	router.Handle("/assets/", http.StripPrefix("/assets/", http.FileServer(http.Dir("./assets"))))
 
 	//Redirect unknown path to home
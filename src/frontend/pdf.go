@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// textWidth is the width of an A4 page inside its one inch margins, in
+// points.
+const textWidth = 595 - 2*72
+
+// helvetica holds the widths of the printable ASCII characters in Helvetica,
+// in thousandths of the font size, from space to tilde.
+var helvetica = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// measure returns the width of s set in Helvetica at size points. Characters
+// outside ASCII are measured as the ? pdfEscape prints for them.
+func measure(s string, size int) int {
+	width := 0
+	for _, r := range s {
+		if r < 32 || r > 126 {
+			r = '?'
+		}
+		width += helvetica[r-32]
+	}
+	return width * size / 1000
+}
+
+// wrap breaks line into lines no wider than the page at size points,
+// between words where it can. A word wider than the page is cut.
+func wrap(line string, size int) []string {
+	result := make([]string, 0, 1)
+	current := ""
+	for _, word := range strings.Fields(line) {
+		candidate := word
+		if len(current) != 0 {
+			candidate = current + " " + word
+		}
+		if measure(candidate, size) <= textWidth {
+			current = candidate
+			continue
+		}
+		if len(current) != 0 {
+			result = append(result, current)
+		}
+		current = ""
+		for _, r := range word {
+			if len(current) != 0 && measure(current+string(r), size) > textWidth {
+				result = append(result, current)
+				current = ""
+			}
+			current += string(r)
+		}
+	}
+	return append(result, current)
+}
+
+// WritePDF writes an A4 PDF with a title and lines of text in Helvetica,
+// wrapping lines wider than the page and starting a new page whenever the
+// current one fills. It covers the printable certificates, statements and
+// bulletins without pulling a PDF library into the frontend.
+func WritePDF(w io.Writer, title string, lines []string) error {
+	pages := make([]bytes.Buffer, 1)
+	y := 770
+	for _, line := range wrap(title, 20) {
+		fmt.Fprintf(&pages[0], "BT /F1 20 Tf 72 %d Td (%s) Tj ET\n", y, pdfEscape(line))
+		y -= 24
+	}
+	y -= 16
+	for _, text := range lines {
+		for _, line := range wrap(text, 12) {
+			if y < 72 {
+				pages = append(pages, bytes.Buffer{})
+				y = 770
+			}
+			fmt.Fprintf(&pages[len(pages)-1], "BT /F1 12 Tf 72 %d Td (%s) Tj ET\n", y, pdfEscape(line))
+			y -= 18
+		}
+	}
+	// Objects 1-3 are the catalog, page tree and font; each page then takes a
+	// page object followed by its content stream.
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+	}
+	for i, content := range pages {
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", 5+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+		)
+	}
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// pdfEscape quotes a string for a PDF literal and drops characters the
+// standard fonts cannot show.
+func pdfEscape(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`).Replace(s)
+	return strings.Map(func(r rune) rune {
+		if r < 32 || r > 126 {
+			return '?'
+		}
+		return r
+	}, s)
+}